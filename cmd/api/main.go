@@ -2,20 +2,28 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"twitter-clone/internal/config"
+	"twitter-clone/internal/messaging"
 	server "twitter-clone/internal/server/api"
+	"twitter-clone/internal/server/relay"
+	"twitter-clone/internal/snowflake"
 
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	app "twitter-clone/internal/app/twitter"
 
 	redis_cache "twitter-clone/internal/cache"
 
+	inmemory_db "twitter-clone/internal/database/inmemory"
 	postgres_db "twitter-clone/internal/database/postgres"
+	sqlite_db "twitter-clone/internal/database/sqlite"
+	"twitter-clone/internal/domain/database"
 
 	"twitter-clone/internal/server/metrics"
 
@@ -48,6 +56,9 @@ func main() {
 			},
 		},
 		Action: runServer,
+		Commands: []*cli.Command{
+			migrateCommand,
+		},
 	}
 	if err := app.Run(os.Args); err != nil {
 		log.Fatal().Msg(err.Error())
@@ -58,7 +69,8 @@ func runServer(cCtx *cli.Context) error {
 	var (
 		err        error
 		configYaml *config.YamlConfig
-		database   *postgres_db.PostgresDB
+		database   database.DatabaseI
+		ids        *snowflake.Generator
 	)
 
 	signalCtx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
@@ -69,11 +81,20 @@ func runServer(cCtx *cli.Context) error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
-	if database, err = postgres_db.NewPostgresDB(configYaml); err != nil {
+	if ids, err = snowflake.NewGenerator(configYaml.TweetNodeID()); err != nil {
+		return fmt.Errorf("failed to create tweet id generator: %w", err)
+	}
+	if database, err = newDatabase(signalCtx, configYaml); err != nil {
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
 	cache := redis_cache.NewRedisCache(configYaml)
-	twitterService := app.NewTweeterService(database, cache)
+	messageBus := messaging.NewRedis(configYaml, time.Duration(configYaml.WorkerClaimIdleSeconds())*time.Second)
+	defer func() {
+		_ = messageBus.Close() // lint issue
+	}()
+	twitterService := app.NewTweeterService(database, cache, ids, configYaml, configYaml)
+	// tweets and follows reach the cache and the workers through the outbox
+	outboxRelay := relay.NewRelay(database, cache, messageBus, configYaml)
 	server := server.NewServerV1(twitterService, configYaml)
 	debugServer := metrics.NewMetricsServer(configYaml)
 
@@ -85,6 +106,12 @@ func runServer(cCtx *cli.Context) error {
 
 	}()
 
+	go func() {
+		if err := outboxRelay.Start(signalCtx); err != nil && !errors.Is(err, context.Canceled) {
+			log.Error().Err(err).Msg("Outbox relay stopped")
+		}
+	}()
+
 	go func() {
 		log.Info().Msgf("Starting data server: %s \n", debugServer.Info())
 		if err := debugServer.Start(); err != nil && err != http.ErrServerClosed {
@@ -104,3 +131,16 @@ func runServer(cCtx *cli.Context) error {
 
 	return nil
 }
+
+func newDatabase(ctx context.Context, configYaml *config.YamlConfig) (database.DatabaseI, error) {
+	switch configYaml.DatabaseDriver() {
+	case "memory":
+		return inmemory_db.NewInMemoryDB(), nil
+	case "sqlite":
+		return sqlite_db.NewSQLiteDB(ctx, configYaml)
+	case "", "postgres":
+		return postgres_db.NewPostgresDB(configYaml)
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", configYaml.DatabaseDriver())
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+	"twitter-clone/internal/config"
+
+	"github.com/pressly/goose/v3"
+	"github.com/urfave/cli/v2"
+
+	postgres_db "twitter-clone/internal/database/postgres"
+	sqlite_db "twitter-clone/internal/database/sqlite"
+)
+
+// the schema migrations embedded into the binary, they run on the database of the config
+var migrateCommand = &cli.Command{
+	Name:  "migrate",
+	Usage: "Manage the database schema",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "up",
+			Usage:  "Apply all the pending migrations",
+			Action: migrateUp,
+		},
+		{
+			Name:   "down",
+			Usage:  "Roll back the last applied migration",
+			Action: migrateDown,
+		},
+		{
+			Name:   "status",
+			Usage:  "Print the migrations and whether they are applied",
+			Action: migrateStatus,
+		},
+	},
+}
+
+func newMigrator(cCtx *cli.Context) (*goose.Provider, error) {
+	configYaml, err := config.NewYamlConfig(cCtx.String("config"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	switch configYaml.DatabaseDriver() {
+	case "sqlite":
+		return sqlite_db.NewMigrator(configYaml)
+	case "", "postgres":
+		return postgres_db.NewMigrator(configYaml)
+	default:
+		return nil, fmt.Errorf("database driver %q has no migrations", configYaml.DatabaseDriver())
+	}
+}
+
+func migrateUp(cCtx *cli.Context) error {
+	migrator, err := newMigrator(cCtx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = migrator.Close() // lint
+	}()
+
+	results, err := migrator.Up(cCtx.Context)
+	for _, result := range results {
+		fmt.Printf("Applied %s in %v\n", result.Source.Path, result.Duration)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply migrations: %w", err)
+	}
+	if len(results) == 0 {
+		fmt.Println("Nothing to apply, the schema is up to date")
+	}
+	return nil
+}
+
+func migrateDown(cCtx *cli.Context) error {
+	migrator, err := newMigrator(cCtx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = migrator.Close() // lint
+	}()
+
+	result, err := migrator.Down(cCtx.Context)
+	if errors.Is(err, goose.ErrNoNextVersion) {
+		fmt.Println("Nothing to roll back")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to roll back migration: %w", err)
+	}
+	fmt.Printf("Rolled back %s in %v\n", result.Source.Path, result.Duration)
+	return nil
+}
+
+func migrateStatus(cCtx *cli.Context) error {
+	migrator, err := newMigrator(cCtx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = migrator.Close() // lint
+	}()
+
+	statuses, err := migrator.Status(cCtx.Context)
+	if err != nil {
+		return fmt.Errorf("failed to get migrations status: %w", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tSTATE\tAPPLIED AT\tMIGRATION")
+	for _, status := range statuses {
+		appliedAt := "-"
+		if status.State == goose.StateApplied {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.Source.Version, status.State, appliedAt, status.Source.Path)
+	}
+	return w.Flush()
+}
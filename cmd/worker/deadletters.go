@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+	"twitter-clone/internal/config"
+	"twitter-clone/internal/messaging"
+	"twitter-clone/internal/server/worker"
+
+	"github.com/urfave/cli/v2"
+
+	redis_cache "twitter-clone/internal/cache"
+)
+
+// tweets the worker failed to deliver even after the retries,
+// they are kept until they are replayed
+var deadLettersCommand = &cli.Command{
+	Name:  "deadletters",
+	Usage: "Inspect and replay failed deliveries",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "list",
+			Usage:  "Print the failed deliveries, oldest first",
+			Action: listDeadLetters,
+		},
+		{
+			Name:   "replay",
+			Usage:  "Deliver the failed tweets again, the delivered ones are removed",
+			Action: replayDeadLetters,
+		},
+	},
+}
+
+func listDeadLetters(cCtx *cli.Context) error {
+	configYaml, err := config.NewYamlConfig(cCtx.String("config"))
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	cache := redis_cache.NewRedisCache(configYaml)
+	defer func() {
+		_ = cache.Close() // lint
+	}()
+
+	letters, err := cache.GetDeadLetters(cCtx.Context)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tTWEET\tFAILED AT\tERROR")
+	for _, letter := range letters {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\n", letter.UserID, letter.Tweet.ID, letter.FailedAt.Format(time.RFC3339), letter.Error)
+	}
+	return w.Flush()
+}
+
+func replayDeadLetters(cCtx *cli.Context) error {
+	configYaml, err := config.NewYamlConfig(cCtx.String("config"))
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	cache := redis_cache.NewRedisCache(configYaml)
+	defer func() {
+		_ = cache.Close() // lint
+	}()
+
+	db, err := newDatabase(cCtx.Context, configYaml)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	messageBus := messaging.NewRedis(configYaml, time.Duration(configYaml.WorkerClaimIdleSeconds())*time.Second)
+	defer func() {
+		_ = messageBus.Close() // lint
+	}()
+
+	replayed, err := worker.NewWorker(cache, db, messageBus, configYaml, configYaml).ReplayDeadLetters(cCtx.Context)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Replayed %d dead letters\n", replayed)
+	return nil
+}
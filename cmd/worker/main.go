@@ -7,7 +7,10 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 	"twitter-clone/internal/config"
+	"twitter-clone/internal/domain/database"
+	"twitter-clone/internal/messaging"
 	"twitter-clone/internal/server/metrics"
 	"twitter-clone/internal/server/worker"
 
@@ -15,6 +18,8 @@ import (
 	"github.com/urfave/cli/v2"
 
 	redis_cache "twitter-clone/internal/cache"
+	postgres_db "twitter-clone/internal/database/postgres"
+	sqlite_db "twitter-clone/internal/database/sqlite"
 )
 
 // The idea is that worker will check the Redis global queue and on new item
@@ -45,6 +50,9 @@ func main() {
 			},
 		},
 		Action: runWorker,
+		Commands: []*cli.Command{
+			deadLettersCommand,
+		},
 	}
 	if err := app.Run(os.Args); err != nil {
 		log.Fatal().Msg(err.Error())
@@ -65,9 +73,17 @@ func runWorker(cCtx *cli.Context) error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
+	db, err := newDatabase(signalCtx, configYaml)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
 	cache := redis_cache.NewRedisCache(configYaml)
+	messageBus := messaging.NewRedis(configYaml, time.Duration(configYaml.WorkerClaimIdleSeconds())*time.Second)
+	defer func() {
+		_ = messageBus.Close() // lint issue
+	}()
 
-	worker := worker.NewWorker(cache)
+	worker := worker.NewWorker(cache, db, messageBus, configYaml, configYaml)
 	debugServer := metrics.NewMetricsServer(configYaml)
 
 	go func() {
@@ -87,3 +103,18 @@ func runWorker(cCtx *cli.Context) error {
 
 	return nil
 }
+
+// newDatabase is where the worker reads the tweets missing in the cache,
+// the in-memory one lives in the API process, so there is none to read from
+func newDatabase(ctx context.Context, configYaml *config.YamlConfig) (database.DatabaseI, error) {
+	switch configYaml.DatabaseDriver() {
+	case "memory":
+		return nil, nil
+	case "sqlite":
+		return sqlite_db.NewSQLiteDB(ctx, configYaml)
+	case "", "postgres":
+		return postgres_db.NewPostgresDB(configYaml)
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", configYaml.DatabaseDriver())
+	}
+}
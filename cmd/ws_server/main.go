@@ -7,8 +7,10 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 	"twitter-clone/internal/app/api"
 	"twitter-clone/internal/config"
+	"twitter-clone/internal/messaging"
 	"twitter-clone/internal/server/metrics"
 	wsserver "twitter-clone/internal/server/ws_server"
 
@@ -69,8 +71,12 @@ func runWebSocketServer(cCtx *cli.Context) error {
 	}
 
 	cache := redis_cache.NewRedisCache(configYaml)
+	messageBus := messaging.NewRedis(configYaml, time.Duration(configYaml.WorkerClaimIdleSeconds())*time.Second)
+	defer func() {
+		_ = messageBus.Close() // lint issue
+	}()
 	apiService := api.NewAPIService(configYaml.WSServerAPIPath())
-	websocketServer := wsserver.NewWebSocketServer(cache, configYaml, apiService)
+	websocketServer := wsserver.NewWebSocketServer(cache, messageBus, configYaml, apiService)
 	debugServer := metrics.NewMetricsServer(configYaml)
 
 	go websocketServer.HandleTweets(signalCtx)
@@ -48,16 +48,29 @@ func (a *APIService) GetFollowers(ctx context.Context, userID int64) ([]twitter.
 	return followers, nil
 }
 
+func (a *APIService) GetFollowing(ctx context.Context, userID int64) ([]twitter.User, error) {
+	var (
+		err       error
+		following []twitter.User
+	)
+	followingPath := fmt.Sprintf("%s/api/v1/followings?user=%d", a.path, userID)
+	if err = a.request(ctx, followingPath, "GET", &following); err != nil {
+		return following, fmt.Errorf("error making request: %v", err)
+	}
+	return following, nil
+}
+
 func (a *APIService) GetTimeline(ctx context.Context, userID int64) ([]twitter.Tweet, error) {
 	var (
-		err    error
-		tweets []twitter.Tweet
+		err  error
+		page twitter.TweetPage
 	)
+	// first page is enough to warm up the timeline
 	tweetsPath := fmt.Sprintf("%s/api/v1/tweets?user=%d", a.path, userID)
-	if err = a.request(ctx, tweetsPath, "GET", &tweets); err != nil {
-		return tweets, fmt.Errorf("error making request: %v", err)
+	if err = a.request(ctx, tweetsPath, "GET", &page); err != nil {
+		return page.Tweets, fmt.Errorf("error making request: %v", err)
 	}
-	return tweets, nil
+	return page.Tweets, nil
 }
 
 func (a *APIService) GetTweet(ctx context.Context, tweetID int64) (twitter.Tweet, error) {
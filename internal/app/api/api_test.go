@@ -80,14 +80,36 @@ func TestGetFollowers(t *testing.T) {
 	require.Equal(t, int64(2), followers[1].ID)
 }
 
+func TestGetFollowing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v1/followings", r.URL.Path)
+		require.Equal(t, "42", r.URL.Query().Get("user"))
+
+		response := []twitter.User{
+			{ID: 3, Username: "followee1"},
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	api := api.NewAPIService(server.URL)
+	following, err := api.GetFollowing(context.Background(), 42)
+	require.NoError(t, err)
+	require.Len(t, following, 1)
+	require.Equal(t, int64(3), following[0].ID)
+}
+
 func TestGetTimeline(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		require.Equal(t, "/api/v1/tweets", r.URL.Path)
 		require.Equal(t, "42", r.URL.Query().Get("user"))
 
-		response := []twitter.Tweet{
-			{ID: 100, UserID: 42, Content: "First tweet", CreatedAt: time.Now()},
-			{ID: 101, UserID: 42, Content: "Second tweet", CreatedAt: time.Now()},
+		response := twitter.TweetPage{
+			Tweets: []twitter.Tweet{
+				{ID: 100, UserID: 42, Content: "First tweet", CreatedAt: time.Now()},
+				{ID: 101, UserID: 42, Content: "Second tweet", CreatedAt: time.Now()},
+			},
+			NextCursor: 101,
 		}
 		_ = json.NewEncoder(w).Encode(response)
 	}))
@@ -7,36 +7,165 @@ import (
 
 type FollowFunc func(ctx context.Context, follow twitter.Follow) error
 type GetUserFunc func(ctx context.Context, userId int64) (twitter.User, error)
-type NewTweetFunc func(ctx context.Context, tweetData twitter.Tweet) error
+type NewTweetFunc func(ctx context.Context, tweetData twitter.Tweet) (twitter.Tweet, error)
+type GetTweetFunc func(ctx context.Context, id int64) (twitter.Tweet, error)
+type GetUsersTweetsFunc func(ctx context.Context, userId int64, cursor twitter.Cursor) (twitter.TweetPage, error)
+type GetTimelineFunc func(ctx context.Context, userId int64, cursor twitter.Cursor) (twitter.TweetPage, error)
+type DeleteTweetFunc func(ctx context.Context, userID, tweetID int64) error
+type EditTweetFunc func(ctx context.Context, tweetData twitter.Tweet) (twitter.Tweet, error)
+type GetTweetHistoryFunc func(ctx context.Context, tweetID int64) ([]twitter.TweetRevision, error)
+type RetweetFunc func(ctx context.Context, userID, tweetID int64) (twitter.Tweet, error)
+type QuoteFunc func(ctx context.Context, tweetData twitter.Tweet, quotedID int64) (twitter.Tweet, error)
+type LikeFunc func(ctx context.Context, like twitter.Like) error
+type LikedByFunc func(ctx context.Context, tweetID int64, offset, limit int) ([]twitter.User, error)
+type GetConversationFunc func(ctx context.Context, tweetID int64, offset, limit int) (twitter.Conversation, error)
+type CreateUserFunc func(ctx context.Context, user twitter.User) (twitter.User, error)
+type UpdateProfileFunc func(ctx context.Context, userID int64, update twitter.ProfileUpdate) (twitter.User, error)
+
+// MockOption sets the functions which are not covered by the constructor
+type MockOption func(m *MockTweeterService)
+
+func WithGetUsersTweets(f GetUsersTweetsFunc) MockOption {
+	return func(m *MockTweeterService) {
+		m.getUsersTweets = f
+	}
+}
+
+func WithGetTweet(f GetTweetFunc) MockOption {
+	return func(m *MockTweeterService) {
+		m.getTweet = f
+	}
+}
+
+func WithGetTimeline(f GetTimelineFunc) MockOption {
+	return func(m *MockTweeterService) {
+		m.getTimeline = f
+	}
+}
+
+func WithUnfollowUser(f FollowFunc) MockOption {
+	return func(m *MockTweeterService) {
+		m.unfollowUser = f
+	}
+}
+
+func WithDeleteTweet(f DeleteTweetFunc) MockOption {
+	return func(m *MockTweeterService) {
+		m.deleteTweet = f
+	}
+}
+
+func WithEditTweet(f EditTweetFunc) MockOption {
+	return func(m *MockTweeterService) {
+		m.editTweet = f
+	}
+}
+
+func WithGetTweetHistory(f GetTweetHistoryFunc) MockOption {
+	return func(m *MockTweeterService) {
+		m.getTweetHistory = f
+	}
+}
+
+func WithRetweet(f RetweetFunc) MockOption {
+	return func(m *MockTweeterService) {
+		m.retweet = f
+	}
+}
+
+func WithQuote(f QuoteFunc) MockOption {
+	return func(m *MockTweeterService) {
+		m.quote = f
+	}
+}
+
+func WithLikeTweet(f LikeFunc) MockOption {
+	return func(m *MockTweeterService) {
+		m.likeTweet = f
+	}
+}
+
+func WithUnlikeTweet(f LikeFunc) MockOption {
+	return func(m *MockTweeterService) {
+		m.unlikeTweet = f
+	}
+}
+
+func WithLikedBy(f LikedByFunc) MockOption {
+	return func(m *MockTweeterService) {
+		m.likedBy = f
+	}
+}
+
+func WithGetConversation(f GetConversationFunc) MockOption {
+	return func(m *MockTweeterService) {
+		m.getConversation = f
+	}
+}
+
+func WithCreateUser(f CreateUserFunc) MockOption {
+	return func(m *MockTweeterService) {
+		m.createUser = f
+	}
+}
+
+func WithUpdateProfile(f UpdateProfileFunc) MockOption {
+	return func(m *MockTweeterService) {
+		m.updateProfile = f
+	}
+}
 
 // Mock implementation of TweeterService
 type MockTweeterService struct {
-	newTweet       func(ctx context.Context, tweetData twitter.Tweet) error
-	getTweet       func(ctx context.Context, id int64) (twitter.Tweet, error)
-	getUsersTweets func(ctx context.Context, userId int64) ([]twitter.Tweet, error) // returns tweets made by user
-	getTimeline    func(ctx context.Context, userId int64) ([]twitter.Tweet, error) // returns tweets from users the user is following
+	newTweet       func(ctx context.Context, tweetData twitter.Tweet) (twitter.Tweet, error)
+	getTweet       GetTweetFunc
+	getUsersTweets GetUsersTweetsFunc // returns tweets made by user
+	getTimeline    GetTimelineFunc    // returns tweets from users the user is following
 	getUser        func(ctx context.Context, userId int64) (twitter.User, error)
+	deleteTweet    DeleteTweetFunc
+
+	// Editing
+	editTweet       EditTweetFunc
+	getTweetHistory GetTweetHistoryFunc
+
+	// Retweets & quotes
+	retweet RetweetFunc
+	quote   QuoteFunc
+
+	// Likes
+	likeTweet   LikeFunc
+	unlikeTweet LikeFunc
+	likedBy     LikedByFunc
+
+	// Conversation
+	getConversation GetConversationFunc
 
 	// Follow
 	followUser   func(ctx context.Context, follow twitter.Follow) error
+	unfollowUser FollowFunc
 	getFollowers func(ctx context.Context, userId int64) ([]twitter.User, error)
 	getFollowing func(ctx context.Context, userId int64) ([]twitter.User, error)
 
 	// User
-	createUser func(ctx context.Context, user twitter.User) (int64, error)
+	createUser    CreateUserFunc
+	updateProfile UpdateProfileFunc
 }
 
 // I have to redefine it
-// Maybe with use of Options pattern
-func NewMockTweeterService(newTweet NewTweetFunc, followUser FollowFunc, getUser GetUserFunc) *MockTweeterService {
-	return &MockTweeterService{
+// the rest of the functions are set with options
+func NewMockTweeterService(newTweet NewTweetFunc, followUser FollowFunc, getUser GetUserFunc, opts ...MockOption) *MockTweeterService {
+	m := &MockTweeterService{
 		newTweet:   newTweet,
 		followUser: followUser,
 		getUser:    getUser,
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
 }
 
-func (m *MockTweeterService) NewTweet(ctx context.Context, tweetData twitter.Tweet) error {
+func (m *MockTweeterService) NewTweet(ctx context.Context, tweetData twitter.Tweet) (twitter.Tweet, error) {
 	return m.newTweet(ctx, tweetData)
 }
 
@@ -44,18 +173,58 @@ func (m *MockTweeterService) GetTweet(ctx context.Context, id int64) (twitter.Tw
 	return m.getTweet(ctx, id)
 }
 
-func (m *MockTweeterService) GetUsersTweets(ctx context.Context, userId int64) ([]twitter.Tweet, error) {
-	return m.getUsersTweets(ctx, userId)
+func (m *MockTweeterService) GetUsersTweets(ctx context.Context, userId int64, cursor twitter.Cursor) (twitter.TweetPage, error) {
+	return m.getUsersTweets(ctx, userId, cursor)
+}
+
+func (m *MockTweeterService) GetTimeline(ctx context.Context, userId int64, cursor twitter.Cursor) (twitter.TweetPage, error) {
+	return m.getTimeline(ctx, userId, cursor)
+}
+
+func (m *MockTweeterService) DeleteTweet(ctx context.Context, userID, tweetID int64) error {
+	return m.deleteTweet(ctx, userID, tweetID)
 }
 
-func (m *MockTweeterService) GetTimeline(ctx context.Context, userId int64) ([]twitter.Tweet, error) {
-	return m.getTimeline(ctx, userId)
+func (m *MockTweeterService) EditTweet(ctx context.Context, tweetData twitter.Tweet) (twitter.Tweet, error) {
+	return m.editTweet(ctx, tweetData)
+}
+
+func (m *MockTweeterService) GetTweetHistory(ctx context.Context, tweetID int64) ([]twitter.TweetRevision, error) {
+	return m.getTweetHistory(ctx, tweetID)
+}
+
+func (m *MockTweeterService) Retweet(ctx context.Context, userID, tweetID int64) (twitter.Tweet, error) {
+	return m.retweet(ctx, userID, tweetID)
+}
+
+func (m *MockTweeterService) Quote(ctx context.Context, tweetData twitter.Tweet, quotedID int64) (twitter.Tweet, error) {
+	return m.quote(ctx, tweetData, quotedID)
+}
+
+func (m *MockTweeterService) LikeTweet(ctx context.Context, like twitter.Like) error {
+	return m.likeTweet(ctx, like)
+}
+
+func (m *MockTweeterService) UnlikeTweet(ctx context.Context, like twitter.Like) error {
+	return m.unlikeTweet(ctx, like)
+}
+
+func (m *MockTweeterService) LikedBy(ctx context.Context, tweetID int64, offset, limit int) ([]twitter.User, error) {
+	return m.likedBy(ctx, tweetID, offset, limit)
+}
+
+func (m *MockTweeterService) GetConversation(ctx context.Context, tweetID int64, offset, limit int) (twitter.Conversation, error) {
+	return m.getConversation(ctx, tweetID, offset, limit)
 }
 
 func (m *MockTweeterService) FollowUser(ctx context.Context, follow twitter.Follow) error {
 	return m.followUser(ctx, follow)
 }
 
+func (m *MockTweeterService) UnfollowUser(ctx context.Context, follow twitter.Follow) error {
+	return m.unfollowUser(ctx, follow)
+}
+
 func (m *MockTweeterService) GetUser(ctx context.Context, id int64) (twitter.User, error) {
 	return m.getUser(ctx, id)
 }
@@ -68,6 +237,10 @@ func (m *MockTweeterService) Following(ctx context.Context, userId int64) ([]twi
 	return m.getFollowing(ctx, userId)
 }
 
-func (m *MockTweeterService) CreateUser(ctx context.Context, user twitter.User) (int64, error) {
+func (m *MockTweeterService) CreateUser(ctx context.Context, user twitter.User) (twitter.User, error) {
 	return m.createUser(ctx, user)
 }
+
+func (m *MockTweeterService) UpdateProfile(ctx context.Context, userID int64, update twitter.ProfileUpdate) (twitter.User, error) {
+	return m.updateProfile(ctx, userID, update)
+}
@@ -3,35 +3,146 @@ package app
 import (
 	"context"
 	"fmt"
+	"net/url"
+	"regexp"
+	"time"
 	"twitter-clone/internal/domain/cache"
+	"twitter-clone/internal/domain/config"
 	"twitter-clone/internal/domain/database"
+	"twitter-clone/internal/domain/idgen"
 	"twitter-clone/internal/domain/twitter"
+	"unicode/utf8"
 )
 
 type TwitterService struct {
 	db    database.DatabaseI
 	cache cache.Cache
+	ids   idgen.Generator
+
+	editWindow    time.Duration
+	timelineItems int // ids the cached timeline keeps
 }
 
-func NewTweeterService(db database.DatabaseI, cache cache.Cache) *TwitterService {
+// defaultEditWindow is used when the edit window is not configured
+const defaultEditWindow = time.Hour
+
+func NewTweeterService(db database.DatabaseI, cache cache.Cache, ids idgen.Generator, cacheConfig config.CacheConfig, tweetConfig config.TweetConfig) *TwitterService {
+	editWindow := time.Duration(tweetConfig.TweetEditWindowMinutes()) * time.Minute
+	if editWindow <= 0 {
+		editWindow = defaultEditWindow
+	}
 	return &TwitterService{
-		db:    db,
-		cache: cache,
+		db:            db,
+		cache:         cache,
+		ids:           ids,
+		editWindow:    editWindow,
+		timelineItems: cacheConfig.MaxTweetsTimelineItems(),
 	}
 }
 
-// TODO return tweet id
-func (tw *TwitterService) NewTweet(ctx context.Context, tweetData twitter.Tweet) error {
+func (tw *TwitterService) NewTweet(ctx context.Context, tweetData twitter.Tweet) (twitter.Tweet, error) {
 	var err error
-	if tweetData.ID, err = tw.db.NewTweet(ctx, tweetData); err != nil {
-		return fmt.Errorf("failed to save tweet: %w", err)
+	if err = validateContent(tweetData.Content); err != nil {
+		return twitter.Tweet{}, err
+	}
+	if tweetData.InReplyTo != nil {
+		if err = tw.setConversationRoot(ctx, &tweetData); err != nil {
+			return twitter.Tweet{}, err
+		}
+	}
+	tweetData.Kind = twitter.TweetKindOriginal
+	return tw.publishTweet(ctx, tweetData)
+}
+
+func (tw *TwitterService) Retweet(ctx context.Context, userID, tweetID int64) (twitter.Tweet, error) {
+	var (
+		original twitter.Tweet
+		err      error
+	)
+	if original, err = tw.referencedTweet(ctx, tweetID); err != nil {
+		return twitter.Tweet{}, err
+	}
+	return tw.publishTweet(ctx, twitter.Tweet{
+		UserID:       userID,
+		Kind:         twitter.TweetKindRetweet,
+		ReferencedID: &original.ID,
+	})
+}
+
+func (tw *TwitterService) Quote(ctx context.Context, tweetData twitter.Tweet, quotedID int64) (twitter.Tweet, error) {
+	var (
+		quoted twitter.Tweet
+		err    error
+	)
+	if err = validateContent(tweetData.Content); err != nil {
+		return twitter.Tweet{}, err
+	}
+	if quoted, err = tw.referencedTweet(ctx, quotedID); err != nil {
+		return twitter.Tweet{}, err
+	}
+	tweetData.Kind = twitter.TweetKindQuote
+	tweetData.ReferencedID = &quoted.ID
+	return tw.publishTweet(ctx, tweetData)
+}
+
+// validateContent counts the characters, not the bytes, same as validateProfile
+func validateContent(content string) error {
+	if utf8.RuneCountInString(content) > twitter.MaxContentLength {
+		return twitter.NewError(twitter.ErrValidation, twitter.ErrContentTooLong.Code,
+			fmt.Sprintf("content is up to %d characters", twitter.MaxContentLength))
+	}
+	return nil
+}
+
+// retweet has no content on its own, so retweeting or quoting it
+// is the same as doing it with the original tweet
+func (tw *TwitterService) referencedTweet(ctx context.Context, tweetID int64) (twitter.Tweet, error) {
+	var (
+		tweet twitter.Tweet
+		err   error
+	)
+	if tweet, err = tw.db.GetTweet(ctx, tweetID); err != nil {
+		return tweet, fmt.Errorf("failed to get referenced tweet %d: %w", tweetID, err)
+	}
+	if tweet.Kind == twitter.TweetKindRetweet && tweet.ReferencedID != nil {
+		if tweet, err = tw.db.GetTweet(ctx, *tweet.ReferencedID); err != nil {
+			return tweet, fmt.Errorf("failed to get original tweet of retweet %d: %w", tweetID, err)
+		}
 	}
-	if err = tw.cache.PushTweet(ctx, tweetData); err != nil {
-		return fmt.Errorf("failed to push tweet to cache: %w", err)
+	return tweet, nil
+}
+
+// saves tweet of any kind, the outbox relay pushes it to the cache and announces it to the workers,
+// so the tweet isn't lost for the followers if the cache or the bus is down at the moment.
+// The id is generated here, so it grows with time whichever node or database saves the tweet,
+// the creation time is the one set by the database
+func (tw *TwitterService) publishTweet(ctx context.Context, tweetData twitter.Tweet) (twitter.Tweet, error) {
+	var err error
+	tweetData.ID = tw.ids.NextID()
+	if tweetData, err = tw.db.NewTweet(ctx, tweetData); err != nil {
+		return twitter.Tweet{}, fmt.Errorf("failed to save tweet: %w", err)
 	}
 	// but in between we can push it to any ML service to analyze the data
 	// just for future work
-	return nil // actually that's all I think, nothing more
+	return tweetData, nil // actually that's all I think, nothing more
+}
+
+// reply inherits the root of its parent, a reply to a top level tweet
+// makes this tweet the root of the conversation
+func (tw *TwitterService) setConversationRoot(ctx context.Context, tweetData *twitter.Tweet) error {
+	var (
+		parent twitter.Tweet
+		err    error
+	)
+	if parent, err = tw.db.GetTweet(ctx, *tweetData.InReplyTo); err != nil {
+		return fmt.Errorf("failed to get parent tweet %d: %w", *tweetData.InReplyTo, err)
+	}
+	rootID := parent.ID
+	if parent.RootID != nil {
+		rootID = *parent.RootID
+	}
+	tweetData.RootID = &rootID
+	return nil
 }
 
 func (tw *TwitterService) GetTweet(ctx context.Context, id int64) (twitter.Tweet, error) {
@@ -40,33 +151,403 @@ func (tw *TwitterService) GetTweet(ctx context.Context, id int64) (twitter.Tweet
 	if tweet, err = tw.db.GetTweet(ctx, id); err != nil {
 		return tweet, fmt.Errorf("failed to get tweet from db: %w", err)
 	}
-	return tweet, nil
+	tweets := []twitter.Tweet{tweet}
+	if err = tw.fillLikes(ctx, tweets); err != nil {
+		return tweet, err
+	}
+	return tweets[0], nil
+}
+
+func (tw *TwitterService) GetUsersTweets(ctx context.Context, userId int64, cursor twitter.Cursor) (twitter.TweetPage, error) {
+	var (
+		tweets []twitter.Tweet
+		err    error
+	)
+	if tweets, err = tw.db.GetUsersTweets(ctx, userId, pageCursor(cursor)); err != nil {
+		return twitter.TweetPage{}, fmt.Errorf("failed to get users tweets from db: %w", err)
+	}
+	page := newTweetPage(tweets, cursor)
+	if err = tw.fillLikes(ctx, page.Tweets); err != nil {
+		return twitter.TweetPage{}, err
+	}
+	return page, nil
+}
+
+// one more tweet tells if there is a next page
+func pageCursor(cursor twitter.Cursor) twitter.Cursor {
+	cursor.Limit++
+	return cursor
 }
 
-func (tw *TwitterService) GetUsersTweets(ctx context.Context, userId int64) ([]twitter.Tweet, error) {
-	panic("not implemented")
+// newTweetPage cuts the tweets read with limit+1 to the page, the tweets are newest first.
+// Going back the extra tweet is the oldest one and the last tweet of the page is the next cursor,
+// going forward the extra tweet is the newest one and there is no next cursor.
+// The first tweet of the page is always the cursor to check for newer tweets
+func newTweetPage(tweets []twitter.Tweet, cursor twitter.Cursor) twitter.TweetPage {
+	var page twitter.TweetPage
+	switch {
+	case len(tweets) <= cursor.Limit:
+		page.Tweets = tweets
+	case cursor.After != 0:
+		page.Tweets = tweets[len(tweets)-cursor.Limit:]
+	default:
+		page.Tweets = tweets[:cursor.Limit]
+		page.NextCursor = page.Tweets[len(page.Tweets)-1].ID
+	}
+	page.PrevCursor = cursor.After
+	if len(page.Tweets) > 0 {
+		page.PrevCursor = page.Tweets[0].ID
+	}
+	return page
+}
+
+func (tw *TwitterService) GetTimeline(ctx context.Context, userId int64, cursor twitter.Cursor) (twitter.TweetPage, error) {
+	var (
+		page   twitter.TweetPage
+		cached bool
+		tweets []twitter.Tweet
+		err    error
+	)
+	if page, cached, err = tw.cachedTimeline(ctx, userId, cursor); err != nil {
+		return twitter.TweetPage{}, err
+	}
+	// cached timeline is short, older pages are only in the database
+	if !cached {
+		if tweets, err = tw.db.GetTimeline(ctx, userId, pageCursor(cursor)); err != nil {
+			return twitter.TweetPage{}, fmt.Errorf("failed to get timeline from db: %w", err)
+		}
+		page = newTweetPage(tweets, cursor)
+	}
+	if err = tw.fillLikes(ctx, page.Tweets); err != nil {
+		return twitter.TweetPage{}, err
+	}
+	return page, nil
+}
+
+// cachedTimeline returns the page if the cached timeline has the whole page, false otherwise.
+// The cached timeline is shorter than a page usually is, so the page is cut to what it keeps
+// with one id left to tell the page goes on, the older pages come from the database.
+// The cursors are taken from the ids, so a tweet deleted since it was cached doesn't end the pages
+func (tw *TwitterService) cachedTimeline(ctx context.Context, userID int64, cursor twitter.Cursor) (twitter.TweetPage, bool, error) {
+	var (
+		exists    bool
+		ids       []int64
+		followees []int64
+		err       error
+	)
+	cursor.Limit = min(cursor.Limit, tw.timelineItems-1)
+	// going forward the cache can't tell if it reaches back to the cursor
+	if cursor.After != 0 || cursor.Limit < 1 {
+		return twitter.TweetPage{}, false, nil
+	}
+	if exists, err = tw.cache.CheckUserTimelineExists(ctx, userID); err != nil {
+		return twitter.TweetPage{}, false, fmt.Errorf("failed to check timeline in cache: %w", err)
+	}
+	if !exists {
+		return twitter.TweetPage{}, false, nil
+	}
+	if ids, err = tw.cache.GetUserTimeline(ctx, userID, pageCursor(cursor)); err != nil {
+		return twitter.TweetPage{}, false, fmt.Errorf("failed to get timeline from cache: %w", err)
+	}
+	// tweets of high fan-out users are not pushed to the feed
+	if followees, err = tw.highFanoutFollowing(ctx, userID); err != nil {
+		return twitter.TweetPage{}, false, err
+	}
+	if ids, err = tw.cache.MergeHighFanoutTweets(ctx, ids, followees, pageCursor(cursor)); err != nil {
+		return twitter.TweetPage{}, false, fmt.Errorf("failed to merge high fan-out tweets: %w", err)
+	}
+	// going back the page may continue in the database, the oldest extra id only tells there is one
+	if len(ids) <= cursor.Limit {
+		return twitter.TweetPage{}, false, nil
+	}
+	ids = ids[len(ids)-cursor.Limit:]
+
+	page := twitter.TweetPage{
+		Tweets:     make([]twitter.Tweet, 0, len(ids)),
+		NextCursor: ids[0],
+		PrevCursor: ids[len(ids)-1],
+	}
+	for i := len(ids) - 1; i >= 0; i-- {
+		tweet, err := tw.cache.GetTweet(ctx, ids[i])
+		if err != nil {
+			// tweet expired from the cache
+			if tweet, err = tw.db.GetTweet(ctx, ids[i]); err != nil {
+				continue // or it's deleted already
+			}
+		}
+		page.Tweets = append(page.Tweets, tweet)
+	}
+	return page, true, nil
+}
+
+// highFanoutFollowing returns the high fan-out users the user follows, the followings are read
+// from the database only if they are not cached. Then all of them are returned, the merge skips the rest
+func (tw *TwitterService) highFanoutFollowing(ctx context.Context, userID int64) ([]int64, error) {
+	var (
+		cached    cache.HighFanoutFollowing
+		following []twitter.User
+		err       error
+	)
+	if cached, err = tw.cache.GetHighFanoutFollowing(ctx, userID); err != nil {
+		return nil, fmt.Errorf("failed to get high fan-out following from cache: %w", err)
+	}
+	if cached.Cached {
+		return cached.Followees, nil
+	}
+	if following, err = tw.db.Following(ctx, userID); err != nil {
+		return nil, fmt.Errorf("failed to get following from db: %w", err)
+	}
+	followees := make([]int64, len(following))
+	for i, user := range following {
+		followees[i] = user.ID
+	}
+	if err = tw.cache.SetFollowing(ctx, userID, followees, cached.Version); err != nil {
+		return nil, fmt.Errorf("failed to set following in cache: %w", err)
+	}
+	return followees, nil
 }
 
-func (tw *TwitterService) GetTimeline(ctx context.Context, userId int64) ([]twitter.Tweet, error) {
+func (tw *TwitterService) DeleteTweet(ctx context.Context, userID, tweetID int64) error {
 	var err error
-	var tweets []twitter.Tweet
+	// the outbox relay takes it out of the cache and the clients of the followers,
+	// so the delete isn't reported as failed if the cache or the bus is down at the moment
+	if _, err = tw.db.DeleteTweet(ctx, tweetID, userID); err != nil {
+		return fmt.Errorf("failed to delete tweet: %w", err)
+	}
+	return nil
+}
+
+func (tw *TwitterService) EditTweet(ctx context.Context, tweetData twitter.Tweet) (twitter.Tweet, error) {
+	var (
+		tweet twitter.Tweet
+		err   error
+	)
+	if err = validateContent(tweetData.Content); err != nil {
+		return twitter.Tweet{}, err
+	}
+	if tweet, err = tw.db.GetTweet(ctx, tweetData.ID); err != nil {
+		return twitter.Tweet{}, fmt.Errorf("failed to get tweet from db: %w", err)
+	}
+	if tweet.UserID != tweetData.UserID {
+		return twitter.Tweet{}, fmt.Errorf("failed to edit tweet: %w", twitter.ErrNotTweetAuthor)
+	}
+	if tweet.Kind == twitter.TweetKindRetweet {
+		return twitter.Tweet{}, twitter.NewError(twitter.ErrForbidden, twitter.ErrEditNotAllowed.Code, "retweet has no content to edit")
+	}
+	if time.Since(tweet.CreatedAt) > tw.editWindow {
+		return twitter.Tweet{}, twitter.NewError(twitter.ErrForbidden, twitter.ErrEditNotAllowed.Code,
+			fmt.Sprintf("tweet can be edited only within %v", tw.editWindow))
+	}
 
-	if tweets, err = tw.db.GetTimeline(ctx, userId); err != nil {
-		return nil, fmt.Errorf("failed to get users tweets from db: %w", err)
+	// the outbox relay replaces it in the cache and the clients of the followers
+	if tweet, err = tw.db.EditTweet(ctx, tweetData); err != nil {
+		return twitter.Tweet{}, fmt.Errorf("failed to edit tweet: %w", err)
 	}
-	return tweets, nil
+	return tweet, nil
+}
+
+func (tw *TwitterService) GetTweetHistory(ctx context.Context, tweetID int64) ([]twitter.TweetRevision, error) {
+	var (
+		tweet     twitter.Tweet
+		revisions []twitter.TweetRevision
+		err       error
+	)
+	if tweet, err = tw.db.GetTweet(ctx, tweetID); err != nil {
+		return nil, fmt.Errorf("failed to get tweet from db: %w", err)
+	}
+	if revisions, err = tw.db.GetTweetRevisions(ctx, tweetID); err != nil {
+		return nil, fmt.Errorf("failed to get tweet revisions from db: %w", err)
+	}
+
+	// database keeps only the previous versions, the current one is the tweet itself
+	currentAt := tweet.CreatedAt
+	if tweet.EditedAt != nil {
+		currentAt = *tweet.EditedAt
+	}
+	return append(revisions, twitter.TweetRevision{
+		TweetID:   tweet.ID,
+		Version:   len(revisions) + 1,
+		Content:   tweet.Content,
+		CreatedAt: currentAt,
+	}), nil
+}
+
+// Likes part
+
+func (tw *TwitterService) LikeTweet(ctx context.Context, like twitter.Like) error {
+	var (
+		liked bool
+		err   error
+	)
+	if liked, err = tw.db.LikeTweet(ctx, like); err != nil {
+		return fmt.Errorf("failed to like tweet: %w", err)
+	}
+	if liked {
+		if err = tw.cache.DropLikes(ctx, like.TweetID); err != nil {
+			return fmt.Errorf("failed to update likes in cache: %w", err)
+		}
+	}
+	return nil
+}
+
+func (tw *TwitterService) UnlikeTweet(ctx context.Context, like twitter.Like) error {
+	var (
+		unliked bool
+		err     error
+	)
+	if unliked, err = tw.db.UnlikeTweet(ctx, like); err != nil {
+		return fmt.Errorf("failed to unlike tweet: %w", err)
+	}
+	if unliked {
+		if err = tw.cache.DropLikes(ctx, like.TweetID); err != nil {
+			return fmt.Errorf("failed to update likes in cache: %w", err)
+		}
+	}
+	return nil
+}
+
+func (tw *TwitterService) LikedBy(ctx context.Context, tweetID int64, offset, limit int) ([]twitter.User, error) {
+	var (
+		users []twitter.User
+		err   error
+	)
+	if users, err = tw.db.LikedBy(ctx, tweetID, offset, limit); err != nil {
+		return []twitter.User{}, fmt.Errorf("failed to get users who liked tweet: %w", err)
+	}
+	return users, nil
+}
+
+// fillLikes sets like counters of the tweets, the counters are taken from cache
+// and the missing ones are counted in database and cached for the next time,
+// unless they are liked while they are counted
+func (tw *TwitterService) fillLikes(ctx context.Context, tweets []twitter.Tweet) error {
+	if len(tweets) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(tweets))
+	for i, tweet := range tweets {
+		ids[i] = tweet.ID
+	}
+
+	cached, err := tw.cache.GetLikes(ctx, ids)
+	cacheDown := err != nil
+	if cacheDown {
+		cached = cache.LikeCounters{Likes: make(map[int64]int64)} // cache is not the source of truth, just count everything
+	}
+	likes := cached.Likes
+	var missing []int64
+	for _, id := range ids {
+		if _, ok := likes[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		var counted map[int64]int64
+		if counted, err = tw.db.LikesCount(ctx, missing); err != nil {
+			return fmt.Errorf("failed to count likes: %w", err)
+		}
+		if !cacheDown {
+			_ = tw.cache.SetLikes(ctx, counted, cached.Versions) // next read will count them again
+		}
+		for id, count := range counted {
+			likes[id] = count
+		}
+	}
+
+	for i := range tweets {
+		tweets[i].Likes = likes[tweets[i].ID]
+	}
+	return nil
+}
+
+func (tw *TwitterService) GetConversation(ctx context.Context, tweetID int64, offset, limit int) (twitter.Conversation, error) {
+	var (
+		tweet  twitter.Tweet
+		tweets []twitter.Tweet
+		err    error
+	)
+	if tweet, err = tw.db.GetTweet(ctx, tweetID); err != nil {
+		return twitter.Conversation{}, fmt.Errorf("failed to get tweet from db: %w", err)
+	}
+	rootID := tweet.ID
+	if tweet.RootID != nil {
+		rootID = *tweet.RootID
+	}
+	if tweets, err = tw.db.GetConversation(ctx, rootID); err != nil {
+		return twitter.Conversation{}, fmt.Errorf("failed to get conversation from db: %w", err)
+	}
+
+	thread := flattenReplyTree(rootID, tweets)
+	conversation := twitter.Conversation{
+		RootID: rootID,
+		Tweets: []twitter.ConversationTweet{},
+	}
+	if offset >= len(thread) {
+		return conversation, nil
+	}
+	end := min(offset+limit, len(thread))
+	conversation.Tweets = thread[offset:end]
+	if end < len(thread) {
+		conversation.NextOffset = end
+	}
+	return conversation, nil
+}
+
+// flattenReplyTree walks the conversation depth-first, replies to the same
+// tweet are kept in the order they were posted (tweets come sorted by id).
+// Replies which lost their parent are attached to the root.
+func flattenReplyTree(rootID int64, tweets []twitter.Tweet) []twitter.ConversationTweet {
+	var root *twitter.Tweet
+	replies := make(map[int64][]twitter.Tweet)
+	for i, tweet := range tweets {
+		if tweet.ID == rootID {
+			root = &tweets[i]
+			continue
+		}
+		parentID := rootID
+		if tweet.InReplyTo != nil {
+			parentID = *tweet.InReplyTo
+		}
+		replies[parentID] = append(replies[parentID], tweet)
+	}
+
+	thread := make([]twitter.ConversationTweet, 0, len(tweets))
+	var stack []twitter.ConversationTweet
+	push := func(parentID int64, depth int) {
+		// pushed in reverse so the oldest reply is visited first
+		children := replies[parentID]
+		for i := len(children) - 1; i >= 0; i-- {
+			stack = append(stack, twitter.ConversationTweet{Tweet: children[i], Depth: depth})
+		}
+	}
+	if root != nil {
+		stack = append(stack, twitter.ConversationTweet{Tweet: *root})
+	} else {
+		push(rootID, 1) // the root is deleted, the replies keep their places
+	}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		thread = append(thread, current)
+		push(current.ID, current.Depth+1)
+	}
+	return thread
 }
 
 // Follow part
 
 func (tw *TwitterService) FollowUser(ctx context.Context, follow twitter.Follow) error {
-	var err error
-	// Here we have to update the cache registry for user
-	if err = tw.db.FollowUser(ctx, follow); err != nil {
+	// the outbox relay updates the cache registry for user and the worker backfills the timeline
+	if err := tw.db.FollowUser(ctx, follow); err != nil {
 		return fmt.Errorf("failed to follow user: %w", err)
 	}
-	if err = tw.cache.FollowUser(ctx, follow); err != nil {
-		return fmt.Errorf("failed to follow user in cache: %w", err)
+	return nil
+}
+
+func (tw *TwitterService) UnfollowUser(ctx context.Context, follow twitter.Follow) error {
+	// the outbox relay removes the follower from the cache registry in the order of the follows
+	if err := tw.db.UnfollowUser(ctx, follow); err != nil {
+		return fmt.Errorf("failed to unfollow user: %w", err)
 	}
 	return nil
 }
@@ -95,15 +576,60 @@ func (tw *TwitterService) Following(ctx context.Context, userId int64) ([]twitte
 }
 
 // User part
-func (tw *TwitterService) CreateUser(ctx context.Context, user twitter.User) (int64, error) {
+var usernamePattern = regexp.MustCompile(fmt.Sprintf(`^[A-Za-z0-9_]{%d,%d}$`, twitter.MinUsernameLength, twitter.MaxUsernameLength))
+
+func validateUsername(username string) error {
+	if !usernamePattern.MatchString(username) {
+		return twitter.NewError(twitter.ErrValidation, twitter.ErrInvalidUsername.Code,
+			fmt.Sprintf("username has to be %d to %d latin letters, digits or underscores", twitter.MinUsernameLength, twitter.MaxUsernameLength))
+	}
+	return nil
+}
+
+// validateProfile checks the profile fields of the user, the lengths are in characters
+// same as the columns of the database
+func validateProfile(user twitter.User) error {
+	fields := []struct {
+		name      string
+		value     string
+		maxLength int
+	}{
+		{"display name", user.DisplayName, twitter.MaxDisplayNameLength},
+		{"bio", user.Bio, twitter.MaxBioLength},
+		{"location", user.Location, twitter.MaxLocationLength},
+		{"website", user.Website, twitter.MaxWebsiteLength},
+	}
+	for _, field := range fields {
+		if utf8.RuneCountInString(field.value) > field.maxLength {
+			return twitter.NewError(twitter.ErrValidation, twitter.ErrInvalidProfile.Code,
+				fmt.Sprintf("%s is up to %d characters", field.name, field.maxLength))
+		}
+	}
+	if user.Website == "" {
+		return nil
+	}
+	website, err := url.Parse(user.Website)
+	if err != nil || (website.Scheme != "http" && website.Scheme != "https") || website.Host == "" {
+		return twitter.NewError(twitter.ErrValidation, twitter.ErrInvalidProfile.Code, "website has to be an http or https url")
+	}
+	return nil
+}
+
+func (tw *TwitterService) CreateUser(ctx context.Context, user twitter.User) (twitter.User, error) {
 	var (
-		id  int64
-		err error
+		saved twitter.User
+		err   error
 	)
-	if id, err = tw.db.CreateUser(ctx, user); err != nil {
-		return 0, fmt.Errorf("failed to create user: %w", err)
+	if err = validateUsername(user.Username); err != nil {
+		return twitter.User{}, err
 	}
-	return id, nil
+	if err = validateProfile(user); err != nil {
+		return twitter.User{}, err
+	}
+	if saved, err = tw.db.CreateUser(ctx, user); err != nil {
+		return twitter.User{}, fmt.Errorf("failed to create user: %w", err)
+	}
+	return saved, nil
 }
 
 func (tw *TwitterService) GetUser(ctx context.Context, id int64) (twitter.User, error) {
@@ -116,3 +642,33 @@ func (tw *TwitterService) GetUser(ctx context.Context, id int64) (twitter.User,
 	}
 	return user, nil
 }
+
+func (tw *TwitterService) UpdateProfile(ctx context.Context, userID int64, update twitter.ProfileUpdate) (twitter.User, error) {
+	var (
+		user twitter.User
+		err  error
+	)
+	if user, err = tw.db.GetUser(ctx, userID); err != nil {
+		return twitter.User{}, fmt.Errorf("failed to get user from database: %w", err)
+	}
+	// only the fields of the update are changed, the rest stays
+	if update.DisplayName != nil {
+		user.DisplayName = *update.DisplayName
+	}
+	if update.Bio != nil {
+		user.Bio = *update.Bio
+	}
+	if update.Location != nil {
+		user.Location = *update.Location
+	}
+	if update.Website != nil {
+		user.Website = *update.Website
+	}
+	if err = validateProfile(user); err != nil {
+		return twitter.User{}, err
+	}
+	if user, err = tw.db.UpdateProfile(ctx, user); err != nil {
+		return twitter.User{}, fmt.Errorf("failed to update profile: %w", err)
+	}
+	return user, nil
+}
@@ -0,0 +1,238 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"twitter-clone/internal/cache/cachetest"
+	cache_inmemory "twitter-clone/internal/cache/inmemory"
+	"twitter-clone/internal/config"
+	db_inmemory "twitter-clone/internal/database/inmemory"
+	"twitter-clone/internal/domain/twitter"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		valid   bool
+	}{
+		{name: "Empty", content: "", valid: true},
+		{name: "Longest", content: strings.Repeat("a", twitter.MaxContentLength), valid: true},
+		{name: "Too long", content: strings.Repeat("a", twitter.MaxContentLength+1)},
+		{name: "Multibyte characters", content: strings.Repeat("推", twitter.MaxContentLength), valid: true},
+		{name: "Emoji", content: strings.Repeat("🐦", twitter.MaxContentLength), valid: true},
+		{name: "Too many multibyte characters", content: strings.Repeat("🐦", twitter.MaxContentLength+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateContent(tt.content)
+			if tt.valid {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, twitter.ErrContentTooLong)
+			}
+		})
+	}
+}
+
+// countingDB runs afterCount once the likes are counted, before they are cached,
+// and counts the reads of the followings
+type countingDB struct {
+	*db_inmemory.InMemoryDB
+	afterCount func()
+	following  int
+	timeline   int
+}
+
+func (db *countingDB) GetTimeline(ctx context.Context, userID int64, cursor twitter.Cursor) ([]twitter.Tweet, error) {
+	db.timeline++
+	return db.InMemoryDB.GetTimeline(ctx, userID, cursor)
+}
+
+func (db *countingDB) Following(ctx context.Context, userId int64) ([]twitter.User, error) {
+	db.following++
+	return db.InMemoryDB.Following(ctx, userId)
+}
+
+func (db *countingDB) LikesCount(ctx context.Context, tweetIDs []int64) (map[int64]int64, error) {
+	counted, err := db.InMemoryDB.LikesCount(ctx, tweetIDs)
+	if db.afterCount != nil {
+		db.afterCount()
+		db.afterCount = nil
+	}
+	return counted, err
+}
+
+func TestFillLikesWhileLiked(t *testing.T) {
+	ctx := context.Background()
+	db := &countingDB{InMemoryDB: db_inmemory.NewInMemoryDB()}
+	tw := &TwitterService{db: db, cache: cache_inmemory.NewInMemoryCache(&cachetest.Config{})}
+	alice, err := db.CreateUser(ctx, twitter.User{Username: "alice"})
+	require.NoError(t, err)
+	bob, err := db.CreateUser(ctx, twitter.User{Username: "bob"})
+	require.NoError(t, err)
+	tweet, err := db.NewTweet(ctx, twitter.Tweet{UserID: alice.ID, Content: "hello", Kind: twitter.TweetKindOriginal})
+	require.NoError(t, err)
+
+	// bob likes it after it's counted, but before the count is cached
+	db.afterCount = func() {
+		require.NoError(t, tw.LikeTweet(ctx, twitter.Like{UserID: bob.ID, TweetID: tweet.ID}))
+	}
+	tweets := []twitter.Tweet{tweet}
+	require.NoError(t, tw.fillLikes(ctx, tweets))
+	require.Zero(t, tweets[0].Likes)
+
+	// the count read before the like is not cached
+	require.NoError(t, tw.fillLikes(ctx, tweets))
+	require.Equal(t, int64(1), tweets[0].Likes)
+	require.NoError(t, tw.fillLikes(ctx, tweets))
+	require.Equal(t, int64(1), tweets[0].Likes)
+
+	require.NoError(t, tw.UnlikeTweet(ctx, twitter.Like{UserID: bob.ID, TweetID: tweet.ID}))
+	require.NoError(t, tw.fillLikes(ctx, tweets))
+	require.Zero(t, tweets[0].Likes)
+}
+
+func TestTimelineReadsFollowingFromCache(t *testing.T) {
+	ctx := context.Background()
+	db := &countingDB{InMemoryDB: db_inmemory.NewInMemoryDB()}
+	c := cache_inmemory.NewInMemoryCache(&cachetest.Config{})
+	tw := &TwitterService{db: db, cache: c, timelineItems: 3}
+	var users []int64
+	for _, name := range []string{"alice", "bob", "carol", "dave"} {
+		user, err := db.CreateUser(ctx, twitter.User{Username: name})
+		require.NoError(t, err)
+		users = append(users, user.ID)
+	}
+	alice, bob, carol, dave := users[0], users[1], users[2], users[3]
+	for _, followee := range []int64{bob, carol} {
+		require.NoError(t, db.FollowUser(ctx, twitter.Follow{FollowerID: alice, FolloweeID: followee}))
+	}
+
+	// carol's tweet is in the feed, bob's one is merged as bob has too many followers
+	fromCarol, err := db.NewTweet(ctx, twitter.Tweet{ID: 1, UserID: carol, Content: "feed", Kind: twitter.TweetKindOriginal})
+	require.NoError(t, err)
+	fromBob, err := db.NewTweet(ctx, twitter.Tweet{ID: 2, UserID: bob, Content: "merged", Kind: twitter.TweetKindOriginal})
+	require.NoError(t, err)
+	require.NoError(t, c.PushTweet(ctx, fromCarol))
+	require.NoError(t, c.PushTweet(ctx, fromBob))
+	require.NoError(t, c.StoreTimeline(ctx, alice, []twitter.Tweet{fromCarol}))
+	require.NoError(t, c.SetHighFanout(ctx, bob, true))
+
+	read := func() {
+		page, err := tw.GetTimeline(ctx, alice, twitter.Cursor{Limit: 1})
+		require.NoError(t, err)
+		require.Len(t, page.Tweets, 1)
+		require.Equal(t, fromBob.ID, page.Tweets[0].ID)
+	}
+	read()
+	require.Equal(t, 1, db.following)
+	read()
+	require.Equal(t, 1, db.following)
+
+	// a follow relayed to the cache makes it read them again
+	require.NoError(t, db.FollowUser(ctx, twitter.Follow{FollowerID: alice, FolloweeID: dave}))
+	require.NoError(t, c.FollowUser(ctx, twitter.Follow{FollowerID: alice, FolloweeID: dave}))
+	read()
+	require.Equal(t, 2, db.following)
+	read()
+	require.Equal(t, 2, db.following)
+}
+
+func TestCachedTimelineKeepsCursorAfterDeletedTweet(t *testing.T) {
+	ctx := context.Background()
+	db := db_inmemory.NewInMemoryDB()
+	c := cache_inmemory.NewInMemoryCache(&cachetest.Config{})
+	tw := &TwitterService{db: db, cache: c, timelineItems: 3}
+	alice, err := db.CreateUser(ctx, twitter.User{Username: "alice"})
+	require.NoError(t, err)
+	bob, err := db.CreateUser(ctx, twitter.User{Username: "bob"})
+	require.NoError(t, err)
+	require.NoError(t, db.FollowUser(ctx, twitter.Follow{FollowerID: alice.ID, FolloweeID: bob.ID}))
+
+	var tweets []twitter.Tweet
+	for id := range int64(3) {
+		tweet, err := db.NewTweet(ctx, twitter.Tweet{ID: id + 1, UserID: bob.ID, Content: "hello", Kind: twitter.TweetKindOriginal})
+		require.NoError(t, err)
+		tweets = append(tweets, tweet)
+	}
+	require.NoError(t, c.StoreTimeline(ctx, alice.ID, tweets))
+	// deleted from the database while its id is still in the cached timeline
+	_, err = db.DeleteTweet(ctx, tweets[2].ID, bob.ID)
+	require.NoError(t, err)
+
+	page, err := tw.GetTimeline(ctx, alice.ID, twitter.Cursor{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, page.Tweets, 1)
+	require.Equal(t, tweets[1].ID, page.Tweets[0].ID)
+	require.Equal(t, tweets[1].ID, page.NextCursor)
+
+	page, err = tw.GetTimeline(ctx, alice.ID, twitter.Cursor{Before: page.NextCursor, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, page.Tweets, 1)
+	require.Equal(t, tweets[0].ID, page.Tweets[0].ID)
+}
+
+func TestCachedTimelineWithShippedConfig(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := config.NewYamlConfig("../../../configs/config.yml")
+	require.NoError(t, err)
+	db := &countingDB{InMemoryDB: db_inmemory.NewInMemoryDB()}
+	c := cache_inmemory.NewInMemoryCache(cfg)
+	tw := NewTweeterService(db, c, nil, cfg, cfg)
+	alice, err := db.CreateUser(ctx, twitter.User{Username: "alice"})
+	require.NoError(t, err)
+	bob, err := db.CreateUser(ctx, twitter.User{Username: "bob"})
+	require.NoError(t, err)
+	require.NoError(t, db.FollowUser(ctx, twitter.Follow{FollowerID: alice.ID, FolloweeID: bob.ID}))
+
+	var tweets []twitter.Tweet
+	for id := range int64(15) {
+		tweet, err := db.NewTweet(ctx, twitter.Tweet{ID: id + 1, UserID: bob.ID, Content: "hello", Kind: twitter.TweetKindOriginal})
+		require.NoError(t, err)
+		require.NoError(t, c.PushTweet(ctx, tweet))
+		tweets = append(tweets, tweet)
+	}
+	require.NoError(t, c.StoreTimeline(ctx, alice.ID, tweets))
+
+	// the default page of the api is longer than the cached timeline, it's cut to fit it
+	page, err := tw.GetTimeline(ctx, alice.ID, twitter.Cursor{Limit: 50})
+	require.NoError(t, err)
+	require.Zero(t, db.timeline)
+	require.Len(t, page.Tweets, cfg.MaxTweetsTimelineItems()-1)
+	require.Equal(t, tweets[14].ID, page.Tweets[0].ID)
+	require.Equal(t, page.Tweets[len(page.Tweets)-1].ID, page.NextCursor)
+
+	// the rest is only in the database
+	page, err = tw.GetTimeline(ctx, alice.ID, twitter.Cursor{Before: page.NextCursor, Limit: 50})
+	require.NoError(t, err)
+	require.Equal(t, 1, db.timeline)
+	require.Len(t, page.Tweets, 15-cfg.MaxTweetsTimelineItems()+1)
+	require.Equal(t, tweets[0].ID, page.Tweets[len(page.Tweets)-1].ID)
+	require.Zero(t, page.NextCursor)
+}
+
+// tweetConfig leaves the edit window unset
+type tweetConfig struct{}
+
+func (c *tweetConfig) TweetEditWindowMinutes() int { return 0 }
+func (c *tweetConfig) TweetNodeID() int            { return 0 }
+
+func TestEditTweetWithDefaultWindow(t *testing.T) {
+	ctx := context.Background()
+	db := db_inmemory.NewInMemoryDB()
+	tw := NewTweeterService(db, cache_inmemory.NewInMemoryCache(&cachetest.Config{}), nil, &cachetest.Config{}, &tweetConfig{})
+	alice, err := db.CreateUser(ctx, twitter.User{Username: "alice"})
+	require.NoError(t, err)
+	tweet, err := db.NewTweet(ctx, twitter.Tweet{UserID: alice.ID, Content: "v1", Kind: twitter.TweetKindOriginal})
+	require.NoError(t, err)
+
+	// a fresh tweet can be edited even though the window is not configured
+	edited, err := tw.EditTweet(ctx, twitter.Tweet{ID: tweet.ID, UserID: alice.ID, Content: "v2"})
+	require.NoError(t, err)
+	require.Equal(t, "v2", edited.Content)
+	require.Equal(t, defaultEditWindow, tw.editWindow)
+}
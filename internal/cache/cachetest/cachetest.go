@@ -0,0 +1,523 @@
+// Package cachetest is the behavior every cache.Cache has to follow,
+// the Redis and the in-memory caches run the same suite
+package cachetest
+
+import (
+	"context"
+	"testing"
+	"time"
+	"twitter-clone/internal/domain/cache"
+	"twitter-clone/internal/domain/twitter"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Config is the cache config the suite expects, Address is set for the caches which need a server
+type Config struct {
+	Address string
+}
+
+func (c *Config) CacheAddress() string                { return c.Address }
+func (c *Config) CachePassword() string               { return "" }
+func (c *Config) CacheDB() int                        { return 0 }
+func (c *Config) MaxTweets2Keep() int                 { return 5 }
+func (c *Config) TweetExpireTimeMinutes() int         { return 10 }
+func (c *Config) UserFeedExpireTimeMinutes() int      { return 10 }
+func (c *Config) TweetTimelineExpireTimeMinutes() int { return 5 }
+func (c *Config) MaxTweetsTimelineItems() int         { return 3 }
+func (c *Config) FanoutFollowersThreshold() int       { return 0 }
+func (c *Config) FanoutBatchSize() int                { return 2 }
+
+// Backend is an empty cache made with the Config and a way to move its clock forward, so the TTLs run out
+type Backend struct {
+	Cache       cache.Cache
+	FastForward func(d time.Duration)
+}
+
+// Run runs the suite, newBackend is called for every test
+func Run(t *testing.T, newBackend func(t *testing.T) Backend) {
+	tests := map[string]func(t *testing.T, b Backend){
+		"Tweets":         testTweets,
+		"TweetExpires":   testTweetExpires,
+		"DeleteTweet":    testDeleteTweet,
+		"Timeline":       testTimeline,
+		"TimelineTTL":    testTimelineTTL,
+		"StoreTimeline":  testStoreTimeline,
+		"Retweets":       testRetweets,
+		"MergeToFeed":    testMergeToFeed,
+		"HighFanout":     testHighFanout,
+		"Following":      testFollowing,
+		"FollowersAmong": testFollowersAmong,
+		"Followers":      testFollowers,
+		"UnfollowPurges": testUnfollowPurges,
+		"Likes":          testLikes,
+		"DeadLetters":    testDeadLetters,
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			test(t, newBackend(t))
+		})
+	}
+}
+
+func newTweet(id, userID int64) twitter.Tweet {
+	return twitter.Tweet{
+		ID:        id,
+		UserID:    userID,
+		Content:   "hello",
+		CreatedAt: time.Date(2025, 1, 1, 0, 0, int(id), 0, time.UTC),
+		Kind:      twitter.TweetKindOriginal,
+	}
+}
+
+func testTweets(t *testing.T, b Backend) {
+	ctx := context.Background()
+	c := b.Cache
+
+	for id := int64(1); id <= 4; id++ {
+		require.NoError(t, c.PushTweet(ctx, newTweet(id, 1)))
+	}
+	tweet, err := c.GetTweet(ctx, 2)
+	require.NoError(t, err)
+	require.Equal(t, newTweet(2, 1), tweet)
+
+	// only as many as fit into a timeline, newest first
+	ids, err := c.GetUserTweets(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, []int64{4, 3, 2}, ids)
+
+	// pushed again it's listed once
+	require.NoError(t, c.PushTweet(ctx, newTweet(4, 1)))
+	ids, err = c.GetUserTweets(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, []int64{4, 3, 2}, ids)
+	ids, err = c.GetUserTweets(ctx, 2)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	edited := newTweet(2, 1)
+	edited.Content = "edited"
+	require.NoError(t, c.UpdateTweet(ctx, edited))
+	tweet, err = c.GetTweet(ctx, 2)
+	require.NoError(t, err)
+	require.Equal(t, "edited", tweet.Content)
+
+	// not cached, so it's not put there
+	require.NoError(t, c.UpdateTweet(ctx, newTweet(10, 1)))
+	_, err = c.GetTweet(ctx, 10)
+	require.Error(t, err)
+}
+
+func testTweetExpires(t *testing.T, b Backend) {
+	ctx := context.Background()
+	c := b.Cache
+
+	require.NoError(t, c.PushTweet(ctx, newTweet(1, 1)))
+	b.FastForward(9 * time.Minute)
+	require.NoError(t, c.UpdateTweet(ctx, newTweet(1, 1))) // keeps the TTL
+	b.FastForward(2 * time.Minute)
+
+	_, err := c.GetTweet(ctx, 1)
+	require.Error(t, err)
+	ids, err := c.GetUserTweets(ctx, 1)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+}
+
+func testDeleteTweet(t *testing.T, b Backend) {
+	ctx := context.Background()
+	c := b.Cache
+
+	require.NoError(t, c.PushTweet(ctx, newTweet(1, 1)))
+	require.NoError(t, c.PushTweet(ctx, newTweet(2, 1)))
+	require.NoError(t, c.SetLikes(ctx, map[int64]int64{1: 3}, nil))
+	_, err := c.PushToUserFeeds(ctx, []int64{10, 11}, 1)
+	require.NoError(t, err)
+	require.NoError(t, c.PushToUserFeed(ctx, 10, 2))
+
+	require.NoError(t, c.DeleteTweet(ctx, newTweet(1, 1), []int64{10, 11}))
+
+	_, err = c.GetTweet(ctx, 1)
+	require.Error(t, err)
+	likes, err := c.GetLikes(ctx, []int64{1})
+	require.NoError(t, err)
+	require.Empty(t, likes.Likes)
+	ids, err := c.GetUserTweets(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, []int64{2}, ids)
+
+	timeline, err := c.GetUserTimeline(ctx, 10, twitter.Cursor{Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []int64{2}, timeline)
+	// the last one is gone, so is the timeline
+	exists, err := c.CheckUserTimelineExists(ctx, 11)
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func testTimeline(t *testing.T, b Backend) {
+	ctx := context.Background()
+	c := b.Cache
+
+	exists, err := c.CheckUserTimelineExists(ctx, 1)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	for _, id := range []int64{10, 20, 30, 40} {
+		require.NoError(t, c.PushToUserFeed(ctx, 1, id))
+	}
+	exists, err = c.CheckUserTimelineExists(ctx, 1)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	// trimmed to the latest ones, oldest first
+	timeline, err := c.GetUserTimeline(ctx, 1, twitter.Cursor{Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []int64{20, 30, 40}, timeline)
+
+	timeline, err = c.GetUserTimeline(ctx, 1, twitter.Cursor{Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []int64{30, 40}, timeline)
+
+	timeline, err = c.GetUserTimeline(ctx, 1, twitter.Cursor{Before: 40, Limit: 1})
+	require.NoError(t, err)
+	require.Equal(t, []int64{30}, timeline)
+
+	timeline, err = c.GetUserTimeline(ctx, 1, twitter.Cursor{After: 20, Limit: 1})
+	require.NoError(t, err)
+	require.Equal(t, []int64{30}, timeline)
+
+	failed, err := c.PushToUserFeeds(ctx, []int64{1, 2, 3}, 50)
+	require.NoError(t, err)
+	require.Empty(t, failed)
+	for _, userID := range []int64{2, 3} {
+		timeline, err = c.GetUserTimeline(ctx, userID, twitter.Cursor{Limit: 10})
+		require.NoError(t, err)
+		require.Equal(t, []int64{50}, timeline)
+	}
+	timeline, err = c.GetUserTimeline(ctx, 1, twitter.Cursor{Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []int64{30, 40, 50}, timeline)
+
+	// a retried push doesn't list the tweet twice
+	require.NoError(t, c.PushToUserFeed(ctx, 1, 50))
+	failed, err = c.PushToUserFeeds(ctx, []int64{1, 2}, 50)
+	require.NoError(t, err)
+	require.Empty(t, failed)
+	timeline, err = c.GetUserTimeline(ctx, 1, twitter.Cursor{Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []int64{30, 40, 50}, timeline)
+	timeline, err = c.GetUserTimeline(ctx, 2, twitter.Cursor{Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []int64{50}, timeline)
+}
+
+func testTimelineTTL(t *testing.T, b Backend) {
+	ctx := context.Background()
+	c := b.Cache
+
+	require.NoError(t, c.PushToUserFeed(ctx, 1, 10))
+	b.FastForward(4 * time.Minute)
+	require.NoError(t, c.PushToUserFeed(ctx, 1, 20)) // a push refreshes the TTL
+	b.FastForward(4 * time.Minute)
+
+	exists, err := c.CheckUserTimelineExists(ctx, 1)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	b.FastForward(2 * time.Minute)
+	exists, err = c.CheckUserTimelineExists(ctx, 1)
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func testStoreTimeline(t *testing.T, b Backend) {
+	ctx := context.Background()
+	c := b.Cache
+
+	// oldest first, as the websocket server stores it
+	require.NoError(t, c.StoreTimeline(ctx, 1, []twitter.Tweet{newTweet(1, 2), newTweet(2, 2), newTweet(3, 2)}))
+	timeline, err := c.GetUserTimeline(ctx, 1, twitter.Cursor{Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []int64{1, 2, 3}, timeline)
+}
+
+func testRetweets(t *testing.T, b Backend) {
+	ctx := context.Background()
+	c := b.Cache
+
+	require.NoError(t, c.PushToUserFeed(ctx, 1, 10))
+
+	// original is already there
+	pushed, err := c.PushRetweetToUserFeed(ctx, 1, 20, 10)
+	require.NoError(t, err)
+	require.False(t, pushed)
+
+	pushed, err = c.PushRetweetToUserFeed(ctx, 1, 21, 11)
+	require.NoError(t, err)
+	require.True(t, pushed)
+
+	// another retweet of the same original
+	pushed, err = c.PushRetweetToUserFeed(ctx, 1, 22, 11)
+	require.NoError(t, err)
+	require.False(t, pushed)
+
+	timeline, err := c.GetUserTimeline(ctx, 1, twitter.Cursor{Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []int64{10, 21}, timeline)
+}
+
+func testMergeToFeed(t *testing.T, b Backend) {
+	ctx := context.Background()
+	c := b.Cache
+
+	// not cached, it's loaded in full later
+	require.NoError(t, c.MergeToUserFeed(ctx, 1, []int64{5}))
+	exists, err := c.CheckUserTimelineExists(ctx, 1)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	require.NoError(t, c.PushToUserFeed(ctx, 1, 10))
+	require.NoError(t, c.PushToUserFeed(ctx, 1, 30))
+	require.NoError(t, c.MergeToUserFeed(ctx, 1, []int64{40, 20, 10, 5}))
+
+	timeline, err := c.GetUserTimeline(ctx, 1, twitter.Cursor{Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []int64{20, 30, 40}, timeline)
+}
+
+func testHighFanout(t *testing.T, b Backend) {
+	ctx := context.Background()
+	c := b.Cache
+
+	for _, tweet := range []twitter.Tweet{newTweet(15, 2), newTweet(25, 2), newTweet(35, 3)} {
+		require.NoError(t, c.PushTweet(ctx, tweet))
+	}
+	require.NoError(t, c.SetHighFanout(ctx, 2, true))
+	require.NoError(t, c.SetHighFanout(ctx, 3, true))
+	require.NoError(t, c.SetHighFanout(ctx, 3, false))
+	high, err := c.IsHighFanout(ctx, 2)
+	require.NoError(t, err)
+	require.True(t, high)
+	high, err = c.IsHighFanout(ctx, 3)
+	require.NoError(t, err)
+	require.False(t, high)
+
+	merged, err := c.MergeHighFanoutTweets(ctx, []int64{10, 20, 30}, []int64{2, 3}, twitter.Cursor{Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []int64{10, 15, 20, 25, 30}, merged)
+
+	merged, err = c.MergeHighFanoutTweets(ctx, []int64{10, 20}, []int64{2}, twitter.Cursor{Before: 25, Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []int64{15, 20}, merged)
+
+	merged, err = c.MergeHighFanoutTweets(ctx, []int64{20, 30}, []int64{2}, twitter.Cursor{After: 10, Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []int64{15, 20}, merged)
+
+	merged, err = c.MergeHighFanoutTweets(ctx, []int64{10}, []int64{3}, twitter.Cursor{Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []int64{10}, merged)
+}
+
+func testFollowing(t *testing.T, b Backend) {
+	ctx := context.Background()
+	c := b.Cache
+
+	require.NoError(t, c.SetHighFanout(ctx, 2, true))
+	require.NoError(t, c.SetHighFanout(ctx, 4, true))
+
+	following, err := c.GetHighFanoutFollowing(ctx, 1)
+	require.NoError(t, err)
+	require.False(t, following.Cached)
+
+	require.NoError(t, c.SetFollowing(ctx, 1, []int64{2, 3, 4}, following.Version))
+	following, err = c.GetHighFanoutFollowing(ctx, 1)
+	require.NoError(t, err)
+	require.True(t, following.Cached)
+	require.Equal(t, []int64{2, 4}, following.Followees)
+
+	// high fan-out is read every time, only the followings are cached
+	require.NoError(t, c.SetHighFanout(ctx, 4, false))
+	following, err = c.GetHighFanoutFollowing(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, []int64{2}, following.Followees)
+
+	// a user who follows nobody is cached too
+	require.NoError(t, c.SetFollowing(ctx, 5, nil, 0))
+	following, err = c.GetHighFanoutFollowing(ctx, 5)
+	require.NoError(t, err)
+	require.True(t, following.Cached)
+	require.Empty(t, following.Followees)
+
+	// the followings read before a follow are not cached
+	stale, err := c.GetHighFanoutFollowing(ctx, 6)
+	require.NoError(t, err)
+	require.NoError(t, c.FollowUser(ctx, twitter.Follow{FollowerID: 6, FolloweeID: 2}))
+	require.NoError(t, c.SetFollowing(ctx, 6, nil, stale.Version))
+	following, err = c.GetHighFanoutFollowing(ctx, 6)
+	require.NoError(t, err)
+	require.False(t, following.Cached)
+	require.NoError(t, c.SetFollowing(ctx, 6, []int64{2}, following.Version))
+	following, err = c.GetHighFanoutFollowing(ctx, 6)
+	require.NoError(t, err)
+	require.Equal(t, []int64{2}, following.Followees)
+
+	// so are the ones read before an unfollow
+	require.NoError(t, c.UnfollowUser(ctx, twitter.Follow{FollowerID: 6, FolloweeID: 2}, nil))
+	following, err = c.GetHighFanoutFollowing(ctx, 6)
+	require.NoError(t, err)
+	require.False(t, following.Cached)
+
+	b.FastForward(10 * time.Minute)
+	following, err = c.GetHighFanoutFollowing(ctx, 1)
+	require.NoError(t, err)
+	require.False(t, following.Cached)
+}
+
+func testFollowersAmong(t *testing.T, b Backend) {
+	ctx := context.Background()
+	c := b.Cache
+
+	followers, uncached, err := c.FollowersAmong(ctx, 2, nil)
+	require.NoError(t, err)
+	require.Empty(t, followers)
+	require.Empty(t, uncached)
+
+	require.NoError(t, c.SetFollowing(ctx, 1, []int64{2, 3}, 0))
+	require.NoError(t, c.SetFollowing(ctx, 3, []int64{4}, 0))
+	require.NoError(t, c.SetFollowing(ctx, 4, nil, 0))
+	followers, uncached, err = c.FollowersAmong(ctx, 2, []int64{1, 3, 4, 5})
+	require.NoError(t, err)
+	require.Equal(t, []int64{1}, followers)
+	require.Equal(t, []int64{5}, uncached)
+
+	// a follow drops the followings, they are not known until they are set again
+	require.NoError(t, c.FollowUser(ctx, twitter.Follow{FollowerID: 3, FolloweeID: 2}))
+	followers, uncached, err = c.FollowersAmong(ctx, 2, []int64{1, 3})
+	require.NoError(t, err)
+	require.Equal(t, []int64{1}, followers)
+	require.Equal(t, []int64{3}, uncached)
+}
+
+func testFollowers(t *testing.T, b Backend) {
+	ctx := context.Background()
+	c := b.Cache
+
+	followers, err := c.GetFollowers(ctx, 1)
+	require.NoError(t, err)
+	require.Empty(t, followers)
+
+	require.NoError(t, c.SetFollowers(ctx, 1, []twitter.User{{ID: 2}, {ID: 3}}))
+	require.NoError(t, c.FollowUser(ctx, twitter.Follow{FollowerID: 4, FolloweeID: 1}))
+	// the same follow again changes nothing
+	require.NoError(t, c.FollowUser(ctx, twitter.Follow{FollowerID: 4, FolloweeID: 1}))
+	followers, err = c.GetFollowers(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, []int64{2, 3, 4}, followers)
+	count, err := c.CountFollowers(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+
+	// overwritten
+	require.NoError(t, c.SetFollowers(ctx, 1, []twitter.User{{ID: 5}}))
+	followers, err = c.GetFollowers(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, []int64{5}, followers)
+}
+
+func testUnfollowPurges(t *testing.T, b Backend) {
+	ctx := context.Background()
+	c := b.Cache
+
+	original := newTweet(1, 3)
+	retweet := newTweet(2, 2)
+	retweet.Kind = twitter.TweetKindRetweet
+	retweet.ReferencedID = &original.ID
+	for _, tweet := range []twitter.Tweet{original, retweet, newTweet(3, 4), newTweet(4, 2)} {
+		require.NoError(t, c.PushTweet(ctx, tweet))
+	}
+	require.NoError(t, c.FollowUser(ctx, twitter.Follow{FollowerID: 1, FolloweeID: 2}))
+	pushed, err := c.PushRetweetToUserFeed(ctx, 1, retweet.ID, original.ID)
+	require.NoError(t, err)
+	require.True(t, pushed)
+	require.NoError(t, c.PushToUserFeed(ctx, 1, 3))
+	require.NoError(t, c.PushToUserFeed(ctx, 1, 4))
+	// the cached copy of this one is gone, the tweet is still passed
+	require.NoError(t, c.PushToUserFeed(ctx, 1, 6))
+
+	followeeTweets := []twitter.Tweet{newTweet(6, 2), newTweet(4, 2), retweet}
+	require.NoError(t, c.UnfollowUser(ctx, twitter.Follow{FollowerID: 1, FolloweeID: 2}, followeeTweets))
+
+	followers, err := c.GetFollowers(ctx, 2)
+	require.NoError(t, err)
+	require.Empty(t, followers)
+	timeline, err := c.GetUserTimeline(ctx, 1, twitter.Cursor{Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []int64{3}, timeline)
+
+	// the original can come again with someone else's retweet
+	pushed, err = c.PushRetweetToUserFeed(ctx, 1, 5, original.ID)
+	require.NoError(t, err)
+	require.True(t, pushed)
+}
+
+func testLikes(t *testing.T, b Backend) {
+	ctx := context.Background()
+	c := b.Cache
+
+	// nothing is cached, the versions are passed back to SetLikes
+	likes, err := c.GetLikes(ctx, []int64{1, 2})
+	require.NoError(t, err)
+	require.Empty(t, likes.Likes)
+	require.Len(t, likes.Versions, 2)
+	require.NoError(t, c.SetLikes(ctx, map[int64]int64{1: 5, 2: 0}, likes.Versions))
+	likes, err = c.GetLikes(ctx, []int64{1, 2, 3})
+	require.NoError(t, err)
+	require.Equal(t, map[int64]int64{1: 5, 2: 0}, likes.Likes)
+	require.Len(t, likes.Versions, 1)
+
+	// a like drops the counter, it's counted again
+	require.NoError(t, c.DropLikes(ctx, 1))
+	likes, err = c.GetLikes(ctx, []int64{1, 2})
+	require.NoError(t, err)
+	require.Equal(t, map[int64]int64{2: 0}, likes.Likes)
+
+	// liked while it was counted, the older count is not cached
+	require.NoError(t, c.DropLikes(ctx, 1))
+	require.NoError(t, c.SetLikes(ctx, map[int64]int64{1: 5}, likes.Versions))
+	likes, err = c.GetLikes(ctx, []int64{1})
+	require.NoError(t, err)
+	require.Empty(t, likes.Likes)
+	require.NoError(t, c.SetLikes(ctx, map[int64]int64{1: 6}, likes.Versions))
+	likes, err = c.GetLikes(ctx, []int64{1})
+	require.NoError(t, err)
+	require.Equal(t, map[int64]int64{1: 6}, likes.Likes)
+
+	b.FastForward(11 * time.Minute)
+	likes, err = c.GetLikes(ctx, []int64{1, 2})
+	require.NoError(t, err)
+	require.Empty(t, likes.Likes)
+}
+
+func testDeadLetters(t *testing.T, b Backend) {
+	ctx := context.Background()
+	c := b.Cache
+
+	failedAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	second := cache.DeadLetter{UserID: 2, Tweet: newTweet(1, 1), Error: "timeout", FailedAt: failedAt.Add(time.Minute)}
+	first := cache.DeadLetter{UserID: 3, Tweet: newTweet(1, 1), Error: "timeout", FailedAt: failedAt}
+	require.NoError(t, c.PushDeadLetter(ctx, second))
+	require.NoError(t, c.PushDeadLetter(ctx, first))
+	// the same delivery is kept once
+	require.NoError(t, c.PushDeadLetter(ctx, second))
+
+	letters, err := c.GetDeadLetters(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []cache.DeadLetter{first, second}, letters)
+
+	require.NoError(t, c.RemoveDeadLetter(ctx, first))
+	letters, err = c.GetDeadLetters(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []cache.DeadLetter{second}, letters)
+}
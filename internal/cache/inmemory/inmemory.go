@@ -0,0 +1,609 @@
+package inmemory
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+	"twitter-clone/internal/domain/cache"
+	"twitter-clone/internal/domain/config"
+	"twitter-clone/internal/domain/twitter"
+)
+
+// InMemoryCache keeps the same data the Redis cache does, but in the process memory,
+// so the services can run without Redis. Lists are newest first as in Redis,
+// tweets and dead letters are kept as json, so they come back the same way they do from Redis.
+// Expired entries are dropped when they are accessed
+type InMemoryCache struct {
+	mu  sync.Mutex
+	now func() time.Time
+
+	tweets            map[int64]entry[[]byte]
+	likes             map[int64]entry[int64]
+	likeVersions      map[int64]int64
+	global            []int64
+	userTweets        map[int64]entry[[]int64]
+	timelines         map[int64]entry[[]int64]
+	timelineRefs      map[int64]entry[map[int64]struct{}]
+	followers         map[int64][]int64 // oldest first, the order GetFollowers returns them
+	highFanout        map[int64]struct{}
+	following         map[int64]entry[map[int64]struct{}]
+	followingVersions map[int64]int64
+	deadLetters       map[string][]byte
+
+	maxTweets2Keep  int
+	tweetExpireTime time.Duration
+
+	maxTweetsTimelineItems  int
+	tweetTimelineExpireTime time.Duration
+}
+
+// entry is a value with a TTL, zero expiresAt never expires
+type entry[T any] struct {
+	value     T
+	expiresAt time.Time
+}
+
+func NewInMemoryCache(config config.CacheConfig) *InMemoryCache {
+	return &InMemoryCache{
+		now:                     time.Now,
+		tweets:                  make(map[int64]entry[[]byte]),
+		likes:                   make(map[int64]entry[int64]),
+		likeVersions:            make(map[int64]int64),
+		userTweets:              make(map[int64]entry[[]int64]),
+		timelines:               make(map[int64]entry[[]int64]),
+		timelineRefs:            make(map[int64]entry[map[int64]struct{}]),
+		followers:               make(map[int64][]int64),
+		highFanout:              make(map[int64]struct{}),
+		following:               make(map[int64]entry[map[int64]struct{}]),
+		followingVersions:       make(map[int64]int64),
+		deadLetters:             make(map[string][]byte),
+		maxTweets2Keep:          config.MaxTweets2Keep(),
+		tweetExpireTime:         time.Duration(config.TweetExpireTimeMinutes()) * time.Minute,
+		maxTweetsTimelineItems:  config.MaxTweetsTimelineItems(),
+		tweetTimelineExpireTime: time.Duration(config.TweetTimelineExpireTimeMinutes()) * time.Minute,
+	}
+}
+
+// lookup returns the entry unless it's missing or expired, the expired one is removed
+func lookup[T any](c *InMemoryCache, m map[int64]entry[T], key int64) (entry[T], bool) {
+	e, ok := m[key]
+	if ok && !e.expiresAt.IsZero() && !c.now().Before(e.expiresAt) {
+		delete(m, key)
+		return entry[T]{}, false
+	}
+	return e, ok
+}
+
+func (c *InMemoryCache) expiresAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return c.now().Add(ttl)
+}
+
+// push does LPUSH and LTRIM 0 stop, the list is removed once it's empty as Redis does it
+func (c *InMemoryCache) push(m map[int64]entry[[]int64], key int64, id int64, stop int, ttl time.Duration) {
+	e, _ := lookup(c, m, key)
+	e.value = trim(append([]int64{id}, e.value...), stop)
+	e.expiresAt = c.expiresAt(ttl)
+	store(m, key, e)
+}
+
+// remove does LREM key 0 id
+func (c *InMemoryCache) remove(m map[int64]entry[[]int64], key int64, id int64) {
+	if e, ok := lookup(c, m, key); ok {
+		e.value = slices.DeleteFunc(e.value, func(v int64) bool {
+			return v == id
+		})
+		store(m, key, e)
+	}
+}
+
+func store(m map[int64]entry[[]int64], key int64, e entry[[]int64]) {
+	if len(e.value) == 0 {
+		delete(m, key)
+		return
+	}
+	m[key] = e
+}
+
+// trim keeps the list from 0 to stop, negative stop counts from the end
+func trim(list []int64, stop int) []int64 {
+	if stop < 0 {
+		stop += len(list)
+	}
+	if stop < 0 {
+		return nil
+	}
+	if stop >= len(list) {
+		return list
+	}
+	return list[:stop+1]
+}
+
+func (c *InMemoryCache) PushTweet(ctx context.Context, tweet twitter.Tweet) error {
+	data, err := json.Marshal(tweet)
+	if err != nil {
+		return fmt.Errorf("failed to marshal tweet %v: %v", tweet.ID, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tweets[tweet.ID] = entry[[]byte]{value: data, expiresAt: c.expiresAt(c.tweetExpireTime)}
+	// pushed again it's not listed twice
+	global := slices.DeleteFunc(c.global, func(id int64) bool {
+		return id == tweet.ID
+	})
+	c.global = trim(append([]int64{tweet.ID}, global...), c.maxTweets2Keep-1)
+	c.remove(c.userTweets, tweet.UserID, tweet.ID)
+	c.push(c.userTweets, tweet.UserID, tweet.ID, c.maxTweetsTimelineItems-1, c.tweetExpireTime)
+	return nil
+}
+
+func (c *InMemoryCache) PushToUserFeed(ctx context.Context, userID, tweetID int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(c.timelines, userID, tweetID)
+	c.push(c.timelines, userID, tweetID, c.maxTweetsTimelineItems-1, c.tweetTimelineExpireTime)
+	return nil
+}
+
+// nothing can fail in memory, so no users are returned
+func (c *InMemoryCache) PushToUserFeeds(ctx context.Context, userIDs []int64, tweetID int64) ([]int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, userID := range userIDs {
+		c.remove(c.timelines, userID, tweetID)
+		c.push(c.timelines, userID, tweetID, c.maxTweetsTimelineItems-1, c.tweetTimelineExpireTime)
+	}
+	return nil, nil
+}
+
+func (c *InMemoryCache) PushRetweetToUserFeed(ctx context.Context, userID, retweetID, originalID int64) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if timeline, ok := lookup(c, c.timelines, userID); ok && slices.Contains(timeline.value, originalID) {
+		return false, nil // original is already there
+	}
+	refs, ok := lookup(c, c.timelineRefs, userID)
+	if !ok {
+		refs.value = make(map[int64]struct{})
+	}
+	if _, ok = refs.value[originalID]; ok {
+		return false, nil // someone else's retweet of it is there
+	}
+	refs.value[originalID] = struct{}{}
+	refs.expiresAt = c.expiresAt(c.tweetTimelineExpireTime)
+	c.timelineRefs[userID] = refs
+
+	c.push(c.timelines, userID, retweetID, c.maxTweetsTimelineItems-1, c.tweetTimelineExpireTime)
+	return true, nil
+}
+
+func (c *InMemoryCache) MergeToUserFeed(ctx context.Context, userID int64, tweetIDs []int64) error {
+	if len(tweetIDs) == 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	timeline, ok := lookup(c, c.timelines, userID)
+	if !ok {
+		return nil // a missing one is loaded in full on connect
+	}
+	merged := append(slices.Clone(timeline.value), tweetIDs...)
+	slices.SortFunc(merged, func(a, b int64) int {
+		return cmp.Compare(b, a)
+	})
+	merged = slices.Compact(merged)
+	timeline.value = merged[:min(len(merged), c.maxTweetsTimelineItems)]
+	timeline.expiresAt = c.expiresAt(c.tweetTimelineExpireTime)
+	store(c.timelines, userID, timeline)
+	return nil
+}
+
+func (c *InMemoryCache) GetUserTweets(ctx context.Context, userID int64) ([]int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tweets, _ := lookup(c, c.userTweets, userID)
+	return append([]int64{}, tweets.value...), nil
+}
+
+func (c *InMemoryCache) GetTweet(ctx context.Context, tweetID int64) (twitter.Tweet, error) {
+	c.mu.Lock()
+	data, ok := lookup(c, c.tweets, tweetID)
+	c.mu.Unlock()
+
+	var tweet twitter.Tweet
+	if !ok {
+		return tweet, fmt.Errorf("failed to get tweet %v: not cached", tweetID)
+	}
+	if err := json.Unmarshal(data.value, &tweet); err != nil {
+		return tweet, fmt.Errorf("failed to unmarshal tweet %v: %v", tweetID, err)
+	}
+	return tweet, nil
+}
+
+func (c *InMemoryCache) DeleteTweet(ctx context.Context, tweet twitter.Tweet, followers []int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.tweets, tweet.ID)
+	delete(c.likes, tweet.ID)
+	c.global = slices.DeleteFunc(c.global, func(id int64) bool {
+		return id == tweet.ID
+	})
+	c.remove(c.userTweets, tweet.UserID, tweet.ID)
+	for _, followerID := range followers {
+		c.remove(c.timelines, followerID, tweet.ID)
+	}
+	return nil
+}
+
+// the tweet is not put back if it already expired, the TTL stays as it is
+func (c *InMemoryCache) UpdateTweet(ctx context.Context, tweet twitter.Tweet) error {
+	data, err := json.Marshal(tweet)
+	if err != nil {
+		return fmt.Errorf("failed to marshal tweet %v: %v", tweet.ID, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, ok := lookup(c, c.tweets, tweet.ID); ok {
+		cached.value = data
+		c.tweets[tweet.ID] = cached
+	}
+	return nil
+}
+
+func (c *InMemoryCache) SetActiveUser(ctx context.Context, userID int64, ttl time.Duration) error {
+	return nil
+}
+
+func (c *InMemoryCache) GetActiveUsers(ctx context.Context) ([]string, error) {
+	return nil, nil
+}
+
+/////////////////////////////////////
+//	Timeline / Feed
+////////////////////////////////////
+
+func (c *InMemoryCache) GetUserTimeline(ctx context.Context, userID int64, cursor twitter.Cursor) ([]int64, error) {
+	c.mu.Lock()
+	timeline, _ := lookup(c, c.timelines, userID)
+	c.mu.Unlock()
+	newest := timeline.value
+
+	result := make([]int64, 0, len(newest))
+	if cursor.After != 0 {
+		for i := len(newest) - 1; i >= 0 && len(result) < cursor.Limit; i-- {
+			if newest[i] > cursor.After {
+				result = append(result, newest[i])
+			}
+		}
+		return result, nil
+	}
+	for _, id := range newest {
+		if len(result) == cursor.Limit {
+			break
+		}
+		if cursor.Before != 0 && id >= cursor.Before {
+			continue
+		}
+		result = append(result, id)
+	}
+	slices.Reverse(result)
+	return result, nil
+}
+
+func (c *InMemoryCache) CheckUserTimelineExists(ctx context.Context, userID int64) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := lookup(c, c.timelines, userID)
+	return ok, nil
+}
+
+func (c *InMemoryCache) StoreTimeline(ctx context.Context, userID int64, tweets []twitter.Tweet) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, tweet := range tweets {
+		c.push(c.timelines, userID, tweet.ID, c.maxTweetsTimelineItems, c.tweetTimelineExpireTime)
+	}
+	return nil
+}
+
+/////////////////////////////////////
+//	Dead letters
+////////////////////////////////////
+
+func deadLetterKey(letter cache.DeadLetter) string {
+	return fmt.Sprintf("%d:%d", letter.UserID, letter.Tweet.ID)
+}
+
+func (c *InMemoryCache) PushDeadLetter(ctx context.Context, letter cache.DeadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter of tweet %v: %v", letter.Tweet.ID, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deadLetters[deadLetterKey(letter)] = data
+	return nil
+}
+
+// oldest failures first
+func (c *InMemoryCache) GetDeadLetters(ctx context.Context) ([]cache.DeadLetter, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	letters := make([]cache.DeadLetter, 0, len(c.deadLetters))
+	for key, data := range c.deadLetters {
+		var letter cache.DeadLetter
+		if err := json.Unmarshal(data, &letter); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal dead letter %v: %v", key, err)
+		}
+		letters = append(letters, letter)
+	}
+	slices.SortFunc(letters, func(a, b cache.DeadLetter) int {
+		return a.FailedAt.Compare(b.FailedAt)
+	})
+	return letters, nil
+}
+
+func (c *InMemoryCache) RemoveDeadLetter(ctx context.Context, letter cache.DeadLetter) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.deadLetters, deadLetterKey(letter))
+	return nil
+}
+
+/////////////////////////////////////
+//	Likes
+////////////////////////////////////
+
+// counter is counted again after a like, see the Redis cache
+func (c *InMemoryCache) DropLikes(ctx context.Context, tweetID int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.likes, tweetID)
+	c.likeVersions[tweetID]++
+	return nil
+}
+
+func (c *InMemoryCache) GetLikes(ctx context.Context, tweetIDs []int64) (cache.LikeCounters, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := cache.LikeCounters{
+		Likes:    make(map[int64]int64, len(tweetIDs)),
+		Versions: make(map[int64]int64),
+	}
+	for _, id := range tweetIDs {
+		if likes, ok := lookup(c, c.likes, id); ok {
+			result.Likes[id] = likes.value
+		} else {
+			result.Versions[id] = c.likeVersions[id]
+		}
+	}
+	return result, nil
+}
+
+func (c *InMemoryCache) SetLikes(ctx context.Context, likes map[int64]int64, versions map[int64]int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for tweetID, count := range likes {
+		if c.likeVersions[tweetID] != versions[tweetID] {
+			continue // dropped since it was read
+		}
+		c.likes[tweetID] = entry[int64]{value: count, expiresAt: c.expiresAt(c.tweetExpireTime)}
+	}
+	return nil
+}
+
+/////////////////////////////////////
+//	Hybrid fan-out
+////////////////////////////////////
+
+func (c *InMemoryCache) SetHighFanout(ctx context.Context, userID int64, high bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if high {
+		c.highFanout[userID] = struct{}{}
+	} else {
+		delete(c.highFanout, userID)
+	}
+	return nil
+}
+
+func (c *InMemoryCache) IsHighFanout(ctx context.Context, userID int64) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, high := c.highFanout[userID]
+	return high, nil
+}
+
+func (c *InMemoryCache) MergeHighFanoutTweets(ctx context.Context, timeline []int64, followees []int64, cursor twitter.Cursor) ([]int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	merged := slices.Clone(timeline)
+	found := false
+	for _, followeeID := range followees {
+		if _, ok := c.highFanout[followeeID]; !ok {
+			continue
+		}
+		found = true
+		tweets, _ := lookup(c, c.userTweets, followeeID)
+		for _, id := range tweets.value {
+			if (cursor.Before != 0 && id >= cursor.Before) || (cursor.After != 0 && id <= cursor.After) {
+				continue
+			}
+			merged = append(merged, id)
+		}
+	}
+	if !found {
+		return timeline, nil
+	}
+	slices.Sort(merged)
+	merged = slices.Compact(merged)
+	if len(merged) <= cursor.Limit {
+		return merged, nil
+	}
+	// going forward the page starts right after the cursor
+	if cursor.After != 0 {
+		return merged[:cursor.Limit], nil
+	}
+	return merged[len(merged)-cursor.Limit:], nil
+}
+
+// followings are dropped by a follow or an unfollow, see the Redis cache
+func (c *InMemoryCache) GetHighFanoutFollowing(ctx context.Context, userID int64) (cache.HighFanoutFollowing, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	following, ok := lookup(c, c.following, userID)
+	if !ok {
+		return cache.HighFanoutFollowing{Version: c.followingVersions[userID]}, nil
+	}
+	result := cache.HighFanoutFollowing{Cached: true, Version: c.followingVersions[userID]}
+	for followeeID := range following.value {
+		if _, ok := c.highFanout[followeeID]; ok {
+			result.Followees = append(result.Followees, followeeID)
+		}
+	}
+	slices.Sort(result.Followees)
+	return result, nil
+}
+
+func (c *InMemoryCache) SetFollowing(ctx context.Context, userID int64, followees []int64, version int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.followingVersions[userID] != version {
+		return nil // dropped since it was read
+	}
+	following := make(map[int64]struct{}, len(followees))
+	for _, id := range followees {
+		following[id] = struct{}{}
+	}
+	c.following[userID] = entry[map[int64]struct{}]{value: following, expiresAt: c.expiresAt(c.tweetTimelineExpireTime)}
+	return nil
+}
+
+func (c *InMemoryCache) FollowersAmong(ctx context.Context, followeeID int64, userIDs []int64) ([]int64, []int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var followers, uncached []int64
+	for _, userID := range userIDs {
+		following, ok := lookup(c, c.following, userID)
+		if !ok {
+			uncached = append(uncached, userID)
+			continue
+		}
+		if _, ok := following.value[followeeID]; ok {
+			followers = append(followers, userID)
+		}
+	}
+	return followers, uncached, nil
+}
+
+func (c *InMemoryCache) dropFollowing(userID int64) {
+	delete(c.following, userID)
+	c.followingVersions[userID]++
+}
+
+/////////////////////////////////////
+//	Follower
+////////////////////////////////////
+
+func (c *InMemoryCache) GetFollowers(ctx context.Context, userID int64) ([]int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]int64{}, c.followers[userID]...), nil
+}
+
+func (c *InMemoryCache) CountFollowers(ctx context.Context, userID int64) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.followers[userID]), nil
+}
+
+// It overwrites the followers list as the Redis cache does
+func (c *InMemoryCache) SetFollowers(ctx context.Context, userID int64, followers []twitter.User) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ids := make([]int64, len(followers))
+	for i, follower := range followers {
+		ids[i] = follower.ID
+	}
+	if len(ids) == 0 {
+		delete(c.followers, userID)
+		return nil
+	}
+	c.followers[userID] = ids
+	return nil
+}
+
+func (c *InMemoryCache) FollowUser(ctx context.Context, follow twitter.Follow) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// followed again the follower is listed once
+	followers := slices.DeleteFunc(c.followers[follow.FolloweeID], func(id int64) bool {
+		return id == follow.FollowerID
+	})
+	c.followers[follow.FolloweeID] = append(followers, follow.FollowerID)
+	c.dropFollowing(follow.FollowerID)
+	return nil
+}
+
+// the followee's tweets are the ones passed, the cached copies may have expired
+func (c *InMemoryCache) UnfollowUser(ctx context.Context, follow twitter.Follow, tweets []twitter.Tweet) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.followers[follow.FolloweeID] = slices.DeleteFunc(c.followers[follow.FolloweeID], func(id int64) bool {
+		return id == follow.FollowerID
+	})
+	if len(c.followers[follow.FolloweeID]) == 0 {
+		delete(c.followers, follow.FolloweeID)
+	}
+	c.dropFollowing(follow.FollowerID)
+
+	for _, tweet := range tweets {
+		if tweet.UserID != follow.FolloweeID {
+			continue
+		}
+		c.remove(c.timelines, follow.FollowerID, tweet.ID)
+		// the original can be delivered again by someone else's retweet
+		if tweet.Kind == twitter.TweetKindRetweet && tweet.ReferencedID != nil {
+			if refs, ok := lookup(c, c.timelineRefs, follow.FollowerID); ok {
+				delete(refs.value, *tweet.ReferencedID)
+				if len(refs.value) == 0 {
+					delete(c.timelineRefs, follow.FollowerID)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package inmemory
+
+import (
+	"testing"
+	"time"
+	"twitter-clone/internal/cache/cachetest"
+)
+
+func TestInMemoryCacheBehavior(t *testing.T) {
+	cachetest.Run(t, func(t *testing.T) cachetest.Backend {
+		c := NewInMemoryCache(&cachetest.Config{})
+		now := time.Now()
+		c.now = func() time.Time {
+			return now
+		}
+		return cachetest.Backend{
+			Cache: c,
+			FastForward: func(d time.Duration) {
+				now = now.Add(d)
+			},
+		}
+	})
+}
@@ -3,9 +3,12 @@ package redis
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
 	"strconv"
 	"time"
+	"twitter-clone/internal/domain/cache"
 	"twitter-clone/internal/domain/config"
 	"twitter-clone/internal/domain/twitter"
 
@@ -21,6 +24,8 @@ type RedisCache struct {
 
 	maxTweetsTimelineItems  int
 	tweetTimelineExpireTime time.Duration
+
+	fanoutBatchSize int
 }
 
 func NewRedisCache(config config.CacheConfig) *RedisCache {
@@ -36,6 +41,7 @@ func NewRedisCache(config config.CacheConfig) *RedisCache {
 		userFeedExpireTime:      time.Duration(config.TweetExpireTimeMinutes()),
 		maxTweetsTimelineItems:  config.MaxTweetsTimelineItems(),
 		tweetTimelineExpireTime: time.Duration(config.TweetTimelineExpireTimeMinutes()),
+		fanoutBatchSize:         config.FanoutBatchSize(),
 	}
 }
 
@@ -53,11 +59,19 @@ func (c *RedisCache) PushTweet(ctx context.Context, tweet twitter.Tweet) error {
 
 	tweetKey := fmt.Sprintf("tweet:%v", tweet.ID)
 	pipe.Set(ctx, tweetKey, data, c.tweetExpireTime*time.Minute)
-	pipe.Publish(ctx, "tweets:channel", tweet.ID) // here is coming the pub/sub model so the worker have to know when the tweet is published
 
+	// the relay pushes the tweet again if it fails to pass it on, so it's not listed twice
+	pipe.LRem(ctx, "tweets:global", 0, tweet.ID)
 	pipe.LPush(ctx, "tweets:global", tweet.ID)
 	pipe.LTrim(ctx, "tweets:global", 0, int64(c.maxTweets2Keep)-1)
 
+	// latest tweets of the author are merged into the timeline of a new follower
+	userTweetsKey := fmt.Sprintf("tweets:user:%d", tweet.UserID)
+	pipe.LRem(ctx, userTweetsKey, 0, tweet.ID)
+	pipe.LPush(ctx, userTweetsKey, tweet.ID)
+	pipe.LTrim(ctx, userTweetsKey, 0, int64(c.maxTweetsTimelineItems)-1)
+	pipe.Expire(ctx, userTweetsKey, c.tweetExpireTime*time.Minute)
+
 	_, err = pipe.Exec(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to push tweet %v to global list: %v", tweet.ID, err)
@@ -70,6 +84,8 @@ func (c *RedisCache) PushToUserFeed(ctx context.Context, userID, tweetID int64)
 	var err error
 	pipe := c.client.TxPipeline()
 	feedKey := fmt.Sprintf("timeline:%d", userID)
+	// the worker pushes the tweet again when a delivery is retried, so it's not listed twice
+	pipe.LRem(ctx, feedKey, 0, tweetID)
 	pipe.LPush(ctx, feedKey, tweetID)
 	pipe.LTrim(ctx, feedKey, 0, int64(c.maxTweetsTimelineItems)-1)
 	pipe.Expire(ctx, feedKey, c.tweetTimelineExpireTime*time.Minute)
@@ -82,25 +98,355 @@ func (c *RedisCache) PushToUserFeed(ctx context.Context, userID, tweetID int64)
 	return nil
 }
 
+// PushToUserFeeds does the same as PushToUserFeed for every user,
+// feeds are updated with one round trip per batch instead of one per user.
+// A batch is applied as a whole, a failed batch doesn't stop the rest and its users
+// are returned with the first error, so pushing them again doesn't list the tweet twice
+func (c *RedisCache) PushToUserFeeds(ctx context.Context, userIDs []int64, tweetID int64) ([]int64, error) {
+	var (
+		failed   []int64
+		firstErr error
+	)
+	batchSize := c.fanoutBatchSize
+	if batchSize <= 0 {
+		batchSize = len(userIDs)
+	}
+	for batch := range slices.Chunk(userIDs, max(batchSize, 1)) {
+		pipe := c.client.TxPipeline()
+		for _, userID := range batch {
+			feedKey := fmt.Sprintf("timeline:%d", userID)
+			pipe.LRem(ctx, feedKey, 0, tweetID)
+			pipe.LPush(ctx, feedKey, tweetID)
+			pipe.LTrim(ctx, feedKey, 0, int64(c.maxTweetsTimelineItems)-1)
+			pipe.Expire(ctx, feedKey, c.tweetTimelineExpireTime*time.Minute)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			failed = append(failed, batch...)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to push tweet %v to user feeds: %v", tweetID, err)
+			}
+		}
+	}
+	return failed, firstErr
+}
+
+// the ref is added and the retweet is pushed in one go, a failed push doesn't leave
+// the ref behind to skip the retweet when the delivery is retried
+const pushRetweetScript = `
+if redis.call("LPOS", KEYS[1], ARGV[2]) then
+	return 0
+end
+if redis.call("SADD", KEYS[2], ARGV[2]) == 0 then
+	return 0
+end
+redis.call("LPUSH", KEYS[1], ARGV[1])
+redis.call("LTRIM", KEYS[1], 0, tonumber(ARGV[3]) - 1)
+redis.call("EXPIRE", KEYS[1], ARGV[4])
+redis.call("EXPIRE", KEYS[2], ARGV[4])
+return 1
+`
+
+// Retweet is skipped if the original tweet is already in the timeline
+// or another retweet of the same original was delivered before.
+// Delivered originals are kept in the timeline_refs:<id> set, it lives as long as the timeline
+func (c *RedisCache) PushRetweetToUserFeed(ctx context.Context, userID, retweetID, originalID int64) (bool, error) {
+	feedKey := fmt.Sprintf("timeline:%d", userID)
+	refsKey := fmt.Sprintf("timeline_refs:%d", userID)
+	ttl := int64((c.tweetTimelineExpireTime * time.Minute).Seconds())
+
+	pushed, err := c.client.Eval(ctx, pushRetweetScript, []string{feedKey, refsKey},
+		retweetID, originalID, c.maxTweetsTimelineItems, ttl).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to push retweet %v to user %v feed: %v", retweetID, userID, err)
+	}
+	return pushed == 1, nil
+}
+
+// timeline is rebuilt only if it's cached, a missing one is loaded in full on connect.
+// Ids are compared as strings of digits, lua numbers are doubles and can't hold every int64
+const mergeToFeedScript = `
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+local ids = redis.call("LRANGE", KEYS[1], 0, -1)
+local seen = {}
+for _, id in ipairs(ids) do
+	seen[id] = true
+end
+for i = 3, #ARGV do
+	if not seen[ARGV[i]] then
+		seen[ARGV[i]] = true
+		table.insert(ids, ARGV[i])
+	end
+end
+table.sort(ids, function(a, b)
+	if #a ~= #b then
+		return #a > #b
+	end
+	return a > b
+end)
+redis.call("DEL", KEYS[1])
+for i = 1, math.min(#ids, tonumber(ARGV[1])) do
+	redis.call("RPUSH", KEYS[1], ids[i])
+end
+redis.call("EXPIRE", KEYS[1], ARGV[2])
+return 1
+`
+
+func (c *RedisCache) MergeToUserFeed(ctx context.Context, userID int64, tweetIDs []int64) error {
+	if len(tweetIDs) == 0 {
+		return nil
+	}
+	feedKey := fmt.Sprintf("timeline:%d", userID)
+	args := make([]any, 0, len(tweetIDs)+2)
+	args = append(args, c.maxTweetsTimelineItems, int64((c.tweetTimelineExpireTime * time.Minute).Seconds()))
+	for _, id := range tweetIDs {
+		args = append(args, id)
+	}
+	if err := c.client.Eval(ctx, mergeToFeedScript, []string{feedKey}, args...).Err(); err != nil {
+		return fmt.Errorf("failed to merge tweets to user %v feed: %v", userID, err)
+	}
+	return nil
+}
+
+// GetUserTweets returns the latest tweets of the user, newest first
+func (c *RedisCache) GetUserTweets(ctx context.Context, userID int64) ([]int64, error) {
+	key := fmt.Sprintf("tweets:user:%d", userID)
+	values, err := c.client.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user %v tweets: %v", userID, err)
+	}
+
+	result := make([]int64, len(values))
+	for i, v := range values {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse int64 from '%s': %w", v, err)
+		}
+		result[i] = id
+	}
+	return result, nil
+}
+
+func (c *RedisCache) SetHighFanout(ctx context.Context, userID int64, high bool) error {
+	var err error
+	if high {
+		err = c.client.SAdd(ctx, "users:high_fanout", userID).Err()
+	} else {
+		err = c.client.SRem(ctx, "users:high_fanout", userID).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set high fan-out of user %v: %v", userID, err)
+	}
+	return nil
+}
+
+func (c *RedisCache) IsHighFanout(ctx context.Context, userID int64) (bool, error) {
+	high, err := c.client.SIsMember(ctx, "users:high_fanout", userID).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check high fan-out of user %v: %v", userID, err)
+	}
+	return high, nil
+}
+
+// High fan-out users keep their latest tweets only in tweets:user:<id>,
+// the page is cut out of them and the timeline the same way GetUserTimeline does it
+func (c *RedisCache) MergeHighFanoutTweets(ctx context.Context, timeline []int64, followees []int64, cursor twitter.Cursor) ([]int64, error) {
+	if len(followees) == 0 {
+		return timeline, nil
+	}
+	members := make([]any, len(followees))
+	for i, id := range followees {
+		members[i] = id
+	}
+	high, err := c.client.SMIsMember(ctx, "users:high_fanout", members...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check high fan-out users: %v", err)
+	}
+
+	pipe := c.client.Pipeline()
+	var cmds []*redis.StringSliceCmd
+	for i, isHigh := range high {
+		if isHigh {
+			cmds = append(cmds, pipe.LRange(ctx, fmt.Sprintf("tweets:user:%d", followees[i]), 0, -1))
+		}
+	}
+	if len(cmds) == 0 {
+		return timeline, nil
+	}
+	if _, err = pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get tweets of high fan-out users: %v", err)
+	}
+
+	merged := slices.Clone(timeline)
+	for _, cmd := range cmds {
+		for _, v := range cmd.Val() {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse int64 from '%s': %w", v, err)
+			}
+			if (cursor.Before != 0 && id >= cursor.Before) || (cursor.After != 0 && id <= cursor.After) {
+				continue
+			}
+			merged = append(merged, id)
+		}
+	}
+	slices.Sort(merged)
+	merged = slices.Compact(merged) // retweeted or already stored by the websocket server
+	if len(merged) <= cursor.Limit {
+		return merged, nil
+	}
+	// going forward the page starts right after the cursor
+	if cursor.After != 0 {
+		return merged[:cursor.Limit], nil
+	}
+	return merged[len(merged)-cursor.Limit:], nil
+}
+
+// the high fan-out users are intersected with the followings on the server, a missing set is a miss.
+// An empty set doesn't exist in Redis, so the cached followings always have 0 in them, there is no such user
+const getHighFanoutFollowingScript = `
+local version = tonumber(redis.call("GET", KEYS[2]) or "0")
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return {0, version}
+end
+return {1, version, redis.call("SINTER", KEYS[1], KEYS[3])}
+`
+
+// the followings are set only if they weren't dropped since their version was read,
+// they are added in chunks as unpack can't take too many values
+const setFollowingScript = `
+local version = redis.call("GET", KEYS[2]) or "0"
+if version ~= ARGV[1] then
+	return 0
+end
+redis.call("DEL", KEYS[1])
+for i = 3, #ARGV, 1000 do
+	redis.call("SADD", KEYS[1], unpack(ARGV, i, math.min(i + 999, #ARGV)))
+end
+redis.call("EXPIRE", KEYS[1], ARGV[2])
+return 1
+`
+
+func followingKeys(userID int64) []string {
+	return []string{fmt.Sprintf("following:%d", userID), fmt.Sprintf("following_version:%d", userID)}
+}
+
+// dropFollowing is a part of a follow or an unfollow, the version tells SetFollowing the followings changed
+func (c *RedisCache) dropFollowing(ctx context.Context, pipe redis.Pipeliner, userID int64) {
+	keys := followingKeys(userID)
+	pipe.Incr(ctx, keys[1])
+	pipe.Expire(ctx, keys[1], c.tweetTimelineExpireTime*time.Minute)
+	pipe.Del(ctx, keys[0])
+}
+
+func (c *RedisCache) GetHighFanoutFollowing(ctx context.Context, userID int64) (cache.HighFanoutFollowing, error) {
+	keys := append(followingKeys(userID), "users:high_fanout")
+	values, err := c.client.Eval(ctx, getHighFanoutFollowingScript, keys).Slice()
+	if err != nil {
+		return cache.HighFanoutFollowing{}, fmt.Errorf("failed to get high fan-out followings of user %v: %v", userID, err)
+	}
+	version, _ := values[1].(int64)
+	result := cache.HighFanoutFollowing{Cached: values[0] == int64(1), Version: version}
+	if !result.Cached {
+		return result, nil
+	}
+	members, _ := values[2].([]any)
+	for _, member := range members {
+		id, err := parseInt(member)
+		if err != nil {
+			return cache.HighFanoutFollowing{}, err
+		}
+		result.Followees = append(result.Followees, id)
+	}
+	slices.Sort(result.Followees) // a set has no order
+	return result, nil
+}
+
+func (c *RedisCache) SetFollowing(ctx context.Context, userID int64, followees []int64, version int64) error {
+	ttl := int64((c.tweetTimelineExpireTime * time.Minute).Seconds())
+	args := make([]any, 0, len(followees)+3)
+	args = append(args, version, ttl, 0)
+	for _, id := range followees {
+		args = append(args, id)
+	}
+	if err := c.client.Eval(ctx, setFollowingScript, followingKeys(userID), args...).Err(); err != nil {
+		return fmt.Errorf("failed to set followings of user %v: %v", userID, err)
+	}
+	return nil
+}
+
+// the cached followings always have 0 in them, so the users whose followings are missing are told apart
+func (c *RedisCache) FollowersAmong(ctx context.Context, followeeID int64, userIDs []int64) ([]int64, []int64, error) {
+	if len(userIDs) == 0 {
+		return nil, nil, nil
+	}
+	pipe := c.client.Pipeline()
+	cmds := make([]*redis.BoolSliceCmd, len(userIDs))
+	for i, userID := range userIDs {
+		cmds[i] = pipe.SMIsMember(ctx, followingKeys(userID)[0], 0, followeeID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, nil, fmt.Errorf("failed to get followers of user %v: %v", followeeID, err)
+	}
+	var followers, uncached []int64
+	for i, cmd := range cmds {
+		members := cmd.Val()
+		switch {
+		case !members[0]:
+			uncached = append(uncached, userIDs[i])
+		case members[1]:
+			followers = append(followers, userIDs[i])
+		}
+	}
+	return followers, uncached, nil
+}
+
 /////////////////////////////////////
 //	Timeline / Feed
 ////////////////////////////////////
 
-func (c *RedisCache) GetUserTimeline(ctx context.Context, userID int64, limit int) ([]int64, error) {
+func (c *RedisCache) GetUserTimeline(ctx context.Context, userID int64, cursor twitter.Cursor) ([]int64, error) {
 	key := fmt.Sprintf("timeline:%d", userID)
-	values, err := c.client.LRange(ctx, key, 0, int64(limit)-1).Result() // TODO here limit should be from config & related to maxTweetsTimelineItems
+	// timeline is not longer than maxTweetsTimelineItems,
+	// so with a cursor it's read whole and filtered here
+	stop := int64(cursor.Limit) - 1
+	if cursor.Before != 0 || cursor.After != 0 {
+		stop = -1
+	}
+	values, err := c.client.LRange(ctx, key, 0, stop).Result()
 	if err != nil {
 		return nil, err
 	}
 
-	result := make([]int64, len(values))
+	newest := make([]int64, len(values)) // the list keeps the newest tweets first
 	for i, v := range values {
 		id, err := strconv.ParseInt(v, 10, 64)
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse int64 from '%s': %w", v, err)
 		}
-		result[len(values)-i-1] = id
+		newest[i] = id
+	}
+
+	result := make([]int64, 0, len(newest))
+	if cursor.After != 0 {
+		for i := len(newest) - 1; i >= 0 && len(result) < cursor.Limit; i-- {
+			if newest[i] > cursor.After {
+				result = append(result, newest[i])
+			}
+		}
+		return result, nil
 	}
+	for _, id := range newest {
+		if len(result) == cursor.Limit {
+			break
+		}
+		if cursor.Before != 0 && id >= cursor.Before {
+			continue
+		}
+		result = append(result, id)
+	}
+	slices.Reverse(result)
 	return result, nil
 }
 
@@ -130,22 +476,6 @@ func (c *RedisCache) StoreTimeline(ctx context.Context, userID int64, tweets []t
 	return nil
 }
 
-func (c *RedisCache) PushToTweetChannel(ctx context.Context, channelTweet twitter.ChannelTweet) error {
-	var err error
-	data, err := json.Marshal(channelTweet)
-	if err != nil {
-		return fmt.Errorf("failed to marshal tweet %v: %v", channelTweet.Tweet.ID, err)
-	}
-	pipe := c.client.TxPipeline()
-
-	pipe.Publish(ctx, "workers:channel", data)
-	_, err = pipe.Exec(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to public tweet: %v, %w", channelTweet.Tweet.ID, err)
-	}
-	return nil
-}
-
 /////////////////////////////////////////////////////////////////////////////////////////////////
 
 func (c *RedisCache) GetTweet(ctx context.Context, tweetID int64) (twitter.Tweet, error) {
@@ -160,6 +490,36 @@ func (c *RedisCache) GetTweet(ctx context.Context, tweetID int64) (twitter.Tweet
 	return tweet, nil
 }
 
+func (c *RedisCache) DeleteTweet(ctx context.Context, tweet twitter.Tweet, followers []int64) error {
+	pipe := c.client.TxPipeline()
+	pipe.Del(ctx, fmt.Sprintf("tweet:%v", tweet.ID), fmt.Sprintf("likes:%v", tweet.ID))
+	pipe.LRem(ctx, "tweets:global", 0, tweet.ID)
+	pipe.LRem(ctx, fmt.Sprintf("tweets:user:%d", tweet.UserID), 0, tweet.ID)
+	for _, followerID := range followers {
+		pipe.LRem(ctx, fmt.Sprintf("timeline:%d", followerID), 0, tweet.ID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to delete tweet %v: %v", tweet.ID, err)
+	}
+	return nil
+}
+
+func (c *RedisCache) UpdateTweet(ctx context.Context, tweet twitter.Tweet) error {
+	data, err := json.Marshal(tweet)
+	if err != nil {
+		return fmt.Errorf("failed to marshal tweet %v: %v", tweet.ID, err)
+	}
+	// XX: the tweet is not put back if it already expired
+	err = c.client.SetArgs(ctx, fmt.Sprintf("tweet:%v", tweet.ID), data, redis.SetArgs{
+		Mode:    "XX",
+		KeepTTL: true,
+	}).Err()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("failed to update tweet %v: %v", tweet.ID, err)
+	}
+	return nil
+}
+
 func (c *RedisCache) SetActiveUser(ctx context.Context, userID int64, ttl time.Duration) error {
 	return nil
 }
@@ -168,27 +528,144 @@ func (c *RedisCache) GetActiveUsers(ctx context.Context) ([]string, error) {
 	return nil, nil
 }
 
-func (c *RedisCache) SubscribeToTweetsChannel(ctx context.Context, channel string) (<-chan string, error) {
-	pubsub := c.client.Subscribe(ctx, channel)
-	ch := pubsub.Channel()
-	chanRet := make(chan string)
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				_ = pubsub.Close() // lint issue
-				close(chanRet)
-				return
-			default:
-				msg, ok := <-ch
-				if !ok {
-					return // send error and die
-				}
-				chanRet <- msg.Payload
+/////////////////////////////////////
+//	Dead letters
+////////////////////////////////////
+
+// hash field is the user and the tweet, so the same delivery is stored once
+const deadLettersKey = "deadletters"
+
+func deadLetterField(letter cache.DeadLetter) string {
+	return fmt.Sprintf("%d:%d", letter.UserID, letter.Tweet.ID)
+}
+
+func (c *RedisCache) PushDeadLetter(ctx context.Context, letter cache.DeadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter of tweet %v: %v", letter.Tweet.ID, err)
+	}
+	if err = c.client.HSet(ctx, deadLettersKey, deadLetterField(letter), data).Err(); err != nil {
+		return fmt.Errorf("failed to store dead letter of tweet %v: %v", letter.Tweet.ID, err)
+	}
+	return nil
+}
+
+// oldest failures first
+func (c *RedisCache) GetDeadLetters(ctx context.Context) ([]cache.DeadLetter, error) {
+	values, err := c.client.HGetAll(ctx, deadLettersKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead letters: %v", err)
+	}
+	letters := make([]cache.DeadLetter, 0, len(values))
+	for field, data := range values {
+		var letter cache.DeadLetter
+		if err = json.Unmarshal([]byte(data), &letter); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal dead letter %v: %v", field, err)
+		}
+		letters = append(letters, letter)
+	}
+	slices.SortFunc(letters, func(a, b cache.DeadLetter) int {
+		return a.FailedAt.Compare(b.FailedAt)
+	})
+	return letters, nil
+}
+
+func (c *RedisCache) RemoveDeadLetter(ctx context.Context, letter cache.DeadLetter) error {
+	if err := c.client.HDel(ctx, deadLettersKey, deadLetterField(letter)).Err(); err != nil {
+		return fmt.Errorf("failed to remove dead letter of tweet %v: %v", letter.Tweet.ID, err)
+	}
+	return nil
+}
+
+/////////////////////////////////////
+//	Likes
+////////////////////////////////////
+
+// the counter is dropped instead of incremented: the count being read from the database at the same time
+// may already have the like in it or not, so it's counted again. The version tells SetLikes it was dropped
+const dropLikesScript = `
+redis.call("INCR", KEYS[2])
+redis.call("EXPIRE", KEYS[2], ARGV[1])
+return redis.call("DEL", KEYS[1])
+`
+
+// the counter is set only if it wasn't dropped since its version was read
+const setLikesScript = `
+local version = redis.call("GET", KEYS[2]) or "0"
+if version ~= ARGV[2] then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[1], "EX", ARGV[3])
+return 1
+`
+
+func likesKeys(tweetID int64) []string {
+	return []string{fmt.Sprintf("likes:%d", tweetID), fmt.Sprintf("likes_version:%d", tweetID)}
+}
+
+func (c *RedisCache) DropLikes(ctx context.Context, tweetID int64) error {
+	ttl := int64((c.tweetExpireTime * time.Minute).Seconds())
+	if err := c.client.Eval(ctx, dropLikesScript, likesKeys(tweetID), ttl).Err(); err != nil {
+		return fmt.Errorf("failed to drop likes of tweet %v: %v", tweetID, err)
+	}
+	return nil
+}
+
+func (c *RedisCache) GetLikes(ctx context.Context, tweetIDs []int64) (cache.LikeCounters, error) {
+	result := cache.LikeCounters{
+		Likes:    make(map[int64]int64, len(tweetIDs)),
+		Versions: make(map[int64]int64),
+	}
+	if len(tweetIDs) == 0 {
+		return result, nil
+	}
+	// counter and version of every tweet in one round trip
+	keys := make([]string, 0, 2*len(tweetIDs))
+	for _, id := range tweetIDs {
+		keys = append(keys, likesKeys(id)...)
+	}
+	values, err := c.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return cache.LikeCounters{}, fmt.Errorf("failed to get likes: %v", err)
+	}
+	for i, id := range tweetIDs {
+		if values[2*i] != nil {
+			if result.Likes[id], err = parseInt(values[2*i]); err != nil {
+				return cache.LikeCounters{}, err
 			}
+			continue
+		}
+		// not cached, a missing version is 0
+		if result.Versions[id], err = parseInt(values[2*i+1]); err != nil {
+			return cache.LikeCounters{}, err
 		}
-	}()
-	return chanRet, nil
+	}
+	return result, nil
+}
+
+// parseInt parses a value of MGET, nil is 0
+func parseInt(value any) (int64, error) {
+	str, ok := value.(string)
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse int64 from '%s': %w", str, err)
+	}
+	return n, nil
+}
+
+func (c *RedisCache) SetLikes(ctx context.Context, likes map[int64]int64, versions map[int64]int64) error {
+	ttl := int64((c.tweetExpireTime * time.Minute).Seconds())
+	pipe := c.client.Pipeline()
+	for tweetID, count := range likes {
+		pipe.Eval(ctx, setLikesScript, likesKeys(tweetID), count, versions[tweetID], ttl)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to set likes: %v", err)
+	}
+	return nil
 }
 
 // Follow part
@@ -211,6 +688,14 @@ func (c *RedisCache) GetFollowers(ctx context.Context, userID int64) ([]int64, e
 	return result, nil
 }
 
+func (c *RedisCache) CountFollowers(ctx context.Context, userID int64) (int, error) {
+	count, err := c.client.LLen(ctx, fmt.Sprintf("followers:%d", userID)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count followers of user %v: %v", userID, err)
+	}
+	return int(count), nil
+}
+
 // It overwrites the followers list!!!
 func (c *RedisCache) SetFollowers(ctx context.Context, userID int64, followers []twitter.User) error {
 	// or maybe we just have to store only ids?
@@ -233,10 +718,39 @@ func (c *RedisCache) FollowUser(ctx context.Context, follow twitter.Follow) erro
 	var err error
 	pipe := c.client.TxPipeline()
 	followerKey := fmt.Sprintf("followers:%d", follow.FolloweeID)
+	// the same follow can be relayed again, the follower is listed once
+	pipe.LRem(ctx, followerKey, 0, follow.FollowerID)
 	pipe.LPush(ctx, followerKey, follow.FollowerID)
+	c.dropFollowing(ctx, pipe, follow.FollowerID)
 	_, err = pipe.Exec(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to execute pipeline: %w", err)
 	}
 	return nil
 }
+
+// Timeline keeps only ids and the tweet copies expire before it, so the followee's tweets
+// are the ones the database reports, not the cached copies
+func (c *RedisCache) UnfollowUser(ctx context.Context, follow twitter.Follow, tweets []twitter.Tweet) error {
+	followerKey := fmt.Sprintf("followers:%d", follow.FolloweeID)
+	feedKey := fmt.Sprintf("timeline:%d", follow.FollowerID)
+	refsKey := fmt.Sprintf("timeline_refs:%d", follow.FollowerID)
+
+	pipe := c.client.TxPipeline()
+	pipe.LRem(ctx, followerKey, 0, follow.FollowerID)
+	c.dropFollowing(ctx, pipe, follow.FollowerID)
+	for _, tweet := range tweets {
+		if tweet.UserID != follow.FolloweeID {
+			continue
+		}
+		pipe.LRem(ctx, feedKey, 0, tweet.ID)
+		// the original can be delivered again by someone else's retweet
+		if tweet.Kind == twitter.TweetKindRetweet && tweet.ReferencedID != nil {
+			pipe.SRem(ctx, refsKey, *tweet.ReferencedID)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to unfollow user %v: %v", follow.FolloweeID, err)
+	}
+	return nil
+}
@@ -3,12 +3,18 @@ package redis
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
 	"testing"
 	"time"
+	"twitter-clone/internal/cache/cachetest"
+	"twitter-clone/internal/domain/cache"
 	"twitter-clone/internal/domain/twitter"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/require"
 )
 
@@ -22,6 +28,9 @@ func (mc *MockCacheConfig) TweetExpireTimeMinutes() int         { return 1 }
 func (mc *MockCacheConfig) UserFeedExpireTimeMinutes() int      { return 1 }
 func (mc *MockCacheConfig) TweetTimelineExpireTimeMinutes() int { return 1 }
 func (mc *MockCacheConfig) MaxTweetsTimelineItems() int         { return 1 }
+func (mc *MockCacheConfig) FanoutFollowersThreshold() int       { return 1 }
+func (mc *MockCacheConfig) FanoutBatchSize() int                { return 2 }
+func (mc *MockCacheConfig) TweetsStreamMaxLen() int             { return 100 }
 
 var mockConfig MockCacheConfig
 
@@ -47,9 +56,13 @@ func TestPushTweet(t *testing.T) {
 
 	mock.ExpectTxPipeline()
 	mock.ExpectSet(fmt.Sprintf("tweet:%v", tweet.ID), data, c.tweetExpireTime*time.Minute).SetVal("OK")
-	mock.ExpectPublish("tweets:channel", tweet.ID).SetVal(1)
+	mock.ExpectLRem("tweets:global", 0, tweet.ID).SetVal(0)
 	mock.ExpectLPush("tweets:global", tweet.ID).SetVal(1)
 	mock.ExpectLTrim("tweets:global", 0, int64(c.maxTweets2Keep)-1).SetVal("OK")
+	mock.ExpectLRem("tweets:user:1", 0, tweet.ID).SetVal(0)
+	mock.ExpectLPush("tweets:user:1", tweet.ID).SetVal(1)
+	mock.ExpectLTrim("tweets:user:1", 0, int64(c.maxTweetsTimelineItems)-1).SetVal("OK")
+	mock.ExpectExpire("tweets:user:1", c.tweetExpireTime*time.Minute).SetVal(true)
 	mock.ExpectTxPipelineExec()
 
 	err = c.PushTweet(ctx, tweet)
@@ -73,6 +86,7 @@ func TestPushToUserFeed(t *testing.T) {
 	userID := int64(1)
 	tweetID := int64(1)
 	mock.ExpectTxPipeline()
+	mock.ExpectLRem(fmt.Sprintf("timeline:%v", 1), 0, tweetID).SetVal(0)
 	mock.ExpectLPush(fmt.Sprintf("timeline:%v", 1), tweetID).SetVal(1)
 	mock.ExpectLTrim(fmt.Sprintf("timeline:%v", 1), 0, int64(c.maxTweetsTimelineItems)-1).SetVal("OK")
 	mock.ExpectExpire(fmt.Sprintf("timeline:%v", 1), c.tweetTimelineExpireTime*time.Minute).SetVal(true)
@@ -84,6 +98,46 @@ func TestPushToUserFeed(t *testing.T) {
 	require.NoError(t, mock.ExpectationsWereMet())
 }
 
+// PushRetweetToUserFeed(ctx context.Context, userID, retweetID, originalID int64) (bool, error)
+func TestPushRetweetToUserFeed(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	defer func() {
+		_ = db.Close() // lint
+	}()
+
+	c := NewRedisCache(&mockConfig)
+	c.client = db
+
+	ctx := context.Background()
+	userID := int64(1)
+	retweetID := int64(20)
+	originalID := int64(10)
+	keys := []string{fmt.Sprintf("timeline:%v", userID), fmt.Sprintf("timeline_refs:%v", userID)}
+	ttl := int64((c.tweetTimelineExpireTime * time.Minute).Seconds())
+
+	mock.ExpectEval(pushRetweetScript, keys, retweetID, originalID, c.maxTweetsTimelineItems, ttl).SetVal(int64(1))
+
+	pushed, err := c.PushRetweetToUserFeed(ctx, userID, retweetID, originalID)
+	require.NoError(t, err)
+	require.True(t, pushed)
+
+	// original or another retweet of it is already in the feed
+	mock.ExpectEval(pushRetweetScript, keys, retweetID, originalID, c.maxTweetsTimelineItems, ttl).SetVal(int64(0))
+
+	pushed, err = c.PushRetweetToUserFeed(ctx, userID, retweetID, originalID)
+	require.NoError(t, err)
+	require.False(t, pushed)
+
+	// failed push is an error, so the delivery is retried
+	mock.ExpectEval(pushRetweetScript, keys, retweetID, originalID, c.maxTweetsTimelineItems, ttl).SetErr(errors.New("connection reset"))
+
+	pushed, err = c.PushRetweetToUserFeed(ctx, userID, retweetID, originalID)
+	require.Error(t, err)
+	require.False(t, pushed)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
 // GetTweet(ctx context.Context, tweetID int64) (twitter.Tweet, error)
 func TestGetTweet(t *testing.T) {
 	db, mock := redismock.NewClientMock()
@@ -190,7 +244,11 @@ func TestFollowUser(t *testing.T) {
 	key := fmt.Sprintf("followers:%d", follow.FolloweeID)
 
 	mock.ExpectTxPipeline()
+	mock.ExpectLRem(key, 0, follow.FollowerID).SetVal(0)
 	mock.ExpectLPush(key, follow.FollowerID).SetVal(1)
+	mock.ExpectIncr("following_version:101").SetVal(1)
+	mock.ExpectExpire("following_version:101", time.Minute).SetVal(true)
+	mock.ExpectDel("following:101").SetVal(1)
 	mock.ExpectTxPipelineExec()
 
 	err := cache.FollowUser(ctx, follow)
@@ -199,7 +257,7 @@ func TestFollowUser(t *testing.T) {
 }
 
 // // Timeline / Feed
-// GetUserTimeline(ctx context.Context, userID int64, limit int) ([]int64, error)
+// GetUserTimeline(ctx context.Context, userID int64, cursor twitter.Cursor) ([]int64, error)
 func TestGetUserTimeline(t *testing.T) {
 	db, mock := redismock.NewClientMock()
 	defer func() {
@@ -218,9 +276,23 @@ func TestGetUserTimeline(t *testing.T) {
 
 	expected := []int64{1, 2, 3}
 
-	timeline, err := cache.GetUserTimeline(ctx, userID, limit)
+	timeline, err := cache.GetUserTimeline(ctx, userID, twitter.Cursor{Limit: limit})
 	require.NoError(t, err)
 	require.Equal(t, expected, timeline)
+
+	// with a cursor the whole timeline is read
+	redisValues = []string{"9", "8", "7", "6", "5", "4", "3"}
+	mock.ExpectLRange(key, int64(0), int64(-1)).SetVal(redisValues)
+
+	timeline, err = cache.GetUserTimeline(ctx, userID, twitter.Cursor{Before: 8, Limit: limit})
+	require.NoError(t, err)
+	require.Equal(t, []int64{5, 6, 7}, timeline)
+
+	mock.ExpectLRange(key, int64(0), int64(-1)).SetVal(redisValues)
+
+	timeline, err = cache.GetUserTimeline(ctx, userID, twitter.Cursor{After: 4, Limit: limit})
+	require.NoError(t, err)
+	require.Equal(t, []int64{5, 6, 7}, timeline)
 	require.NoError(t, mock.ExpectationsWereMet())
 }
 
@@ -284,7 +356,313 @@ func TestStoreTimeline(t *testing.T) {
 	require.NoError(t, mock.ExpectationsWereMet())
 }
 
-// // this part should be moved to pub/sub service but no time rn
-// // subscribe to tweets channel
-// PushToTweetChannel(ctx context.Context, channelTweet twitter.ChannelTweet) error
-// SubscribeToTweetsChannel(ctx context.Context, channel string) (<-chan string, error)
+// DropLikes(ctx context.Context, tweetID int64) error
+func TestDropLikes(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	defer func() {
+		_ = db.Close() // lint
+	}()
+
+	cache := NewRedisCache(&mockConfig)
+	cache.client = db
+	ctx := context.Background()
+	tweetID := int64(7)
+
+	ttl := int64((cache.tweetExpireTime * time.Minute).Seconds())
+	mock.ExpectEval(dropLikesScript, []string{"likes:7", "likes_version:7"}, ttl).SetVal(int64(1))
+
+	err := cache.DropLikes(ctx, tweetID)
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// GetLikes(ctx context.Context, tweetIDs []int64) (cache.LikeCounters, error)
+func TestGetLikes(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	defer func() {
+		_ = db.Close() // lint
+	}()
+
+	cache := NewRedisCache(&mockConfig)
+	cache.client = db
+	ctx := context.Background()
+
+	mock.ExpectMGet("likes:1", "likes_version:1", "likes:2", "likes_version:2", "likes:3", "likes_version:3", "likes:4", "likes_version:4").
+		SetVal([]interface{}{"5", "2", nil, "3", "0", nil, nil, nil})
+
+	likes, err := cache.GetLikes(ctx, []int64{1, 2, 3, 4})
+	require.NoError(t, err)
+	require.Equal(t, map[int64]int64{1: 5, 3: 0}, likes.Likes)
+	require.Equal(t, map[int64]int64{2: 3, 4: 0}, likes.Versions)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// DeleteTweet(ctx context.Context, tweet twitter.Tweet, followers []int64) error
+func TestDeleteTweet(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	defer func() {
+		_ = db.Close() // lint
+	}()
+
+	cache := NewRedisCache(&mockConfig)
+	cache.client = db
+	ctx := context.Background()
+
+	tweet := twitter.Tweet{
+		ID:     5,
+		UserID: 1,
+	}
+	followers := []int64{2, 3}
+
+	mock.ExpectTxPipeline()
+	mock.ExpectDel("tweet:5", "likes:5").SetVal(2)
+	mock.ExpectLRem("tweets:global", 0, tweet.ID).SetVal(1)
+	mock.ExpectLRem("tweets:user:1", 0, tweet.ID).SetVal(1)
+	for _, f := range followers {
+		mock.ExpectLRem(fmt.Sprintf("timeline:%d", f), 0, tweet.ID).SetVal(1)
+	}
+	mock.ExpectTxPipelineExec()
+
+	err := cache.DeleteTweet(ctx, tweet, followers)
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// UpdateTweet(ctx context.Context, tweet twitter.Tweet) error
+func TestUpdateTweet(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	defer func() {
+		_ = db.Close() // lint
+	}()
+
+	cache := NewRedisCache(&mockConfig)
+	cache.client = db
+	ctx := context.Background()
+
+	tweet := twitter.Tweet{
+		ID:      5,
+		UserID:  1,
+		Content: "Edited",
+	}
+	data, err := json.Marshal(tweet)
+	require.NoError(t, err)
+
+	args := redis.SetArgs{Mode: "XX", KeepTTL: true}
+	mock.ExpectSetArgs("tweet:5", data, args).SetVal("OK")
+
+	err = cache.UpdateTweet(ctx, tweet)
+	require.NoError(t, err)
+
+	// tweet is not cached
+	mock.ExpectSetArgs("tweet:5", data, args).RedisNil()
+
+	err = cache.UpdateTweet(ctx, tweet)
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// UnfollowUser(ctx context.Context, follow twitter.Follow, tweets []twitter.Tweet) error
+func TestUnfollowUser(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	defer func() {
+		_ = db.Close() // lint
+	}()
+
+	cache := NewRedisCache(&mockConfig)
+	cache.client = db
+	ctx := context.Background()
+
+	follow := twitter.Follow{FollowerID: 1, FolloweeID: 2}
+	original := int64(3)
+	tweets := []twitter.Tweet{
+		{ID: 7, UserID: 2},
+		{ID: 6, UserID: 2, Kind: twitter.TweetKindRetweet, ReferencedID: &original},
+		{ID: 5, UserID: 4},
+	}
+
+	mock.ExpectTxPipeline()
+	mock.ExpectLRem("followers:2", 0, follow.FollowerID).SetVal(1)
+	mock.ExpectIncr("following_version:1").SetVal(1)
+	mock.ExpectExpire("following_version:1", time.Minute).SetVal(true)
+	mock.ExpectDel("following:1").SetVal(1)
+	mock.ExpectLRem("timeline:1", 0, int64(7)).SetVal(1)
+	mock.ExpectLRem("timeline:1", 0, int64(6)).SetVal(1)
+	mock.ExpectSRem("timeline_refs:1", original).SetVal(1)
+	mock.ExpectTxPipelineExec()
+
+	err := cache.UnfollowUser(ctx, follow, tweets)
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// MergeToUserFeed(ctx context.Context, userID int64, tweetIDs []int64) error
+func TestMergeToUserFeed(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	defer func() {
+		_ = db.Close() // lint
+	}()
+
+	cache := NewRedisCache(&mockConfig)
+	cache.client = db
+	ctx := context.Background()
+
+	ttl := int64((cache.tweetTimelineExpireTime * time.Minute).Seconds())
+	mock.ExpectEval(mergeToFeedScript, []string{"timeline:1"}, cache.maxTweetsTimelineItems, ttl, int64(5), int64(3)).SetVal(int64(1))
+
+	err := cache.MergeToUserFeed(ctx, 1, []int64{5, 3})
+	require.NoError(t, err)
+
+	// nothing to merge, nothing is sent
+	err = cache.MergeToUserFeed(ctx, 1, nil)
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// GetUserTweets(ctx context.Context, userID int64) ([]int64, error)
+func TestGetUserTweets(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	defer func() {
+		_ = db.Close() // lint
+	}()
+
+	cache := NewRedisCache(&mockConfig)
+	cache.client = db
+	ctx := context.Background()
+
+	mock.ExpectLRange("tweets:user:1", 0, -1).SetVal([]string{"5", "3"})
+
+	tweets, err := cache.GetUserTweets(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, []int64{5, 3}, tweets)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// SetHighFanout(ctx context.Context, userID int64, high bool) error
+func TestSetHighFanout(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	defer func() {
+		_ = db.Close() // lint
+	}()
+
+	cache := NewRedisCache(&mockConfig)
+	cache.client = db
+	ctx := context.Background()
+
+	mock.ExpectSAdd("users:high_fanout", int64(1)).SetVal(1)
+	mock.ExpectSRem("users:high_fanout", int64(2)).SetVal(1)
+
+	require.NoError(t, cache.SetHighFanout(ctx, 1, true))
+	require.NoError(t, cache.SetHighFanout(ctx, 2, false))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// MergeHighFanoutTweets(ctx context.Context, timeline []int64, followees []int64, cursor twitter.Cursor) ([]int64, error)
+func TestMergeHighFanoutTweets(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	defer func() {
+		_ = db.Close() // lint
+	}()
+
+	cache := NewRedisCache(&mockConfig)
+	cache.client = db
+	ctx := context.Background()
+
+	timeline := []int64{2, 5, 8}
+	followees := []int64{10, 20, 30}
+
+	mock.ExpectSMIsMember("users:high_fanout", int64(10), int64(20), int64(30)).SetVal([]bool{false, true, true})
+	mock.ExpectLRange("tweets:user:20", 0, -1).SetVal([]string{"12", "7"})
+	mock.ExpectLRange("tweets:user:30", 0, -1).SetVal([]string{"9", "8", "1"})
+
+	merged, err := cache.MergeHighFanoutTweets(ctx, timeline, followees, twitter.Cursor{Before: 10, Limit: 4})
+	require.NoError(t, err)
+	require.Equal(t, []int64{5, 7, 8, 9}, merged)
+
+	// nobody to merge
+	mock.ExpectSMIsMember("users:high_fanout", int64(10)).SetVal([]bool{false})
+
+	merged, err = cache.MergeHighFanoutTweets(ctx, timeline, followees[:1], twitter.Cursor{Limit: 4})
+	require.NoError(t, err)
+	require.Equal(t, timeline, merged)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// PushToUserFeeds(ctx context.Context, userIDs []int64, tweetID int64) error
+func TestPushToUserFeeds(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	defer func() {
+		_ = db.Close() // lint
+	}()
+
+	c := NewRedisCache(&mockConfig)
+	c.client = db
+	ctx := context.Background()
+
+	tweetID := int64(7)
+	userIDs := []int64{1, 2, 3}
+
+	// batch size of the config is 2, so there are two transactions
+	for batch := range slices.Chunk(userIDs, 2) {
+		mock.ExpectTxPipeline()
+		for _, userID := range batch {
+			feedKey := fmt.Sprintf("timeline:%d", userID)
+			mock.ExpectLRem(feedKey, 0, tweetID).SetVal(0)
+			mock.ExpectLPush(feedKey, tweetID).SetVal(1)
+			mock.ExpectLTrim(feedKey, 0, int64(c.maxTweetsTimelineItems)-1).SetVal("OK")
+			mock.ExpectExpire(feedKey, c.tweetTimelineExpireTime*time.Minute).SetVal(true)
+		}
+		mock.ExpectTxPipelineExec()
+	}
+
+	failed, err := c.PushToUserFeeds(ctx, userIDs, tweetID)
+	require.NoError(t, err)
+	require.Empty(t, failed)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// PushDeadLetter(ctx context.Context, letter cache.DeadLetter) error
+// GetDeadLetters(ctx context.Context) ([]cache.DeadLetter, error)
+// RemoveDeadLetter(ctx context.Context, letter cache.DeadLetter) error
+func TestDeadLetters(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	defer func() {
+		_ = db.Close() // lint
+	}()
+
+	c := NewRedisCache(&mockConfig)
+	c.client = db
+	ctx := context.Background()
+
+	older := cache.DeadLetter{UserID: 2, Tweet: twitter.Tweet{ID: 7}, Error: "timeout", FailedAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	newer := cache.DeadLetter{UserID: 1, Tweet: twitter.Tweet{ID: 9}, Error: "timeout", FailedAt: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)}
+	olderData, err := json.Marshal(older)
+	require.NoError(t, err)
+	newerData, err := json.Marshal(newer)
+	require.NoError(t, err)
+
+	mock.ExpectHSet("deadletters", "2:7", olderData).SetVal(1)
+	require.NoError(t, c.PushDeadLetter(ctx, older))
+
+	mock.ExpectHGetAll("deadletters").SetVal(map[string]string{
+		"1:9": string(newerData),
+		"2:7": string(olderData),
+	})
+	letters, err := c.GetDeadLetters(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []cache.DeadLetter{older, newer}, letters)
+
+	mock.ExpectHDel("deadletters", "2:7").SetVal(1)
+	require.NoError(t, c.RemoveDeadLetter(ctx, older))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRedisCacheBehavior(t *testing.T) {
+	cachetest.Run(t, func(t *testing.T) cachetest.Backend {
+		server := miniredis.RunT(t)
+		c := NewRedisCache(&cachetest.Config{Address: server.Addr()})
+		t.Cleanup(func() {
+			_ = c.Close() // lint
+		})
+		return cachetest.Backend{Cache: c, FastForward: server.FastForward}
+	})
+}
@@ -22,6 +22,15 @@ type YamlConfig struct {
 
 	// metrics
 	MetricsServer MetricsConfig `yaml:"metrics,omitempty"`
+
+	// tweets
+	Tweet TweetConfig `yaml:"tweet,omitempty"`
+
+	// worker
+	Worker WorkerConfig `yaml:"worker,omitempty"`
+
+	// outbox relay
+	Outbox OutboxConfig `yaml:"outbox,omitempty"`
 }
 
 type API struct {
@@ -30,6 +39,7 @@ type API struct {
 }
 
 type Database struct {
+	Driver   string `yaml:"driver,omitempty"`
 	Path     string `yaml:"path,omitempty"`
 	User     string `yaml:"user,omitempty"`
 	Port     string `yaml:"port,omitempty"`
@@ -47,6 +57,8 @@ type CacheConfig struct {
 	UserFeedExpireTimeMinutes      int    `yaml:"user_feed_expire_time_minutes"`
 	TweetTimelineExpireTimeMinutes int    `yaml:"tweet_timeline_expire_time_minutes"`
 	MaxTweetsTimelineItems         int    `yaml:"max_tweets_timeline_items"`
+	FanoutFollowersThreshold       int    `yaml:"fanout_followers_threshold"`
+	FanoutBatchSize                int    `yaml:"fanout_batch_size"`
 }
 
 type WSSConfig struct {
@@ -60,6 +72,26 @@ type MetricsConfig struct {
 	Port int    `yaml:"port"`
 }
 
+type TweetConfig struct {
+	EditWindowMinutes int `yaml:"edit_window_minutes"`
+	NodeID            int `yaml:"node_id"`
+}
+
+type WorkerConfig struct {
+	PoolSize         int `yaml:"pool_size"`
+	QueueSize        int `yaml:"queue_size"`
+	ClaimIdleSeconds int `yaml:"claim_idle_seconds"`
+	RetryAttempts    int `yaml:"retry_attempts"`
+	RetryBackoffMs   int `yaml:"retry_backoff_ms"`
+	MaxDeliveries    int `yaml:"max_deliveries"`
+}
+
+type OutboxConfig struct {
+	PollIntervalMs int `yaml:"poll_interval_ms"`
+	BatchSize      int `yaml:"batch_size"`
+	LeaseSeconds   int `yaml:"lease_seconds"`
+}
+
 func NewYamlConfig(configFilePath string) (*YamlConfig, error) {
 	var (
 		err  error
@@ -122,6 +154,10 @@ func (c *YamlConfig) DatabaseUser() string {
 	return c.Database.User
 }
 
+func (c *YamlConfig) DatabaseDriver() string {
+	return c.Database.Driver
+}
+
 ///////////////////////////////////
 //	Cache Config
 ///////////////////////////////////
@@ -150,6 +186,12 @@ func (c *YamlConfig) TweetTimelineExpireTimeMinutes() int {
 func (c *YamlConfig) MaxTweetsTimelineItems() int {
 	return c.Cache.MaxTweetsTimelineItems
 }
+func (c *YamlConfig) FanoutFollowersThreshold() int {
+	return c.Cache.FanoutFollowersThreshold
+}
+func (c *YamlConfig) FanoutBatchSize() int {
+	return c.Cache.FanoutBatchSize
+}
 
 ///////////////////////////////////
 //	WSS Config
@@ -175,3 +217,51 @@ func (c *YamlConfig) MetricsServerHost() string {
 func (c *YamlConfig) MetricsServerPort() int {
 	return c.MetricsServer.Port
 }
+
+///////////////////////////////////
+//	Tweet Config
+///////////////////////////////////
+
+func (c *YamlConfig) TweetEditWindowMinutes() int {
+	return c.Tweet.EditWindowMinutes
+}
+func (c *YamlConfig) TweetNodeID() int {
+	return c.Tweet.NodeID
+}
+
+///////////////////////////////////
+//	Worker Config
+///////////////////////////////////
+
+func (c *YamlConfig) WorkerPoolSize() int {
+	return c.Worker.PoolSize
+}
+func (c *YamlConfig) WorkerQueueSize() int {
+	return c.Worker.QueueSize
+}
+func (c *YamlConfig) WorkerClaimIdleSeconds() int {
+	return c.Worker.ClaimIdleSeconds
+}
+func (c *YamlConfig) WorkerRetryAttempts() int {
+	return c.Worker.RetryAttempts
+}
+func (c *YamlConfig) WorkerRetryBackoffMillis() int {
+	return c.Worker.RetryBackoffMs
+}
+func (c *YamlConfig) WorkerMaxDeliveries() int {
+	return c.Worker.MaxDeliveries
+}
+
+///////////////////////////////////
+//	Outbox Config
+///////////////////////////////////
+
+func (c *YamlConfig) OutboxPollIntervalMillis() int {
+	return c.Outbox.PollIntervalMs
+}
+func (c *YamlConfig) OutboxBatchSize() int {
+	return c.Outbox.BatchSize
+}
+func (c *YamlConfig) OutboxLeaseSeconds() int {
+	return c.Outbox.LeaseSeconds
+}
@@ -0,0 +1,673 @@
+// Package dbtest is the behavior every database.DatabaseI has to follow,
+// the in-memory, the sqlite and the postgres databases run the same suite
+package dbtest
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+	"twitter-clone/internal/domain/database"
+	"twitter-clone/internal/domain/twitter"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Run runs the suite, newDB is called for every test and has to return an empty database
+func Run(t *testing.T, newDB func(t *testing.T) database.DatabaseI) {
+	tests := map[string]func(t *testing.T, db database.DatabaseI){
+		"Users":         testUsers,
+		"Profile":       testProfile,
+		"Tweets":        testTweets,
+		"GeneratedIDs":  testGeneratedIDs,
+		"UsersTweets":   testUsersTweets,
+		"Timeline":      testTimeline,
+		"Follows":       testFollows,
+		"Conversation":  testConversation,
+		"Retweets":      testRetweets,
+		"DeleteTweet":   testDeleteTweet,
+		"EditTweet":     testEditTweet,
+		"Likes":         testLikes,
+		"MissingRows":   testMissingRows,
+		"BrokenForeign": testBrokenForeign,
+		"Outbox":        testOutbox,
+		"OutboxLease":   testOutboxLease,
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			test(t, newDB(t))
+		})
+	}
+}
+
+func createUser(t *testing.T, db database.DatabaseI, username string) int64 {
+	user, err := db.CreateUser(context.Background(), twitter.User{Username: username})
+	require.NoError(t, err)
+	return user.ID
+}
+
+func newTweet(t *testing.T, db database.DatabaseI, tweet twitter.Tweet) int64 {
+	if tweet.Kind == "" {
+		tweet.Kind = twitter.TweetKindOriginal
+	}
+	saved, err := db.NewTweet(context.Background(), tweet)
+	require.NoError(t, err)
+	return saved.ID
+}
+
+func follow(t *testing.T, db database.DatabaseI, followerID, followeeID int64) {
+	err := db.FollowUser(context.Background(), twitter.Follow{FollowerID: followerID, FolloweeID: followeeID})
+	require.NoError(t, err)
+}
+
+func ids(tweets []twitter.Tweet) []int64 {
+	result := []int64{}
+	for _, tweet := range tweets {
+		result = append(result, tweet.ID)
+	}
+	return result
+}
+
+func userIDs(users []twitter.User) []int64 {
+	result := []int64{}
+	for _, user := range users {
+		result = append(result, user.ID)
+	}
+	return result
+}
+
+func testUsers(t *testing.T, db database.DatabaseI) {
+	ctx := context.Background()
+
+	alice := createUser(t, db, "alice")
+	bob := createUser(t, db, "bob")
+	require.NotEqual(t, alice, bob)
+
+	user, err := db.GetUser(ctx, bob)
+	require.NoError(t, err)
+	require.Equal(t, bob, user.ID)
+	require.Equal(t, "bob", user.Username)
+	require.False(t, user.CreatedAt.IsZero())
+
+	// usernames are unique regardless of the case
+	_, err = db.CreateUser(ctx, twitter.User{Username: "alice"})
+	require.ErrorIs(t, err, twitter.ErrUsernameTaken)
+	_, err = db.CreateUser(ctx, twitter.User{Username: "Alice"})
+	require.ErrorIs(t, err, twitter.ErrUsernameTaken)
+	_, err = db.CreateUser(ctx, twitter.User{Username: "Carol"})
+	require.NoError(t, err)
+}
+
+func testProfile(t *testing.T, db database.DatabaseI) {
+	ctx := context.Background()
+
+	created, err := db.CreateUser(ctx, twitter.User{
+		Username:    "alice",
+		DisplayName: "Alice",
+		Bio:         "hello",
+	})
+	require.NoError(t, err)
+	alice := created.ID
+	bob := createUser(t, db, "bob")
+	follow(t, db, bob, alice)
+
+	user, err := db.GetUser(ctx, alice)
+	require.NoError(t, err)
+	require.Equal(t, "Alice", user.DisplayName)
+	require.Equal(t, "hello", user.Bio)
+	require.Empty(t, user.Location)
+	// the created user is the one which is read back
+	require.Equal(t, user.Username, created.Username)
+	require.Equal(t, user.DisplayName, created.DisplayName)
+	require.WithinDuration(t, user.CreatedAt, created.CreatedAt, 0)
+
+	// every profile field is written, the username and created_at stay
+	updated, err := db.UpdateProfile(ctx, twitter.User{
+		ID:       alice,
+		Username: "mallory",
+		Location: "Berlin",
+		Website:  "https://alice.example",
+	})
+	require.NoError(t, err)
+	require.Equal(t, alice, updated.ID)
+	require.Equal(t, "alice", updated.Username)
+	require.Empty(t, updated.DisplayName)
+	require.Empty(t, updated.Bio)
+	require.Equal(t, "Berlin", updated.Location)
+	require.Equal(t, "https://alice.example", updated.Website)
+	require.WithinDuration(t, user.CreatedAt, updated.CreatedAt, 0)
+
+	user, err = db.GetUser(ctx, alice)
+	require.NoError(t, err)
+	require.Equal(t, "Berlin", user.Location)
+
+	// the users in the lists come with their profiles
+	following, err := db.Following(ctx, bob)
+	require.NoError(t, err)
+	require.Len(t, following, 1)
+	require.Equal(t, "https://alice.example", following[0].Website)
+}
+
+func testTweets(t *testing.T, db database.DatabaseI) {
+	ctx := context.Background()
+	alice := createUser(t, db, "alice")
+
+	saved, err := db.NewTweet(ctx, twitter.Tweet{UserID: alice, Content: "first", Kind: twitter.TweetKindOriginal})
+	require.NoError(t, err)
+	first := saved.ID
+	second := newTweet(t, db, twitter.Tweet{UserID: alice, Content: "second"})
+	require.Greater(t, second, first) // ids grow with time, pages rely on it
+
+	tweet, err := db.GetTweet(ctx, first)
+	require.NoError(t, err)
+	// the saved tweet is the one which is read back
+	require.Equal(t, tweet.Content, saved.Content)
+	require.WithinDuration(t, tweet.CreatedAt, saved.CreatedAt, 0)
+	require.Equal(t, first, tweet.ID)
+	require.Equal(t, alice, tweet.UserID)
+	require.Equal(t, "first", tweet.Content)
+	require.Equal(t, twitter.TweetKindOriginal, tweet.Kind)
+	require.False(t, tweet.CreatedAt.IsZero())
+	require.Nil(t, tweet.EditedAt)
+	require.Nil(t, tweet.InReplyTo)
+	require.Nil(t, tweet.RootID)
+	require.Nil(t, tweet.ReferencedID)
+}
+
+// ids made by the id generator are kept, the ones of other nodes may come out of order
+func testGeneratedIDs(t *testing.T, db database.DatabaseI) {
+	ctx := context.Background()
+	alice := createUser(t, db, "alice")
+	bob := createUser(t, db, "bob")
+	follow(t, db, bob, alice)
+
+	older := int64(1) << 40
+	newer := older + 2
+	require.Equal(t, newer, newTweet(t, db, twitter.Tweet{ID: newer, UserID: alice, Content: "newer"}))
+	require.Equal(t, older, newTweet(t, db, twitter.Tweet{ID: older, UserID: alice, Content: "older"}))
+
+	tweet, err := db.GetTweet(ctx, older)
+	require.NoError(t, err)
+	require.Equal(t, "older", tweet.Content)
+
+	page, err := db.GetUsersTweets(ctx, alice, twitter.Cursor{Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []int64{newer, older}, ids(page))
+	page, err = db.GetUsersTweets(ctx, alice, twitter.Cursor{Before: newer, Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []int64{older}, ids(page))
+	page, err = db.GetUsersTweets(ctx, alice, twitter.Cursor{After: older, Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []int64{newer}, ids(page))
+	page, err = db.GetTimeline(ctx, bob, twitter.Cursor{Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []int64{newer, older}, ids(page))
+	// the cursors are bigger than a 32 bit integer as well
+	page, err = db.GetTimeline(ctx, bob, twitter.Cursor{Before: newer, Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []int64{older}, ids(page))
+	page, err = db.GetTimeline(ctx, bob, twitter.Cursor{After: older, Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []int64{newer}, ids(page))
+
+	_, err = db.NewTweet(ctx, twitter.Tweet{ID: older, UserID: alice, Content: "again", Kind: twitter.TweetKindOriginal})
+	require.ErrorIs(t, err, twitter.ErrTweetExists)
+}
+
+func testUsersTweets(t *testing.T, db database.DatabaseI) {
+	ctx := context.Background()
+	alice := createUser(t, db, "alice")
+	bob := createUser(t, db, "bob")
+
+	var tweets []int64
+	for range 5 {
+		tweets = append(tweets, newTweet(t, db, twitter.Tweet{UserID: alice, Content: "hello"}))
+		newTweet(t, db, twitter.Tweet{UserID: bob, Content: "hello"})
+	}
+
+	page, err := db.GetUsersTweets(ctx, alice, twitter.Cursor{Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []int64{tweets[4], tweets[3]}, ids(page))
+
+	page, err = db.GetUsersTweets(ctx, alice, twitter.Cursor{Before: tweets[3], Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []int64{tweets[2], tweets[1]}, ids(page))
+
+	// the newer ones right after the cursor, still newest first
+	page, err = db.GetUsersTweets(ctx, alice, twitter.Cursor{After: tweets[0], Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []int64{tweets[2], tweets[1]}, ids(page))
+
+	page, err = db.GetUsersTweets(ctx, alice, twitter.Cursor{Before: tweets[0], Limit: 2})
+	require.NoError(t, err)
+	require.Empty(t, page)
+}
+
+func testTimeline(t *testing.T, db database.DatabaseI) {
+	ctx := context.Background()
+	alice := createUser(t, db, "alice")
+	bob := createUser(t, db, "bob")
+	carol := createUser(t, db, "carol")
+	dave := createUser(t, db, "dave")
+	follow(t, db, alice, bob)
+	follow(t, db, alice, carol)
+
+	bobs := newTweet(t, db, twitter.Tweet{UserID: bob, Content: "bob"})
+	newTweet(t, db, twitter.Tweet{UserID: alice, Content: "own"})
+	newTweet(t, db, twitter.Tweet{UserID: dave, Content: "not followed"})
+	carols := newTweet(t, db, twitter.Tweet{UserID: carol, Content: "carol"})
+	bobsLast := newTweet(t, db, twitter.Tweet{UserID: bob, Content: "bob again"})
+
+	// only the followed users, newest first, the user's own tweets are not there
+	timeline, err := db.GetTimeline(ctx, alice, twitter.Cursor{Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []int64{bobsLast, carols, bobs}, ids(timeline))
+
+	timeline, err = db.GetTimeline(ctx, alice, twitter.Cursor{Before: bobsLast, Limit: 1})
+	require.NoError(t, err)
+	require.Equal(t, []int64{carols}, ids(timeline))
+	timeline, err = db.GetTimeline(ctx, alice, twitter.Cursor{After: bobs, Limit: 1})
+	require.NoError(t, err)
+	require.Equal(t, []int64{carols}, ids(timeline))
+
+	require.NoError(t, db.UnfollowUser(ctx, twitter.Follow{FollowerID: alice, FolloweeID: bob}))
+	timeline, err = db.GetTimeline(ctx, alice, twitter.Cursor{Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []int64{carols}, ids(timeline))
+
+	timeline, err = db.GetTimeline(ctx, dave, twitter.Cursor{Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, timeline)
+}
+
+func testFollows(t *testing.T, db database.DatabaseI) {
+	ctx := context.Background()
+	alice := createUser(t, db, "alice")
+	bob := createUser(t, db, "bob")
+	carol := createUser(t, db, "carol")
+
+	follow(t, db, bob, alice)
+	follow(t, db, carol, alice)
+	follow(t, db, bob, alice) // already followed, nothing changes
+	follow(t, db, alice, carol)
+
+	// users are ordered by the time of the follow
+	followers, err := db.Followers(ctx, alice)
+	require.NoError(t, err)
+	require.Equal(t, []int64{bob, carol}, userIDs(followers))
+	require.Equal(t, "bob", followers[0].Username)
+	require.False(t, followers[0].CreatedAt.IsZero())
+
+	following, err := db.Following(ctx, alice)
+	require.NoError(t, err)
+	require.Equal(t, []int64{carol}, userIDs(following))
+	require.Equal(t, "carol", following[0].Username)
+
+	followed, err := db.IsFollowing(ctx, twitter.Follow{FollowerID: bob, FolloweeID: alice})
+	require.NoError(t, err)
+	require.True(t, followed)
+
+	require.NoError(t, db.UnfollowUser(ctx, twitter.Follow{FollowerID: bob, FolloweeID: alice}))
+	require.NoError(t, db.UnfollowUser(ctx, twitter.Follow{FollowerID: bob, FolloweeID: alice})) // not followed anymore
+	followed, err = db.IsFollowing(ctx, twitter.Follow{FollowerID: bob, FolloweeID: alice})
+	require.NoError(t, err)
+	require.False(t, followed)
+	followers, err = db.Followers(ctx, alice)
+	require.NoError(t, err)
+	require.Equal(t, []int64{carol}, userIDs(followers))
+
+	following, err = db.Following(ctx, bob)
+	require.NoError(t, err)
+	require.Empty(t, following)
+
+	// nobody follows themselves
+	require.ErrorIs(t, db.FollowUser(ctx, twitter.Follow{FollowerID: alice, FolloweeID: alice}), twitter.ErrSelfFollow)
+}
+
+func testConversation(t *testing.T, db database.DatabaseI) {
+	ctx := context.Background()
+	alice := createUser(t, db, "alice")
+	bob := createUser(t, db, "bob")
+
+	root := newTweet(t, db, twitter.Tweet{UserID: alice, Content: "root"})
+	reply := newTweet(t, db, twitter.Tweet{UserID: bob, Content: "reply", InReplyTo: &root, RootID: &root})
+	newTweet(t, db, twitter.Tweet{UserID: bob, Content: "another conversation"})
+	nested := newTweet(t, db, twitter.Tweet{UserID: alice, Content: "nested", InReplyTo: &reply, RootID: &root})
+
+	conversation, err := db.GetConversation(ctx, root)
+	require.NoError(t, err)
+	require.Equal(t, []int64{root, reply, nested}, ids(conversation))
+	require.Equal(t, reply, *conversation[2].InReplyTo)
+
+	// replies stay, they just lose the reference
+	_, err = db.DeleteTweet(ctx, reply, bob)
+	require.NoError(t, err)
+	tweet, err := db.GetTweet(ctx, nested)
+	require.NoError(t, err)
+	require.Nil(t, tweet.InReplyTo)
+	require.Equal(t, root, *tweet.RootID)
+
+	// without the root the rest of the thread is still one conversation
+	sibling := newTweet(t, db, twitter.Tweet{UserID: bob, Content: "sibling", InReplyTo: &root, RootID: &root})
+	_, err = db.DeleteTweet(ctx, root, alice)
+	require.NoError(t, err)
+	tweet, err = db.GetTweet(ctx, sibling)
+	require.NoError(t, err)
+	require.Nil(t, tweet.InReplyTo)
+	require.Equal(t, root, *tweet.RootID)
+	conversation, err = db.GetConversation(ctx, *tweet.RootID)
+	require.NoError(t, err)
+	require.Equal(t, []int64{nested, sibling}, ids(conversation))
+
+	// a reply to the thread still joins it
+	late := newTweet(t, db, twitter.Tweet{UserID: alice, Content: "late", InReplyTo: &sibling, RootID: &root})
+	conversation, err = db.GetConversation(ctx, root)
+	require.NoError(t, err)
+	require.Equal(t, []int64{nested, sibling, late}, ids(conversation))
+}
+
+func testRetweets(t *testing.T, db database.DatabaseI) {
+	ctx := context.Background()
+	alice := createUser(t, db, "alice")
+	bob := createUser(t, db, "bob")
+
+	original := newTweet(t, db, twitter.Tweet{UserID: alice, Content: "original"})
+	retweet := newTweet(t, db, twitter.Tweet{UserID: bob, Kind: twitter.TweetKindRetweet, ReferencedID: &original})
+	quote := newTweet(t, db, twitter.Tweet{UserID: bob, Content: "quote", Kind: twitter.TweetKindQuote, ReferencedID: &original})
+
+	tweet, err := db.GetTweet(ctx, retweet)
+	require.NoError(t, err)
+	require.Equal(t, twitter.TweetKindRetweet, tweet.Kind)
+	require.Equal(t, original, *tweet.ReferencedID)
+
+	// a tweet is retweeted by a user once, quoted as many times as wanted
+	_, err = db.NewTweet(ctx, twitter.Tweet{UserID: bob, Kind: twitter.TweetKindRetweet, ReferencedID: &original})
+	require.ErrorIs(t, err, twitter.ErrAlreadyRetweeted)
+	quoteAgain := newTweet(t, db, twitter.Tweet{UserID: bob, Content: "quote again", Kind: twitter.TweetKindQuote, ReferencedID: &original})
+
+	// retweets go together with the tweet, quotes keep the content without the reference
+	deleted, err := db.DeleteTweet(ctx, original, alice)
+	require.NoError(t, err)
+	require.Equal(t, original, deleted.Tweet.ID)
+	require.Equal(t, []int64{retweet}, ids(deleted.Retweets))
+	require.ElementsMatch(t, []int64{quote, quoteAgain}, ids(deleted.Quotes))
+	for _, q := range deleted.Quotes {
+		require.Nil(t, q.ReferencedID)
+	}
+	_, err = db.GetTweet(ctx, retweet)
+	require.ErrorIs(t, err, twitter.ErrTweetNotFound)
+	tweet, err = db.GetTweet(ctx, quote)
+	require.NoError(t, err)
+	require.Equal(t, twitter.TweetKindQuote, tweet.Kind)
+	require.Equal(t, "quote", tweet.Content)
+	require.Nil(t, tweet.ReferencedID)
+
+	// a quote can be deleted after the quoted tweet is gone
+	_, err = db.DeleteTweet(ctx, quote, bob)
+	require.NoError(t, err)
+}
+
+func testDeleteTweet(t *testing.T, db database.DatabaseI) {
+	ctx := context.Background()
+	alice := createUser(t, db, "alice")
+	bob := createUser(t, db, "bob")
+	tweetID := newTweet(t, db, twitter.Tweet{UserID: alice, Content: "hello"})
+
+	_, err := db.DeleteTweet(ctx, tweetID, bob)
+	require.ErrorIs(t, err, twitter.ErrNotTweetAuthor)
+
+	deleted, err := db.DeleteTweet(ctx, tweetID, alice)
+	require.NoError(t, err)
+	require.Equal(t, tweetID, deleted.Tweet.ID)
+	require.Equal(t, "hello", deleted.Tweet.Content)
+	require.Empty(t, deleted.Retweets)
+	require.Empty(t, deleted.Quotes)
+
+	_, err = db.GetTweet(ctx, tweetID)
+	require.ErrorIs(t, err, twitter.ErrTweetNotFound)
+	_, err = db.DeleteTweet(ctx, tweetID, alice)
+	require.ErrorIs(t, err, twitter.ErrTweetNotFound)
+	tweets, err := db.GetUsersTweets(ctx, alice, twitter.Cursor{Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, tweets)
+}
+
+func testEditTweet(t *testing.T, db database.DatabaseI) {
+	ctx := context.Background()
+	alice := createUser(t, db, "alice")
+	bob := createUser(t, db, "bob")
+	tweetID := newTweet(t, db, twitter.Tweet{UserID: alice, Content: "v1"})
+
+	revisions, err := db.GetTweetRevisions(ctx, tweetID)
+	require.NoError(t, err)
+	require.Empty(t, revisions)
+
+	_, err = db.EditTweet(ctx, twitter.Tweet{ID: tweetID, UserID: bob, Content: "hacked"})
+	require.ErrorIs(t, err, twitter.ErrNotTweetAuthor)
+	_, err = db.EditTweet(ctx, twitter.Tweet{ID: tweetID + 100, UserID: alice, Content: "v2"})
+	require.ErrorIs(t, err, twitter.ErrTweetNotFound)
+
+	edited, err := db.EditTweet(ctx, twitter.Tweet{ID: tweetID, UserID: alice, Content: "v2"})
+	require.NoError(t, err)
+	require.Equal(t, "v2", edited.Content)
+	require.NotNil(t, edited.EditedAt)
+	// the edit window is checked against now, the times are the same instants whatever the time zone of the database
+	require.WithinDuration(t, time.Now(), edited.CreatedAt, time.Minute)
+	require.WithinDuration(t, time.Now(), *edited.EditedAt, time.Minute)
+	_, err = db.EditTweet(ctx, twitter.Tweet{ID: tweetID, UserID: alice, Content: "v3"})
+	require.NoError(t, err)
+
+	tweet, err := db.GetTweet(ctx, tweetID)
+	require.NoError(t, err)
+	require.Equal(t, "v3", tweet.Content)
+
+	// previous versions only, the first one is the posted content
+	revisions, err = db.GetTweetRevisions(ctx, tweetID)
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	require.Equal(t, 1, revisions[0].Version)
+	require.Equal(t, "v1", revisions[0].Content)
+	require.Equal(t, 2, revisions[1].Version)
+	require.Equal(t, "v2", revisions[1].Content)
+}
+
+func testLikes(t *testing.T, db database.DatabaseI) {
+	ctx := context.Background()
+	alice := createUser(t, db, "alice")
+	bob := createUser(t, db, "bob")
+	carol := createUser(t, db, "carol")
+	tweetID := newTweet(t, db, twitter.Tweet{UserID: alice, Content: "hello"})
+	other := newTweet(t, db, twitter.Tweet{UserID: alice, Content: "other"})
+
+	liked, err := db.LikeTweet(ctx, twitter.Like{UserID: bob, TweetID: tweetID})
+	require.NoError(t, err)
+	require.True(t, liked)
+	liked, err = db.LikeTweet(ctx, twitter.Like{UserID: bob, TweetID: tweetID})
+	require.NoError(t, err)
+	require.False(t, liked)
+	_, err = db.LikeTweet(ctx, twitter.Like{UserID: carol, TweetID: tweetID})
+	require.NoError(t, err)
+
+	counts, err := db.LikesCount(ctx, []int64{tweetID, other})
+	require.NoError(t, err)
+	require.Equal(t, map[int64]int64{tweetID: 2, other: 0}, counts)
+
+	users, err := db.LikedBy(ctx, tweetID, 0, 10)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []int64{bob, carol}, userIDs(users))
+	users, err = db.LikedBy(ctx, tweetID, 1, 10)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+
+	unliked, err := db.UnlikeTweet(ctx, twitter.Like{UserID: bob, TweetID: tweetID})
+	require.NoError(t, err)
+	require.True(t, unliked)
+	unliked, err = db.UnlikeTweet(ctx, twitter.Like{UserID: bob, TweetID: tweetID})
+	require.NoError(t, err)
+	require.False(t, unliked)
+
+	users, err = db.LikedBy(ctx, tweetID, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []int64{carol}, userIDs(users))
+	require.Equal(t, "carol", users[0].Username)
+	require.False(t, users[0].CreatedAt.IsZero())
+}
+
+func testMissingRows(t *testing.T, db database.DatabaseI) {
+	ctx := context.Background()
+
+	_, err := db.GetUser(ctx, 100)
+	require.ErrorIs(t, err, twitter.ErrUserNotFound)
+	_, err = db.GetTweet(ctx, 100)
+	require.ErrorIs(t, err, twitter.ErrTweetNotFound)
+	_, err = db.DeleteTweet(ctx, 100, 1)
+	require.ErrorIs(t, err, twitter.ErrTweetNotFound)
+	_, err = db.UpdateProfile(ctx, twitter.User{ID: 100, Bio: "hello"})
+	require.ErrorIs(t, err, twitter.ErrUserNotFound)
+
+	conversation, err := db.GetConversation(ctx, 100)
+	require.NoError(t, err)
+	require.Empty(t, conversation)
+	followers, err := db.Followers(ctx, 100)
+	require.NoError(t, err)
+	require.Empty(t, followers)
+}
+
+// the rows have to reference the existing ones
+func testBrokenForeign(t *testing.T, db database.DatabaseI) {
+	ctx := context.Background()
+	alice := createUser(t, db, "alice")
+	tweetID := newTweet(t, db, twitter.Tweet{UserID: alice, Content: "hello"})
+	missing := tweetID + 100
+
+	// which one is missing is up to the database, every one reports the same error
+	_, err := db.NewTweet(ctx, twitter.Tweet{UserID: alice + 100, Content: "hello", Kind: twitter.TweetKindOriginal})
+	require.ErrorIs(t, err, twitter.ErrReferenceNotFound)
+	_, err = db.NewTweet(ctx, twitter.Tweet{UserID: alice, Content: "reply", Kind: twitter.TweetKindOriginal, InReplyTo: &missing, RootID: &missing})
+	require.ErrorIs(t, err, twitter.ErrReferenceNotFound)
+	_, err = db.NewTweet(ctx, twitter.Tweet{UserID: alice, Kind: twitter.TweetKindRetweet, ReferencedID: &missing})
+	require.ErrorIs(t, err, twitter.ErrReferenceNotFound)
+
+	require.ErrorIs(t, db.FollowUser(ctx, twitter.Follow{FollowerID: alice, FolloweeID: alice + 100}), twitter.ErrReferenceNotFound)
+	_, err = db.LikeTweet(ctx, twitter.Like{UserID: alice + 100, TweetID: tweetID})
+	require.ErrorIs(t, err, twitter.ErrReferenceNotFound)
+	_, err = db.LikeTweet(ctx, twitter.Like{UserID: alice, TweetID: missing})
+	require.ErrorIs(t, err, twitter.ErrReferenceNotFound)
+}
+
+// tweets and follows are passed on through the outbox in the order they were made
+func testOutbox(t *testing.T, db database.DatabaseI) {
+	ctx := context.Background()
+	alice := createUser(t, db, "alice")
+	bob := createUser(t, db, "bob")
+
+	tweetID := newTweet(t, db, twitter.Tweet{UserID: alice, Content: "hello"})
+	follow(t, db, bob, alice)
+	follow(t, db, bob, alice) // already followed, nothing new
+	// nothing is saved, so there is nothing to pass on
+	_, err := db.NewTweet(ctx, twitter.Tweet{UserID: alice + 100, Content: "hello", Kind: twitter.TweetKindOriginal})
+	require.Error(t, err)
+	require.Error(t, db.FollowUser(ctx, twitter.Follow{FollowerID: alice, FolloweeID: alice}))
+
+	entries, err := db.ClaimOutbox(ctx, 10, time.Hour)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Less(t, entries[0].ID, entries[1].ID)
+
+	require.Equal(t, database.OutboxTweet, entries[0].Kind)
+	var tweet twitter.Tweet
+	require.NoError(t, json.Unmarshal(entries[0].Payload, &tweet))
+	stored, err := db.GetTweet(ctx, tweetID)
+	require.NoError(t, err)
+	require.Equal(t, tweetID, tweet.ID)
+	require.Equal(t, "hello", tweet.Content)
+	require.Equal(t, twitter.TweetKindOriginal, tweet.Kind)
+	require.True(t, stored.CreatedAt.Equal(tweet.CreatedAt))
+
+	require.Equal(t, database.OutboxFollow, entries[1].Kind)
+	var followed twitter.Follow
+	require.NoError(t, json.Unmarshal(entries[1].Payload, &followed))
+	require.Equal(t, bob, followed.FollowerID)
+	require.Equal(t, alice, followed.FolloweeID)
+	require.False(t, followed.CreatedAt.IsZero())
+
+	followEntry := entries[1].ID
+	require.NoError(t, db.DeleteOutbox(ctx, []int64{entries[0].ID}))
+	require.NoError(t, db.DeleteOutbox(ctx, nil))
+	entries, err = db.ClaimOutbox(ctx, 10, time.Hour)
+	require.NoError(t, err)
+	require.Empty(t, entries) // the follow is still claimed
+
+	// the unfollow goes after the follow, unfollowing again adds nothing
+	require.NoError(t, db.UnfollowUser(ctx, twitter.Follow{FollowerID: bob, FolloweeID: alice}))
+	require.NoError(t, db.UnfollowUser(ctx, twitter.Follow{FollowerID: bob, FolloweeID: alice}))
+	unfollows, err := db.ClaimOutbox(ctx, 10, time.Hour)
+	require.NoError(t, err)
+	require.Len(t, unfollows, 1)
+	require.Equal(t, database.OutboxUnfollow, unfollows[0].Kind)
+	require.Greater(t, unfollows[0].ID, followEntry)
+	var unfollowed twitter.Follow
+	require.NoError(t, json.Unmarshal(unfollows[0].Payload, &unfollowed))
+	require.Equal(t, bob, unfollowed.FollowerID)
+	require.Equal(t, alice, unfollowed.FolloweeID)
+
+	// the edited tweet goes as it's stored, an edit which fails adds nothing
+	_, err = db.EditTweet(ctx, twitter.Tweet{ID: tweetID, UserID: bob, Content: "hacked"})
+	require.ErrorIs(t, err, twitter.ErrNotTweetAuthor)
+	edited, err := db.EditTweet(ctx, twitter.Tweet{ID: tweetID, UserID: alice, Content: "hello again"})
+	require.NoError(t, err)
+	edits, err := db.ClaimOutbox(ctx, 10, time.Hour)
+	require.NoError(t, err)
+	require.Len(t, edits, 1)
+	require.Equal(t, database.OutboxEdit, edits[0].Kind)
+	require.NoError(t, json.Unmarshal(edits[0].Payload, &tweet))
+	require.Equal(t, tweetID, tweet.ID)
+	require.Equal(t, "hello again", tweet.Content)
+	require.True(t, edited.EditedAt.Equal(*tweet.EditedAt))
+
+	// the deleted tweet goes with the tweets it changed, a delete which fails adds nothing
+	quoteID := newTweet(t, db, twitter.Tweet{UserID: bob, Content: "look", Kind: twitter.TweetKindQuote, ReferencedID: &tweetID})
+	_, err = db.ClaimOutbox(ctx, 10, time.Hour)
+	require.NoError(t, err)
+	_, err = db.DeleteTweet(ctx, tweetID, bob)
+	require.ErrorIs(t, err, twitter.ErrNotTweetAuthor)
+	_, err = db.DeleteTweet(ctx, tweetID, alice)
+	require.NoError(t, err)
+	deletes, err := db.ClaimOutbox(ctx, 10, time.Hour)
+	require.NoError(t, err)
+	require.Len(t, deletes, 1)
+	require.Equal(t, database.OutboxDelete, deletes[0].Kind)
+	var deleted database.DeletedTweet
+	require.NoError(t, json.Unmarshal(deletes[0].Payload, &deleted))
+	require.Equal(t, tweetID, deleted.Tweet.ID)
+	require.Equal(t, []int64{quoteID}, ids(deleted.Quotes))
+	require.Nil(t, deleted.Quotes[0].ReferencedID)
+}
+
+func testOutboxLease(t *testing.T, db database.DatabaseI) {
+	ctx := context.Background()
+	alice := createUser(t, db, "alice")
+	first := newTweet(t, db, twitter.Tweet{UserID: alice, Content: "first"})
+	newTweet(t, db, twitter.Tweet{UserID: alice, Content: "second"})
+	newTweet(t, db, twitter.Tweet{UserID: alice, Content: "third"})
+
+	// the oldest ones first, at most the limit
+	entries, err := db.ClaimOutbox(ctx, 1, 50*time.Millisecond)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	var tweet twitter.Tweet
+	require.NoError(t, json.Unmarshal(entries[0].Payload, &tweet))
+	require.Equal(t, first, tweet.ID)
+	claimed := entries[0].ID
+
+	// another relay gets the rest
+	entries, err = db.ClaimOutbox(ctx, 10, time.Hour)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	// the first relay didn't finish in time, the entry is claimed again
+	require.Eventually(t, func() bool {
+		entries, err = db.ClaimOutbox(ctx, 10, time.Hour)
+		return err == nil && len(entries) == 1 && entries[0].ID == claimed
+	}, 5*time.Second, 20*time.Millisecond)
+}
@@ -1,41 +1,111 @@
 package inmemory
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
 	"fmt"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+	"twitter-clone/internal/domain/database"
 	"twitter-clone/internal/domain/twitter"
 )
 
+// InMemoryDB behaves as the postgres database does, including its constraints
 type InMemoryDB struct {
 	tweets     map[int64]twitter.Tweet
 	userTweets map[int64][]twitter.Tweet
-	follows    map[int64]map[int64]struct{}
+	follows    map[int64]map[int64]time.Time // follower id -> followee id -> followed at
 	users      map[int64]twitter.User
+	likes      map[int64]map[int64]time.Time // tweet id -> user id -> liked at
+	revisions  map[int64][]twitter.TweetRevision
+	outbox     []outboxEntry
 	nextID     int64
+	nextUserID int64
+	nextOutbox int64
 	mu         sync.RWMutex
 }
 
+type outboxEntry struct {
+	database.OutboxEntry
+	claimedUntil time.Time
+}
+
 func NewInMemoryDB() *InMemoryDB {
 	return &InMemoryDB{
 		tweets:     make(map[int64]twitter.Tweet),
 		userTweets: make(map[int64][]twitter.Tweet),
-		follows:    make(map[int64]map[int64]struct{}),
+		follows:    make(map[int64]map[int64]time.Time),
+		users:      make(map[int64]twitter.User),
+		likes:      make(map[int64]map[int64]time.Time),
+		revisions:  make(map[int64][]twitter.TweetRevision),
 		nextID:     1,
+		nextUserID: 1,
+		nextOutbox: 1,
 	}
 }
 
-func (db *InMemoryDB) NewTweet(ctx context.Context, tweet twitter.Tweet) (int64, error) {
+// mimics the entry added in the transaction of the change, the change is already checked and can't fail
+func (db *InMemoryDB) addOutbox(kind database.OutboxKind, data any) {
+	payload, _ := json.Marshal(data) // tweets and follows are always marshaled
+	db.outbox = append(db.outbox, outboxEntry{
+		OutboxEntry: database.OutboxEntry{
+			ID:        db.nextOutbox,
+			Kind:      kind,
+			Payload:   payload,
+			CreatedAt: time.Now().UTC(),
+		},
+	})
+	db.nextOutbox++
+}
+
+func (db *InMemoryDB) NewTweet(ctx context.Context, tweet twitter.Tweet) (twitter.Tweet, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	tweet.ID = db.nextID
-	db.nextID++
+	if _, exists := db.users[tweet.UserID]; !exists {
+		return twitter.Tweet{}, fmt.Errorf("failed to insert tweet: user with ID %d: %w", tweet.UserID, twitter.ErrReferenceNotFound)
+	}
+	// root_id is not a reference, it outlives the root
+	for _, referenced := range []*int64{tweet.InReplyTo, tweet.ReferencedID} {
+		if referenced == nil {
+			continue
+		}
+		if _, exists := db.tweets[*referenced]; !exists {
+			return twitter.Tweet{}, fmt.Errorf("failed to insert tweet: tweet with ID %d: %w", *referenced, twitter.ErrReferenceNotFound)
+		}
+	}
+	if tweet.Kind == twitter.TweetKindRetweet {
+		for _, userTweet := range db.userTweets[tweet.UserID] {
+			if userTweet.Kind == twitter.TweetKindRetweet && *userTweet.ReferencedID == *tweet.ReferencedID {
+				return twitter.Tweet{}, fmt.Errorf("failed to insert tweet: %w", twitter.ErrAlreadyRetweeted)
+			}
+		}
+	}
+
+	// the id comes from the id generator, the counter is used only if it's not set
+	if tweet.ID == 0 {
+		tweet.ID = db.nextID
+	} else if _, exists := db.tweets[tweet.ID]; exists {
+		return twitter.Tweet{}, fmt.Errorf("failed to insert tweet: %w", twitter.ErrTweetExists)
+	}
+	db.nextID = max(db.nextID, tweet.ID+1)
+	tweet.CreatedAt = time.Now().UTC()
+	tweet.EditedAt = nil
 
 	db.tweets[tweet.ID] = tweet
-	db.userTweets[tweet.UserID] = append(db.userTweets[tweet.UserID], tweet)
+	// ids of other nodes may come a bit out of order, user's tweets are kept sorted by id
+	userTweets := db.userTweets[tweet.UserID]
+	i, _ := slices.BinarySearchFunc(userTweets, tweet.ID, func(t twitter.Tweet, id int64) int {
+		return cmp.Compare(t.ID, id)
+	})
+	db.userTweets[tweet.UserID] = slices.Insert(userTweets, i, tweet)
+	db.addOutbox(database.OutboxTweet, tweet)
 
-	return tweet.ID, nil
+	return tweet, nil
 }
 
 func (db *InMemoryDB) GetTweet(ctx context.Context, id int64) (twitter.Tweet, error) {
@@ -44,80 +114,410 @@ func (db *InMemoryDB) GetTweet(ctx context.Context, id int64) (twitter.Tweet, er
 
 	tweet, exists := db.tweets[id]
 	if !exists {
-		return twitter.Tweet{}, fmt.Errorf("tweet with ID %d not found", id)
+		return twitter.Tweet{}, twitter.ErrTweetNotFound
 	}
 	return tweet, nil
 }
 
-func (db *InMemoryDB) GetUsersTweets(ctx context.Context, userID int64) ([]twitter.Tweet, error) {
+func (db *InMemoryDB) GetUsersTweets(ctx context.Context, userID int64, cursor twitter.Cursor) ([]twitter.Tweet, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	tweets, exists := db.userTweets[userID]
-	if !exists {
-		return nil, fmt.Errorf("no tweets found for user with ID %d", userID)
-	}
+	// user's tweets are appended in the order of ids, so the newest are at the end
+	tweets := slices.Clone(db.userTweets[userID])
+	slices.Reverse(tweets)
+	return pageTweets(tweets, cursor), nil
+}
 
-	result := make([]twitter.Tweet, len(tweets))
-	copy(result, tweets)
-	return result, nil
+// pageTweets cuts the page selected by cursor out of the tweets sorted from the newest
+func pageTweets(tweets []twitter.Tweet, cursor twitter.Cursor) []twitter.Tweet {
+	result := []twitter.Tweet{}
+	if cursor.After != 0 {
+		// the closest newer tweets are the last ones
+		for i := len(tweets) - 1; i >= 0 && len(result) < cursor.Limit; i-- {
+			if tweets[i].ID > cursor.After {
+				result = append(result, tweets[i])
+			}
+		}
+		slices.Reverse(result)
+		return result
+	}
+	for _, tweet := range tweets {
+		if len(result) == cursor.Limit {
+			break
+		}
+		if cursor.Before != 0 && tweet.ID >= cursor.Before {
+			continue
+		}
+		result = append(result, tweet)
+	}
+	return result
 }
 
-func (db *InMemoryDB) GetTimeline(ctx context.Context, userID int64) ([]twitter.Tweet, error) {
+func (db *InMemoryDB) GetTimeline(ctx context.Context, userID int64, cursor twitter.Cursor) ([]twitter.Tweet, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
+	// only the followed users, the user's own tweets are not there
 	var timeline []twitter.Tweet
+	for followedID := range db.follows[userID] {
+		timeline = append(timeline, db.userTweets[followedID]...)
+	}
+	slices.SortFunc(timeline, func(a, b twitter.Tweet) int {
+		return cmp.Compare(b.ID, a.ID)
+	})
+
+	return pageTweets(timeline, cursor), nil
+}
+
+func (db *InMemoryDB) GetConversation(ctx context.Context, rootID int64) ([]twitter.Tweet, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 
-	if tweets, exists := db.userTweets[userID]; exists {
-		timeline = append(timeline, tweets...)
+	var conversation []twitter.Tweet
+	for _, tweet := range db.tweets {
+		if tweet.ID == rootID || (tweet.RootID != nil && *tweet.RootID == rootID) {
+			conversation = append(conversation, tweet)
+		}
 	}
+	sort.Slice(conversation, func(i, j int) bool {
+		return conversation[i].ID < conversation[j].ID
+	})
+	return conversation, nil
+}
 
-	if followed, exists := db.follows[userID]; exists {
-		for followedID := range followed {
-			if tweets, exists := db.userTweets[followedID]; exists {
-				timeline = append(timeline, tweets...)
-			}
+func (db *InMemoryDB) DeleteTweet(ctx context.Context, tweetID, userID int64) (database.DeletedTweet, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	tweet, exists := db.tweets[tweetID]
+	if !exists {
+		return database.DeletedTweet{}, twitter.ErrTweetNotFound
+	}
+	if tweet.UserID != userID {
+		return database.DeletedTweet{}, twitter.ErrNotTweetAuthor
+	}
+	deleted := database.DeletedTweet{Tweet: tweet}
+	for _, t := range db.tweets {
+		if t.ReferencedID == nil || *t.ReferencedID != tweetID {
+			continue
+		}
+		if t.Kind == twitter.TweetKindRetweet {
+			deleted.Retweets = append(deleted.Retweets, t)
+		} else {
+			deleted.Quotes = append(deleted.Quotes, t)
 		}
 	}
+	for _, retweet := range deleted.Retweets {
+		db.deleteTweet(retweet.ID)
+	}
+	db.deleteTweet(tweetID)
+	for i := range deleted.Quotes {
+		deleted.Quotes[i] = db.tweets[deleted.Quotes[i].ID]
+	}
+	db.addOutbox(database.OutboxDelete, deleted)
+	return deleted, nil
+}
 
-	for i := 0; i < len(timeline)-1; i++ {
-		for j := i + 1; j < len(timeline); j++ {
-			if timeline[i].ID < timeline[j].ID {
-				timeline[i], timeline[j] = timeline[j], timeline[i]
-			}
+// deleteTweet mimics foreign keys of the postgres schema: quotes and replies
+// just lose the reference, retweets have to be deleted before
+func (db *InMemoryDB) deleteTweet(tweetID int64) {
+	tweet := db.tweets[tweetID]
+	delete(db.tweets, tweetID)
+	delete(db.likes, tweetID)
+	delete(db.revisions, tweetID)
+
+	userTweets := db.userTweets[tweet.UserID]
+	for i := range userTweets {
+		if userTweets[i].ID == tweetID {
+			db.userTweets[tweet.UserID] = append(userTweets[:i], userTweets[i+1:]...)
+			break
+		}
+	}
+
+	for _, t := range db.tweets {
+		changed := false
+		if t.ReferencedID != nil && *t.ReferencedID == tweetID {
+			t.ReferencedID = nil
+			changed = true
+		}
+		if t.InReplyTo != nil && *t.InReplyTo == tweetID {
+			t.InReplyTo = nil
+			changed = true
+		}
+		if changed {
+			db.updateTweet(t)
 		}
 	}
+}
+
+func (db *InMemoryDB) EditTweet(ctx context.Context, tweet twitter.Tweet) (twitter.Tweet, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	edited, exists := db.tweets[tweet.ID]
+	if !exists {
+		return twitter.Tweet{}, twitter.ErrTweetNotFound
+	}
+	if edited.UserID != tweet.UserID {
+		return twitter.Tweet{}, twitter.ErrNotTweetAuthor
+	}
+
+	previousAt := edited.CreatedAt
+	if edited.EditedAt != nil {
+		previousAt = *edited.EditedAt
+	}
+	db.revisions[tweet.ID] = append(db.revisions[tweet.ID], twitter.TweetRevision{
+		TweetID:   tweet.ID,
+		Version:   len(db.revisions[tweet.ID]) + 1,
+		Content:   edited.Content,
+		CreatedAt: previousAt,
+	})
 
-	return timeline, nil
+	editedAt := time.Now().UTC()
+	edited.Content = tweet.Content
+	edited.EditedAt = &editedAt
+	db.updateTweet(edited)
+	db.addOutbox(database.OutboxEdit, edited)
+	return edited, nil
+}
+
+func (db *InMemoryDB) GetTweetRevisions(ctx context.Context, tweetID int64) ([]twitter.TweetRevision, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	result := make([]twitter.TweetRevision, len(db.revisions[tweetID]))
+	copy(result, db.revisions[tweetID])
+	return result, nil
+}
+
+// tweets are stored twice, in the tweets map & in the author's list
+func (db *InMemoryDB) updateTweet(tweet twitter.Tweet) {
+	db.tweets[tweet.ID] = tweet
+	userTweets := db.userTweets[tweet.UserID]
+	for i := range userTweets {
+		if userTweets[i].ID == tweet.ID {
+			userTweets[i] = tweet
+			return
+		}
+	}
 }
 
 func (db *InMemoryDB) FollowUser(ctx context.Context, follow twitter.Follow) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	if follow.FollowerID == follow.FolloweeID {
+		return fmt.Errorf("failed to follow user: %w", twitter.ErrSelfFollow)
+	}
+	for _, id := range []int64{follow.FollowerID, follow.FolloweeID} {
+		if _, exists := db.users[id]; !exists {
+			return fmt.Errorf("failed to follow user: user with ID %d: %w", id, twitter.ErrReferenceNotFound)
+		}
+	}
 	if _, exists := db.follows[follow.FollowerID]; !exists {
-		db.follows[follow.FollowerID] = make(map[int64]struct{})
+		db.follows[follow.FollowerID] = make(map[int64]time.Time)
 	}
-
-	db.follows[follow.FollowerID][follow.FolloweeID] = struct{}{}
+	if _, exists := db.follows[follow.FollowerID][follow.FolloweeID]; exists {
+		return nil // already followed
+	}
+	follow.CreatedAt = time.Now().UTC()
+	db.follows[follow.FollowerID][follow.FolloweeID] = follow.CreatedAt
+	db.addOutbox(database.OutboxFollow, follow)
 	return nil
 }
 
-func (db *InMemoryDB) GetUser(ctx context.Context, id int64) (twitter.User, error) {
+func (db *InMemoryDB) UnfollowUser(ctx context.Context, follow twitter.Follow) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
+
+	if _, exists := db.follows[follow.FollowerID][follow.FolloweeID]; !exists {
+		return nil // not followed
+	}
+	delete(db.follows[follow.FollowerID], follow.FolloweeID)
+	db.addOutbox(database.OutboxUnfollow, follow)
+	return nil
+}
+
+func (db *InMemoryDB) IsFollowing(ctx context.Context, follow twitter.Follow) (bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	_, exists := db.follows[follow.FollowerID][follow.FolloweeID]
+	return exists, nil
+}
+
+// as in postgres, created_at of the users is the time of the follow
+func (db *InMemoryDB) Followers(ctx context.Context, userId int64) ([]twitter.User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	followedAt := make(map[int64]time.Time)
+	for followerID, followed := range db.follows {
+		if at, exists := followed[userId]; exists {
+			followedAt[followerID] = at
+		}
+	}
+	return db.followUsers(followedAt), nil
+}
+
+func (db *InMemoryDB) Following(ctx context.Context, userId int64) ([]twitter.User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.followUsers(db.follows[userId]), nil
+}
+
+// users ordered by the time of the follow
+func (db *InMemoryDB) followUsers(followedAt map[int64]time.Time) []twitter.User {
+	var users []twitter.User
+	for id, at := range followedAt {
+		user := db.users[id]
+		user.CreatedAt = at
+		users = append(users, user)
+	}
+	slices.SortFunc(users, func(a, b twitter.User) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return users
+}
+
+func (db *InMemoryDB) GetUser(ctx context.Context, id int64) (twitter.User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	user, exists := db.users[id]
 	if !exists {
-		return twitter.User{}, fmt.Errorf("user not found")
+		return twitter.User{}, twitter.ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (db *InMemoryDB) CreateUser(ctx context.Context, user twitter.User) (twitter.User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// usernames are unique regardless of the case in postgres
+	for _, existing := range db.users {
+		if strings.EqualFold(existing.Username, user.Username) {
+			return twitter.User{}, fmt.Errorf("failed to insert user: %w", twitter.ErrUsernameTaken)
+		}
 	}
+	user.ID = db.nextUserID
+	db.nextUserID++
+	user.CreatedAt = time.Now().UTC()
+	db.users[user.ID] = user
 	return user, nil
 }
 
-func (db *InMemoryDB) CreateUser(ctx context.Context, user twitter.User) (int64, error) {
+func (db *InMemoryDB) UpdateProfile(ctx context.Context, user twitter.User) (twitter.User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	existing, exists := db.users[user.ID]
+	if !exists {
+		return twitter.User{}, twitter.ErrUserNotFound
+	}
+	existing.DisplayName = user.DisplayName
+	existing.Bio = user.Bio
+	existing.Location = user.Location
+	existing.Website = user.Website
+	db.users[user.ID] = existing
+	return existing, nil
+}
+
+func (db *InMemoryDB) LikeTweet(ctx context.Context, like twitter.Like) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, exists := db.tweets[like.TweetID]; !exists {
+		return false, fmt.Errorf("failed to like tweet: tweet with ID %d: %w", like.TweetID, twitter.ErrReferenceNotFound)
+	}
+	if _, exists := db.users[like.UserID]; !exists {
+		return false, fmt.Errorf("failed to like tweet: user with ID %d: %w", like.UserID, twitter.ErrReferenceNotFound)
+	}
+	if _, exists := db.likes[like.TweetID]; !exists {
+		db.likes[like.TweetID] = make(map[int64]time.Time)
+	}
+	if _, liked := db.likes[like.TweetID][like.UserID]; liked {
+		return false, nil
+	}
+	db.likes[like.TweetID][like.UserID] = time.Now().UTC()
+	return true, nil
+}
+
+func (db *InMemoryDB) UnlikeTweet(ctx context.Context, like twitter.Like) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, liked := db.likes[like.TweetID][like.UserID]; !liked {
+		return false, nil
+	}
+	delete(db.likes[like.TweetID], like.UserID)
+	return true, nil
+}
+
+func (db *InMemoryDB) LikesCount(ctx context.Context, tweetIDs []int64) (map[int64]int64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	result := make(map[int64]int64, len(tweetIDs))
+	for _, id := range tweetIDs {
+		result[id] = int64(len(db.likes[id]))
+	}
+	return result, nil
+}
+
+func (db *InMemoryDB) LikedBy(ctx context.Context, tweetID int64, offset, limit int) ([]twitter.User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	type userLike struct {
+		userID  int64
+		likedAt time.Time
+	}
+	likes := make([]userLike, 0, len(db.likes[tweetID]))
+	for userID, likedAt := range db.likes[tweetID] {
+		likes = append(likes, userLike{userID: userID, likedAt: likedAt})
+	}
+	sort.Slice(likes, func(i, j int) bool {
+		if likes[i].likedAt.Equal(likes[j].likedAt) {
+			return likes[i].userID < likes[j].userID
+		}
+		return likes[i].likedAt.After(likes[j].likedAt)
+	})
+
+	users := []twitter.User{}
+	for i := offset; i < len(likes) && len(users) < limit; i++ {
+		user := db.users[likes[i].userID]
+		user.CreatedAt = likes[i].likedAt
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (db *InMemoryDB) ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]database.OutboxEntry, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	now := time.Now()
+	entries := []database.OutboxEntry{}
+	for i := 0; i < len(db.outbox) && len(entries) < limit; i++ {
+		if db.outbox[i].claimedUntil.After(now) {
+			continue // someone else is relaying it
+		}
+		db.outbox[i].claimedUntil = now.Add(lease)
+		entries = append(entries, db.outbox[i].OutboxEntry)
+	}
+	return entries, nil
+}
+
+func (db *InMemoryDB) DeleteOutbox(ctx context.Context, ids []int64) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	id := int64(len(db.users)) + 1
-	db.users[id] = user
-	return id, nil
+
+	db.outbox = slices.DeleteFunc(db.outbox, func(entry outboxEntry) bool {
+		return slices.Contains(ids, entry.ID)
+	})
+	return nil
 }
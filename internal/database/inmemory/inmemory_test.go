@@ -0,0 +1,13 @@
+package inmemory
+
+import (
+	"testing"
+	"twitter-clone/internal/database/dbtest"
+	"twitter-clone/internal/domain/database"
+)
+
+func TestInMemoryDBBehavior(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) database.DatabaseI {
+		return NewInMemoryDB()
+	})
+}
@@ -1,13 +1,20 @@
 package postgres
 
 import (
+	"cmp"
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"time"
 	"twitter-clone/internal/domain/config"
+	"twitter-clone/internal/domain/database"
 	"twitter-clone/internal/domain/twitter"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type PostgresDB struct {
@@ -15,7 +22,17 @@ type PostgresDB struct {
 }
 
 func NewPostgresDB(config config.DatabaseConfig) (*PostgresDB, error) {
-	connStr := fmt.Sprintf(
+	// Connect to the database using sqlx
+	db, err := sqlx.Connect("postgres", connString(config))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	return &PostgresDB{db: db}, nil
+}
+
+func connString(config config.DatabaseConfig) string {
+	return fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		config.DatabaseHost(),
 		config.DatabasePort(),
@@ -23,76 +40,263 @@ func NewPostgresDB(config config.DatabaseConfig) (*PostgresDB, error) {
 		config.DatabasePassword(),
 		config.DatabaseName(),
 	)
+}
 
-	// Connect to the database using sqlx
-	db, err := sqlx.Connect("postgres", connStr)
+func (p *PostgresDB) NewTweet(ctx context.Context, tweet twitter.Tweet) (twitter.Tweet, error) {
+	tx, err := p.db.BeginTxx(ctx, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to database: %w", err)
+		return twitter.Tweet{}, fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer func() {
+		_ = tx.Rollback() // no-op after commit
+	}()
 
-	return &PostgresDB{db: db}, nil
-}
-
-func (p *PostgresDB) NewTweet(ctx context.Context, tweet twitter.Tweet) (int64, error) {
 	query := `
-        INSERT INTO tweets (user_id, content)
-        VALUES ($1, $2)
-        RETURNING id
+        INSERT INTO tweets (user_id, content, in_reply_to, root_id, kind, referenced_id)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, created_at
     ` // https://stackoverflow.com/questions/19167349/postgresql-insert-from-select-returning-id
-	var tweetID int64
-	err := p.db.QueryRowxContext(ctx, query, tweet.UserID, tweet.Content).Scan(&tweetID)
+	args := []any{tweet.UserID, tweet.Content, tweet.InReplyTo, tweet.RootID, tweet.Kind, tweet.ReferencedID}
+	// the id comes from the id generator, the database makes one up only if it's not set
+	if tweet.ID != 0 {
+		query = `
+            INSERT INTO tweets (user_id, content, in_reply_to, root_id, kind, referenced_id, id)
+            VALUES ($1, $2, $3, $4, $5, $6, $7)
+            RETURNING id, created_at
+        `
+		args = append(args, tweet.ID)
+	}
+	err = tx.QueryRowxContext(ctx, query, args...).Scan(&tweet.ID, &tweet.CreatedAt)
 	if err != nil {
-		return 0, fmt.Errorf("failed to insert tweet: %w", err)
+		return twitter.Tweet{}, fmt.Errorf("failed to insert tweet: %w", domainError(err))
+	}
+	tweet.EditedAt = nil
+	if err = addOutbox(ctx, tx, database.OutboxTweet, tweet); err != nil {
+		return twitter.Tweet{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return twitter.Tweet{}, fmt.Errorf("failed to commit tweet: %w", err)
 	}
-	return tweetID, nil
+	return tweet, nil
 }
 
 func (p *PostgresDB) GetTweet(ctx context.Context, tweetID int64) (twitter.Tweet, error) {
 	var tweet twitter.Tweet
 	query := `
-        SELECT id, user_id, content, created_at
+        SELECT id, user_id, content, in_reply_to, root_id, kind, referenced_id, created_at, edited_at
         FROM tweets
         WHERE id = $1
     `
 	err := p.db.GetContext(ctx, &tweet, query, tweetID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return twitter.Tweet{}, twitter.ErrTweetNotFound
+	}
 	if err != nil {
 		return twitter.Tweet{}, fmt.Errorf("failed to get tweet: %w", err)
 	}
 	return tweet, nil
 }
 
-func (p *PostgresDB) GetUsersTweets(ctx context.Context, userID int64) ([]twitter.Tweet, error) {
-	var tweets []twitter.Tweet
-	query := `
-        SELECT id, user_id, content, created_at
+func (p *PostgresDB) GetUsersTweets(ctx context.Context, userID int64, cursor twitter.Cursor) ([]twitter.Tweet, error) {
+	tweets := []twitter.Tweet{}
+	// ids grow with time, so they are used as a key for the pages
+	query := fmt.Sprintf(`
+        SELECT id, user_id, content, in_reply_to, root_id, kind, referenced_id, created_at, edited_at
         FROM tweets
-        WHERE user_id = $1
-        ORDER BY created_at DESC
-    `
-	err := p.db.SelectContext(ctx, &tweets, query, userID)
+        WHERE user_id = $1 AND ($2::bigint = 0 OR id < $2::bigint) AND ($3::bigint = 0 OR id > $3::bigint)
+        ORDER BY id %s
+        LIMIT $4
+    `, pageOrder(cursor))
+	err := p.db.SelectContext(ctx, &tweets, query, userID, cursor.Before, cursor.After, cursor.Limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user's tweets: %w", err)
 	}
-	return tweets, nil
+	return newestFirst(tweets, cursor), nil
 }
 
-func (p *PostgresDB) GetTimeline(ctx context.Context, userID int64) ([]twitter.Tweet, error) {
-	var tweets []twitter.Tweet
-	query := `
-        SELECT t.id, t.user_id, t.content, t.created_at
+func (p *PostgresDB) GetTimeline(ctx context.Context, userID int64, cursor twitter.Cursor) ([]twitter.Tweet, error) {
+	tweets := []twitter.Tweet{}
+	query := fmt.Sprintf(`
+        SELECT t.id, t.user_id, t.content, t.in_reply_to, t.root_id, t.kind, t.referenced_id, t.created_at, t.edited_at
         FROM tweets t
         JOIN follows f ON t.user_id = f.followed_id
-        WHERE f.follower_id = $1
-        ORDER BY t.created_at DESC
-		LIMIT 50 
-    ` // TODO add LIMIT to config or as a param sent
-	err := p.db.SelectContext(ctx, &tweets, query, userID)
+        WHERE f.follower_id = $1 AND ($2::bigint = 0 OR t.id < $2::bigint) AND ($3::bigint = 0 OR t.id > $3::bigint)
+        ORDER BY t.id %s
+        LIMIT $4
+    `, pageOrder(cursor))
+	err := p.db.SelectContext(ctx, &tweets, query, userID, cursor.Before, cursor.After, cursor.Limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get timeline: %w", err)
 	}
+	return newestFirst(tweets, cursor), nil
+}
+
+// page after the cursor has to start right after it,
+// so it's read from the oldest tweets and reversed later
+func pageOrder(cursor twitter.Cursor) string {
+	if cursor.After != 0 {
+		return "ASC"
+	}
+	return "DESC"
+}
+
+func newestFirst(tweets []twitter.Tweet, cursor twitter.Cursor) []twitter.Tweet {
+	if cursor.After != 0 {
+		slices.Reverse(tweets)
+	}
+	return tweets
+}
+
+func (p *PostgresDB) GetConversation(ctx context.Context, rootID int64) ([]twitter.Tweet, error) {
+	var tweets []twitter.Tweet
+	query := `
+        SELECT id, user_id, content, in_reply_to, root_id, kind, referenced_id, created_at, edited_at
+        FROM tweets
+        WHERE id = $1 OR root_id = $1
+        ORDER BY id
+    `
+	err := p.db.SelectContext(ctx, &tweets, query, rootID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversation: %w", err)
+	}
 	return tweets, nil
 }
 
+func (p *PostgresDB) DeleteTweet(ctx context.Context, tweetID, userID int64) (database.DeletedTweet, error) {
+	var deleted database.DeletedTweet
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return database.DeletedTweet{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback() // no-op after commit
+	}()
+
+	var authorID int64
+	err = tx.GetContext(ctx, &authorID, `SELECT user_id FROM tweets WHERE id = $1
+        FOR UPDATE`, tweetID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.DeletedTweet{}, twitter.ErrTweetNotFound
+	}
+	if err != nil {
+		return database.DeletedTweet{}, fmt.Errorf("failed to get tweet: %w", err)
+	}
+	if authorID != userID {
+		return database.DeletedTweet{}, twitter.ErrNotTweetAuthor
+	}
+
+	// retweets have nothing without the tweet, quotes keep their content
+	query := `
+        DELETE FROM tweets
+        WHERE referenced_id = $1 AND kind = 'retweet'
+        RETURNING id, user_id, content, in_reply_to, root_id, kind, referenced_id, created_at, edited_at
+    `
+	if err = tx.SelectContext(ctx, &deleted.Retweets, query, tweetID); err != nil {
+		return database.DeletedTweet{}, fmt.Errorf("failed to delete retweets: %w", err)
+	}
+	query = `
+        UPDATE tweets
+        SET referenced_id = NULL
+        WHERE referenced_id = $1 AND kind = 'quote'
+        RETURNING id, user_id, content, in_reply_to, root_id, kind, referenced_id, created_at, edited_at
+    `
+	if err = tx.SelectContext(ctx, &deleted.Quotes, query, tweetID); err != nil {
+		return database.DeletedTweet{}, fmt.Errorf("failed to detach quotes: %w", err)
+	}
+	query = `
+        DELETE FROM tweets
+        WHERE id = $1
+        RETURNING id, user_id, content, in_reply_to, root_id, kind, referenced_id, created_at, edited_at
+    `
+	if err = tx.GetContext(ctx, &deleted.Tweet, query, tweetID); err != nil {
+		return database.DeletedTweet{}, fmt.Errorf("failed to delete tweet: %w", err)
+	}
+	if err = addOutbox(ctx, tx, database.OutboxDelete, deleted); err != nil {
+		return database.DeletedTweet{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return database.DeletedTweet{}, fmt.Errorf("failed to commit delete: %w", err)
+	}
+	return deleted, nil
+}
+
+func (p *PostgresDB) EditTweet(ctx context.Context, tweet twitter.Tweet) (twitter.Tweet, error) {
+	var (
+		previous twitter.Tweet
+		edited   twitter.Tweet
+	)
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return twitter.Tweet{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback() // no-op after commit
+	}()
+
+	query := `
+        SELECT id, user_id, content, in_reply_to, root_id, kind, referenced_id, created_at, edited_at
+        FROM tweets
+        WHERE id = $1
+        FOR UPDATE
+    `
+	err = tx.GetContext(ctx, &previous, query, tweet.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return twitter.Tweet{}, twitter.ErrTweetNotFound
+	}
+	if err != nil {
+		return twitter.Tweet{}, fmt.Errorf("failed to get tweet: %w", err)
+	}
+	if previous.UserID != tweet.UserID {
+		return twitter.Tweet{}, twitter.ErrNotTweetAuthor
+	}
+
+	previousAt := previous.CreatedAt
+	if previous.EditedAt != nil {
+		previousAt = *previous.EditedAt
+	}
+	query = `
+        INSERT INTO tweet_revisions (tweet_id, version, content, created_at)
+        SELECT $1, COUNT(*) + 1, $2, $3
+        FROM tweet_revisions
+        WHERE tweet_id = $1
+    `
+	if _, err = tx.ExecContext(ctx, query, previous.ID, previous.Content, previousAt); err != nil {
+		return twitter.Tweet{}, fmt.Errorf("failed to save tweet revision: %w", err)
+	}
+
+	query = `
+        UPDATE tweets
+        SET content = $2, edited_at = CURRENT_TIMESTAMP
+        WHERE id = $1
+        RETURNING id, user_id, content, in_reply_to, root_id, kind, referenced_id, created_at, edited_at
+    `
+	if err = tx.GetContext(ctx, &edited, query, tweet.ID, tweet.Content); err != nil {
+		return twitter.Tweet{}, fmt.Errorf("failed to update tweet: %w", err)
+	}
+	if err = addOutbox(ctx, tx, database.OutboxEdit, edited); err != nil {
+		return twitter.Tweet{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return twitter.Tweet{}, fmt.Errorf("failed to commit tweet edit: %w", err)
+	}
+	return edited, nil
+}
+
+func (p *PostgresDB) GetTweetRevisions(ctx context.Context, tweetID int64) ([]twitter.TweetRevision, error) {
+	var revisions []twitter.TweetRevision
+	query := `
+        SELECT tweet_id, version, content, created_at
+        FROM tweet_revisions
+        WHERE tweet_id = $1
+        ORDER BY version
+    `
+	err := p.db.SelectContext(ctx, &revisions, query, tweetID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tweet revisions: %w", err)
+	}
+	return revisions, nil
+}
+
 // type User struct {
 // 	ID          int64     `json:"id"`
 // 	Username    string    `json:"username"`
@@ -102,27 +306,54 @@ func (p *PostgresDB) GetTimeline(ctx context.Context, userID int64) ([]twitter.T
 func (p *PostgresDB) GetUser(ctx context.Context, id int64) (twitter.User, error) {
 	var user twitter.User
 	query := `
-        SELECT id, username, created_at
+        SELECT id, username, display_name, bio, location, website, created_at
         FROM users
         WHERE id = $1
 		`
 	err := p.db.GetContext(ctx, &user, query, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return twitter.User{}, twitter.ErrUserNotFound
+	}
 	if err != nil {
 		return twitter.User{}, fmt.Errorf("failed to get user: %w", err)
 	}
 	return user, nil
 }
 
-func (p *PostgresDB) CreateUser(ctx context.Context, userData twitter.User) (int64, error) {
-	var userID int64
+func (p *PostgresDB) CreateUser(ctx context.Context, userData twitter.User) (twitter.User, error) {
+	var user twitter.User
 	query := `
-		INSERT INTO users (username) VALUES ($1)  RETURNING id;
+		INSERT INTO users (username, display_name, bio, location, website)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, username, display_name, bio, location, website, created_at;
 	`
-	err := p.db.QueryRowxContext(ctx, query, userData.Username).Scan(&userID)
+	err := p.db.GetContext(ctx, &user, query,
+		userData.Username, userData.DisplayName, userData.Bio, userData.Location, userData.Website,
+	)
+	if err != nil {
+		return twitter.User{}, fmt.Errorf("failed to insert user: %w", domainError(err))
+	}
+	return user, nil
+}
+
+func (p *PostgresDB) UpdateProfile(ctx context.Context, user twitter.User) (twitter.User, error) {
+	var updated twitter.User
+	query := `
+        UPDATE users
+        SET display_name = $2, bio = $3, location = $4, website = $5
+        WHERE id = $1
+        RETURNING id, username, display_name, bio, location, website, created_at
+    `
+	err := p.db.GetContext(ctx, &updated, query,
+		user.ID, user.DisplayName, user.Bio, user.Location, user.Website,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return twitter.User{}, twitter.ErrUserNotFound
+	}
 	if err != nil {
-		return 0, fmt.Errorf("failed to insert user: %w", err)
+		return twitter.User{}, fmt.Errorf("failed to update profile: %w", err)
 	}
-	return userID, nil
+	return updated, nil
 }
 
 ///////////////////////////////////////////
@@ -130,25 +361,89 @@ func (p *PostgresDB) CreateUser(ctx context.Context, userData twitter.User) (int
 ///////////////////////////////////////////
 
 func (p *PostgresDB) FollowUser(ctx context.Context, follow twitter.Follow) error {
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback() // no-op after commit
+	}()
+
 	query := `
         INSERT INTO follows (follower_id, followed_id)
         VALUES ($1, $2)
         ON CONFLICT DO NOTHING
+        RETURNING created_at
     `
-	_, err := p.db.ExecContext(ctx, query, follow.FollowerID, follow.FolloweeID)
+	err = tx.GetContext(ctx, &follow.CreatedAt, query, follow.FollowerID, follow.FolloweeID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil // already followed, nothing to pass on
+	}
 	if err != nil {
-		return fmt.Errorf("failed to follow user: %w", err)
+		return fmt.Errorf("failed to follow user: %w", domainError(err))
+	}
+	if err = addOutbox(ctx, tx, database.OutboxFollow, follow); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit follow: %w", err)
 	}
 	return nil
 }
 
+func (p *PostgresDB) UnfollowUser(ctx context.Context, follow twitter.Follow) error {
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback() // no-op after commit
+	}()
+
+	query := `
+        DELETE FROM follows
+        WHERE follower_id = $1 AND followed_id = $2
+    `
+	result, err := tx.ExecContext(ctx, query, follow.FollowerID, follow.FolloweeID)
+	if err != nil {
+		return fmt.Errorf("failed to unfollow user: %w", err)
+	}
+	if deleted, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to unfollow user: %w", err)
+	} else if deleted == 0 {
+		return nil // not followed, nothing to pass on
+	}
+	if err = addOutbox(ctx, tx, database.OutboxUnfollow, follow); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit unfollow: %w", err)
+	}
+	return nil
+}
+
+func (p *PostgresDB) IsFollowing(ctx context.Context, follow twitter.Follow) (bool, error) {
+	var following bool
+	query := `
+        SELECT EXISTS (
+            SELECT 1 FROM follows
+            WHERE follower_id = $1 AND followed_id = $2
+        )
+    `
+	if err := p.db.GetContext(ctx, &following, query, follow.FollowerID, follow.FolloweeID); err != nil {
+		return false, fmt.Errorf("failed to check follow: %w", err)
+	}
+	return following, nil
+}
+
 func (p *PostgresDB) Followers(ctx context.Context, userId int64) ([]twitter.User, error) {
 	var users []twitter.User
 	query := `
-        SELECT id, username, follows.created_at
+        SELECT id, username, display_name, bio, location, website, follows.created_at
         FROM users
         JOIN follows ON follows.follower_id = users.id
 		WHERE follows.followed_id = $1
+		ORDER BY follows.created_at, users.id
 		`
 	err := p.db.SelectContext(ctx, &users, query, userId)
 	if err != nil {
@@ -160,10 +455,11 @@ func (p *PostgresDB) Followers(ctx context.Context, userId int64) ([]twitter.Use
 func (p *PostgresDB) Following(ctx context.Context, userId int64) ([]twitter.User, error) {
 	var users []twitter.User
 	query := `
-        SELECT id, username, follows.created_at as created_at
+        SELECT id, username, display_name, bio, location, website, follows.created_at as created_at
         FROM users
         JOIN follows ON follows.followed_id = users.id
 		WHERE follows.follower_id = $1
+		ORDER BY follows.created_at, users.id
 		`
 	err := p.db.SelectContext(ctx, &users, query, userId)
 	if err != nil {
@@ -171,3 +467,134 @@ func (p *PostgresDB) Following(ctx context.Context, userId int64) ([]twitter.Use
 	}
 	return users, nil
 }
+
+///////////////////////////////////////////
+//	Likes part
+///////////////////////////////////////////
+
+func (p *PostgresDB) LikeTweet(ctx context.Context, like twitter.Like) (bool, error) {
+	query := `
+        INSERT INTO likes (user_id, tweet_id)
+        VALUES ($1, $2)
+        ON CONFLICT DO NOTHING
+    `
+	result, err := p.db.ExecContext(ctx, query, like.UserID, like.TweetID)
+	if err != nil {
+		return false, fmt.Errorf("failed to like tweet: %w", domainError(err))
+	}
+	return rowsChanged(result)
+}
+
+func (p *PostgresDB) UnlikeTweet(ctx context.Context, like twitter.Like) (bool, error) {
+	query := `
+        DELETE FROM likes
+        WHERE user_id = $1 AND tweet_id = $2
+    `
+	result, err := p.db.ExecContext(ctx, query, like.UserID, like.TweetID)
+	if err != nil {
+		return false, fmt.Errorf("failed to unlike tweet: %w", err)
+	}
+	return rowsChanged(result)
+}
+
+func (p *PostgresDB) LikesCount(ctx context.Context, tweetIDs []int64) (map[int64]int64, error) {
+	var counts []struct {
+		TweetID int64 `db:"tweet_id"`
+		Count   int64 `db:"count"`
+	}
+	query := `
+        SELECT tweet_id, COUNT(*) AS count
+        FROM likes
+        WHERE tweet_id = ANY($1)
+        GROUP BY tweet_id
+    `
+	err := p.db.SelectContext(ctx, &counts, query, pq.Array(tweetIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to count likes: %w", err)
+	}
+	result := make(map[int64]int64, len(tweetIDs))
+	for _, id := range tweetIDs {
+		result[id] = 0
+	}
+	for _, c := range counts {
+		result[c.TweetID] = c.Count
+	}
+	return result, nil
+}
+
+func (p *PostgresDB) LikedBy(ctx context.Context, tweetID int64, offset, limit int) ([]twitter.User, error) {
+	var users []twitter.User
+	query := `
+        SELECT id, username, display_name, bio, location, website, likes.created_at AS created_at
+        FROM users
+        JOIN likes ON likes.user_id = users.id
+        WHERE likes.tweet_id = $1
+        ORDER BY likes.created_at DESC, users.id
+        OFFSET $2 LIMIT $3
+    `
+	err := p.db.SelectContext(ctx, &users, query, tweetID, offset, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users who liked tweet: %w", err)
+	}
+	return users, nil
+}
+
+func rowsChanged(result sql.Result) (bool, error) {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return rows > 0, nil
+}
+
+///////////////////////////////////////////
+//	Outbox part
+///////////////////////////////////////////
+
+// the entry is written in the transaction of the change, so both of them are committed or none
+func addOutbox(ctx context.Context, tx *sqlx.Tx, kind database.OutboxKind, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s outbox entry: %w", kind, err)
+	}
+	query := `INSERT INTO outbox (kind, payload) VALUES ($1, $2)`
+	if _, err = tx.ExecContext(ctx, query, kind, payload); err != nil {
+		return fmt.Errorf("failed to insert %s outbox entry: %w", kind, err)
+	}
+	return nil
+}
+
+func (p *PostgresDB) ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]database.OutboxEntry, error) {
+	entries := []database.OutboxEntry{}
+	// the entries claimed by other relays at the same time are skipped, not waited for
+	query := `
+        UPDATE outbox
+        SET claimed_until = CURRENT_TIMESTAMP + make_interval(secs => $2)
+        WHERE id IN (
+            SELECT id
+            FROM outbox
+            WHERE claimed_until IS NULL OR claimed_until < CURRENT_TIMESTAMP
+            ORDER BY id
+            LIMIT $1
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING id, kind, payload, created_at
+    `
+	err := p.db.SelectContext(ctx, &entries, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim outbox entries: %w", err)
+	}
+	// RETURNING keeps no order
+	slices.SortFunc(entries, func(a, b database.OutboxEntry) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return entries, nil
+}
+
+func (p *PostgresDB) DeleteOutbox(ctx context.Context, ids []int64) error {
+	query := `DELETE FROM outbox WHERE id = ANY($1)`
+	if _, err := p.db.ExecContext(ctx, query, pq.Array(ids)); err != nil {
+		return fmt.Errorf("failed to delete outbox entries: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package postgres
+
+import (
+	"errors"
+	"twitter-clone/internal/domain/twitter"
+
+	"github.com/lib/pq"
+)
+
+// domain errors of the violated constraints, the ones missing here are internal errors
+var constraintErrors = map[string]error{
+	"tweets_pkey":               twitter.ErrTweetExists,
+	"idx_tweets_unique_retweet": twitter.ErrAlreadyRetweeted,
+	"no_self_follow":            twitter.ErrSelfFollow,
+	"users_username_key":        twitter.ErrUsernameTaken,
+	"idx_users_username_lower":  twitter.ErrUsernameTaken,
+}
+
+// domainError turns a constraint violation into the domain error, so the caller can tell
+// what went wrong. Any missing row referenced by a foreign key is twitter.ErrReferenceNotFound
+func domainError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+	if pqErr.Code.Name() == "foreign_key_violation" {
+		return twitter.ErrReferenceNotFound
+	}
+	if domainErr, ok := constraintErrors[pqErr.Constraint]; ok {
+		return domainErr
+	}
+	return err
+}
@@ -0,0 +1,42 @@
+package postgres
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"twitter-clone/internal/domain/config"
+
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+)
+
+// the schema is versioned together with the queries which use it
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// NewMigrator connects to the database of the config and returns its migrations,
+// closing the migrator closes the connection
+func NewMigrator(config config.DatabaseConfig) (*goose.Provider, error) {
+	fsys, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+	db, err := sql.Open("postgres", connString(config))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	// several instances may start at once, only one of them migrates
+	locker, err := lock.NewPostgresSessionLocker()
+	if err != nil {
+		_ = db.Close() // the lock error matters
+		return nil, fmt.Errorf("failed to create migration lock: %w", err)
+	}
+	provider, err := goose.NewProvider(goose.DialectPostgres, db, fsys, goose.WithSessionLocker(locker))
+	if err != nil {
+		_ = db.Close() // the migrations error matters
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+	return provider, nil
+}
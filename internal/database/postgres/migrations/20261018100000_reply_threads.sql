@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+-- in_reply_to is the direct parent of the reply, root_id is the first tweet of the conversation,
+-- it's kept when the root is deleted so the replies still make up one conversation
+ALTER TABLE tweets
+    ADD COLUMN in_reply_to BIGINT REFERENCES tweets(id) ON DELETE SET NULL,
+    ADD COLUMN root_id BIGINT;
+
+CREATE INDEX idx_tweets_root_id ON tweets(root_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_tweets_root_id;
+ALTER TABLE tweets
+    DROP COLUMN IF EXISTS root_id,
+    DROP COLUMN IF EXISTS in_reply_to;
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+-- kind is one of original, retweet, quote
+-- referenced_id points to the retweeted or quoted tweet, a quote keeps its content
+-- when the quoted tweet is deleted. Retweets have to be deleted before the tweet (see DeleteTweet),
+-- so the ones who have them in the timeline are told about it, the check fails otherwise
+ALTER TABLE tweets
+    ADD COLUMN kind VARCHAR(16) NOT NULL DEFAULT 'original',
+    ADD COLUMN referenced_id BIGINT REFERENCES tweets(id) ON DELETE SET NULL,
+    ADD CONSTRAINT tweet_kind CHECK (kind IN ('original', 'retweet', 'quote')),
+    ADD CONSTRAINT referenced_tweet CHECK (
+        (kind = 'original' AND referenced_id IS NULL)
+        OR (kind = 'retweet' AND referenced_id IS NOT NULL)
+        OR kind = 'quote'
+    );
+
+-- the same tweet can be retweeted by user only once
+CREATE UNIQUE INDEX idx_tweets_unique_retweet ON tweets(user_id, referenced_id) WHERE kind = 'retweet';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_tweets_unique_retweet;
+ALTER TABLE tweets
+    DROP CONSTRAINT IF EXISTS referenced_tweet,
+    DROP CONSTRAINT IF EXISTS tweet_kind,
+    DROP COLUMN IF EXISTS referenced_id,
+    DROP COLUMN IF EXISTS kind;
+-- +goose StatementEnd
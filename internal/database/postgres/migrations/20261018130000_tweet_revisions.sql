@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE tweets ADD COLUMN edited_at TIMESTAMP;
+
+-- previous versions of the tweet content, the current one stays in tweets
+CREATE TABLE tweet_revisions (
+    tweet_id BIGINT NOT NULL,
+    version INT NOT NULL,
+    content TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL, -- when this version was posted
+    PRIMARY KEY (tweet_id, version),
+    FOREIGN KEY (tweet_id) REFERENCES tweets(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS tweet_revisions CASCADE;
+ALTER TABLE tweets DROP COLUMN IF EXISTS edited_at;
+-- +goose StatementEnd
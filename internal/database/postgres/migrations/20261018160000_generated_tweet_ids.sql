@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- tweet ids are generated by the api (snowflake), the identity is left for the tweets inserted without one
+ALTER TABLE tweets ALTER COLUMN id SET GENERATED BY DEFAULT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE tweets ALTER COLUMN id SET GENERATED ALWAYS;
+-- +goose StatementEnd
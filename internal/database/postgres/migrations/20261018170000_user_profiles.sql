@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+    ADD COLUMN display_name VARCHAR(50) NOT NULL DEFAULT '',
+    ADD COLUMN bio VARCHAR(160) NOT NULL DEFAULT '',
+    ADD COLUMN location VARCHAR(30) NOT NULL DEFAULT '',
+    ADD COLUMN website VARCHAR(100) NOT NULL DEFAULT '';
+
+-- usernames are unique regardless of the case, fails if there are such duplicates already
+CREATE UNIQUE INDEX idx_users_username_lower ON users (LOWER(username));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_users_username_lower;
+ALTER TABLE users
+    DROP COLUMN IF EXISTS display_name,
+    DROP COLUMN IF EXISTS bio,
+    DROP COLUMN IF EXISTS location,
+    DROP COLUMN IF EXISTS website;
+-- +goose StatementEnd
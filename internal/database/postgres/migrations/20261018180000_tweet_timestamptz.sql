@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+-- the times of the tweets are compared with now, e.g. for the edit window, so they keep the time zone.
+-- The old values were written as the local time of the database and are converted in its time zone
+ALTER TABLE tweets
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN edited_at TYPE TIMESTAMPTZ;
+ALTER TABLE tweet_revisions ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE tweet_revisions ALTER COLUMN created_at TYPE TIMESTAMP;
+ALTER TABLE tweets
+    ALTER COLUMN created_at TYPE TIMESTAMP,
+    ALTER COLUMN edited_at TYPE TIMESTAMP;
+-- +goose StatementEnd
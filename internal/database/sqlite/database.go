@@ -0,0 +1,619 @@
+package sqlite
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+	"twitter-clone/internal/domain/config"
+	"twitter-clone/internal/domain/database"
+	"twitter-clone/internal/domain/twitter"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteDB keeps everything in one file, so the app runs without a database server.
+// It needs cgo, the driver is a binding to the C library
+type SQLiteDB struct {
+	db *sqlx.DB
+}
+
+// NewSQLiteDB opens the database file at the path of the config, creates it if needed
+// and brings the schema up to date
+func NewSQLiteDB(ctx context.Context, config config.DatabaseConfig) (*SQLiteDB, error) {
+	db, err := sqlx.Open("sqlite3", connString(config))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// sqlite has one writer at a time anyway, and a single connection serializes the transactions
+	db.SetMaxOpenConns(1)
+
+	// there is nobody else to run the migrations of a local file
+	provider, err := newProvider(db.DB)
+	if err == nil {
+		_, err = provider.Up(ctx)
+	}
+	if err != nil {
+		_ = db.Close() // the migration error matters
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+	return &SQLiteDB{db: db}, nil
+}
+
+func connString(config config.DatabaseConfig) string {
+	// foreign keys are off in sqlite unless they are turned on for the connection
+	return fmt.Sprintf("file:%s?_foreign_keys=on&_busy_timeout=5000", config.DatabasePath())
+}
+
+func (s *SQLiteDB) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteDB) NewTweet(ctx context.Context, tweet twitter.Tweet) (twitter.Tweet, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return twitter.Tweet{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback() // no-op after commit
+	}()
+
+	query := `
+        INSERT INTO tweets (user_id, content, in_reply_to, root_id, kind, referenced_id)
+        VALUES (?, ?, ?, ?, ?, ?)
+        RETURNING id, created_at
+    `
+	args := []any{tweet.UserID, tweet.Content, tweet.InReplyTo, tweet.RootID, tweet.Kind, tweet.ReferencedID}
+	// the id comes from the id generator, the database makes one up only if it's not set
+	if tweet.ID != 0 {
+		query = `
+            INSERT INTO tweets (user_id, content, in_reply_to, root_id, kind, referenced_id, id)
+            VALUES (?, ?, ?, ?, ?, ?, ?)
+            RETURNING id, created_at
+        `
+		args = append(args, tweet.ID)
+	}
+	err = tx.QueryRowxContext(ctx, query, args...).Scan(&tweet.ID, &tweet.CreatedAt)
+	if err != nil {
+		return twitter.Tweet{}, fmt.Errorf("failed to insert tweet: %w", domainError(err))
+	}
+	tweet.EditedAt = nil
+	if err = addOutbox(ctx, tx, database.OutboxTweet, tweet); err != nil {
+		return twitter.Tweet{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return twitter.Tweet{}, fmt.Errorf("failed to commit tweet: %w", err)
+	}
+	return tweet, nil
+}
+
+func (s *SQLiteDB) GetTweet(ctx context.Context, tweetID int64) (twitter.Tweet, error) {
+	var tweet twitter.Tweet
+	query := `
+        SELECT id, user_id, content, in_reply_to, root_id, kind, referenced_id, created_at, edited_at
+        FROM tweets
+        WHERE id = ?
+    `
+	err := s.db.GetContext(ctx, &tweet, query, tweetID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return twitter.Tweet{}, twitter.ErrTweetNotFound
+	}
+	if err != nil {
+		return twitter.Tweet{}, fmt.Errorf("failed to get tweet: %w", err)
+	}
+	return tweet, nil
+}
+
+func (s *SQLiteDB) GetUsersTweets(ctx context.Context, userID int64, cursor twitter.Cursor) ([]twitter.Tweet, error) {
+	tweets := []twitter.Tweet{}
+	// ids grow with time, so they are used as a key for the pages
+	query := fmt.Sprintf(`
+        SELECT id, user_id, content, in_reply_to, root_id, kind, referenced_id, created_at, edited_at
+        FROM tweets
+        WHERE user_id = ? AND (? = 0 OR id < ?) AND (? = 0 OR id > ?)
+        ORDER BY id %s
+        LIMIT ?
+    `, pageOrder(cursor))
+	err := s.db.SelectContext(ctx, &tweets, query,
+		userID, cursor.Before, cursor.Before, cursor.After, cursor.After, cursor.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user's tweets: %w", err)
+	}
+	return newestFirst(tweets, cursor), nil
+}
+
+func (s *SQLiteDB) GetTimeline(ctx context.Context, userID int64, cursor twitter.Cursor) ([]twitter.Tweet, error) {
+	tweets := []twitter.Tweet{}
+	query := fmt.Sprintf(`
+        SELECT t.id, t.user_id, t.content, t.in_reply_to, t.root_id, t.kind, t.referenced_id, t.created_at, t.edited_at
+        FROM tweets t
+        JOIN follows f ON t.user_id = f.followed_id
+        WHERE f.follower_id = ? AND (? = 0 OR t.id < ?) AND (? = 0 OR t.id > ?)
+        ORDER BY t.id %s
+        LIMIT ?
+    `, pageOrder(cursor))
+	err := s.db.SelectContext(ctx, &tweets, query,
+		userID, cursor.Before, cursor.Before, cursor.After, cursor.After, cursor.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get timeline: %w", err)
+	}
+	return newestFirst(tweets, cursor), nil
+}
+
+// page after the cursor has to start right after it,
+// so it's read from the oldest tweets and reversed later
+func pageOrder(cursor twitter.Cursor) string {
+	if cursor.After != 0 {
+		return "ASC"
+	}
+	return "DESC"
+}
+
+func newestFirst(tweets []twitter.Tweet, cursor twitter.Cursor) []twitter.Tweet {
+	if cursor.After != 0 {
+		slices.Reverse(tweets)
+	}
+	return tweets
+}
+
+func (s *SQLiteDB) GetConversation(ctx context.Context, rootID int64) ([]twitter.Tweet, error) {
+	var tweets []twitter.Tweet
+	query := `
+        SELECT id, user_id, content, in_reply_to, root_id, kind, referenced_id, created_at, edited_at
+        FROM tweets
+        WHERE id = ? OR root_id = ?
+        ORDER BY id
+    `
+	err := s.db.SelectContext(ctx, &tweets, query, rootID, rootID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversation: %w", err)
+	}
+	return tweets, nil
+}
+
+func (s *SQLiteDB) DeleteTweet(ctx context.Context, tweetID, userID int64) (database.DeletedTweet, error) {
+	var deleted database.DeletedTweet
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return database.DeletedTweet{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback() // no-op after commit
+	}()
+
+	var authorID int64
+	err = tx.GetContext(ctx, &authorID, `SELECT user_id FROM tweets WHERE id = ?`, tweetID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.DeletedTweet{}, twitter.ErrTweetNotFound
+	}
+	if err != nil {
+		return database.DeletedTweet{}, fmt.Errorf("failed to get tweet: %w", err)
+	}
+	if authorID != userID {
+		return database.DeletedTweet{}, twitter.ErrNotTweetAuthor
+	}
+
+	// retweets have nothing without the tweet, quotes keep their content
+	query := `
+        DELETE FROM tweets
+        WHERE referenced_id = ? AND kind = 'retweet'
+        RETURNING id, user_id, content, in_reply_to, root_id, kind, referenced_id, created_at, edited_at
+    `
+	if err = tx.SelectContext(ctx, &deleted.Retweets, query, tweetID); err != nil {
+		return database.DeletedTweet{}, fmt.Errorf("failed to delete retweets: %w", err)
+	}
+	query = `
+        UPDATE tweets
+        SET referenced_id = NULL
+        WHERE referenced_id = ? AND kind = 'quote'
+        RETURNING id, user_id, content, in_reply_to, root_id, kind, referenced_id, created_at, edited_at
+    `
+	if err = tx.SelectContext(ctx, &deleted.Quotes, query, tweetID); err != nil {
+		return database.DeletedTweet{}, fmt.Errorf("failed to detach quotes: %w", err)
+	}
+	query = `
+        DELETE FROM tweets
+        WHERE id = ?
+        RETURNING id, user_id, content, in_reply_to, root_id, kind, referenced_id, created_at, edited_at
+    `
+	if err = tx.GetContext(ctx, &deleted.Tweet, query, tweetID); err != nil {
+		return database.DeletedTweet{}, fmt.Errorf("failed to delete tweet: %w", err)
+	}
+	if err = addOutbox(ctx, tx, database.OutboxDelete, deleted); err != nil {
+		return database.DeletedTweet{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return database.DeletedTweet{}, fmt.Errorf("failed to commit delete: %w", err)
+	}
+	return deleted, nil
+}
+
+func (s *SQLiteDB) EditTweet(ctx context.Context, tweet twitter.Tweet) (twitter.Tweet, error) {
+	var (
+		previous twitter.Tweet
+		edited   twitter.Tweet
+	)
+	// there is no FOR UPDATE, the only connection keeps others out until the commit
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return twitter.Tweet{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback() // no-op after commit
+	}()
+
+	query := `
+        SELECT id, user_id, content, in_reply_to, root_id, kind, referenced_id, created_at, edited_at
+        FROM tweets
+        WHERE id = ?
+    `
+	err = tx.GetContext(ctx, &previous, query, tweet.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return twitter.Tweet{}, twitter.ErrTweetNotFound
+	}
+	if err != nil {
+		return twitter.Tweet{}, fmt.Errorf("failed to get tweet: %w", err)
+	}
+	if previous.UserID != tweet.UserID {
+		return twitter.Tweet{}, twitter.ErrNotTweetAuthor
+	}
+
+	previousAt := previous.CreatedAt
+	if previous.EditedAt != nil {
+		previousAt = *previous.EditedAt
+	}
+	query = `
+        INSERT INTO tweet_revisions (tweet_id, version, content, created_at)
+        SELECT ?, COUNT(*) + 1, ?, ?
+        FROM tweet_revisions
+        WHERE tweet_id = ?
+    `
+	if _, err = tx.ExecContext(ctx, query, previous.ID, previous.Content, previousAt, previous.ID); err != nil {
+		return twitter.Tweet{}, fmt.Errorf("failed to save tweet revision: %w", err)
+	}
+
+	query = `
+        UPDATE tweets
+        SET content = ?, edited_at = strftime('%Y-%m-%d %H:%M:%f', 'now')
+        WHERE id = ?
+        RETURNING id, user_id, content, in_reply_to, root_id, kind, referenced_id, created_at, edited_at
+    `
+	if err = tx.GetContext(ctx, &edited, query, tweet.Content, tweet.ID); err != nil {
+		return twitter.Tweet{}, fmt.Errorf("failed to update tweet: %w", err)
+	}
+	if err = addOutbox(ctx, tx, database.OutboxEdit, edited); err != nil {
+		return twitter.Tweet{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return twitter.Tweet{}, fmt.Errorf("failed to commit tweet edit: %w", err)
+	}
+	return edited, nil
+}
+
+func (s *SQLiteDB) GetTweetRevisions(ctx context.Context, tweetID int64) ([]twitter.TweetRevision, error) {
+	var revisions []twitter.TweetRevision
+	query := `
+        SELECT tweet_id, version, content, created_at
+        FROM tweet_revisions
+        WHERE tweet_id = ?
+        ORDER BY version
+    `
+	err := s.db.SelectContext(ctx, &revisions, query, tweetID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tweet revisions: %w", err)
+	}
+	return revisions, nil
+}
+
+func (s *SQLiteDB) GetUser(ctx context.Context, id int64) (twitter.User, error) {
+	var user twitter.User
+	query := `
+        SELECT id, username, display_name, bio, location, website, created_at
+        FROM users
+        WHERE id = ?
+    `
+	err := s.db.GetContext(ctx, &user, query, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return twitter.User{}, twitter.ErrUserNotFound
+	}
+	if err != nil {
+		return twitter.User{}, fmt.Errorf("failed to get user: %w", err)
+	}
+	return user, nil
+}
+
+func (s *SQLiteDB) CreateUser(ctx context.Context, userData twitter.User) (twitter.User, error) {
+	var user twitter.User
+	query := `
+        INSERT INTO users (username, display_name, bio, location, website)
+        VALUES (?, ?, ?, ?, ?)
+        RETURNING id, username, display_name, bio, location, website, created_at
+    `
+	err := s.db.GetContext(ctx, &user, query,
+		userData.Username, userData.DisplayName, userData.Bio, userData.Location, userData.Website,
+	)
+	if err != nil {
+		return twitter.User{}, fmt.Errorf("failed to insert user: %w", domainError(err))
+	}
+	return user, nil
+}
+
+func (s *SQLiteDB) UpdateProfile(ctx context.Context, user twitter.User) (twitter.User, error) {
+	var updated twitter.User
+	query := `
+        UPDATE users
+        SET display_name = ?, bio = ?, location = ?, website = ?
+        WHERE id = ?
+        RETURNING id, username, display_name, bio, location, website, created_at
+    `
+	err := s.db.GetContext(ctx, &updated, query,
+		user.DisplayName, user.Bio, user.Location, user.Website, user.ID,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return twitter.User{}, twitter.ErrUserNotFound
+	}
+	if err != nil {
+		return twitter.User{}, fmt.Errorf("failed to update profile: %w", err)
+	}
+	return updated, nil
+}
+
+///////////////////////////////////////////
+//	Follow part
+///////////////////////////////////////////
+
+func (s *SQLiteDB) FollowUser(ctx context.Context, follow twitter.Follow) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback() // no-op after commit
+	}()
+
+	query := `
+        INSERT INTO follows (follower_id, followed_id)
+        VALUES (?, ?)
+        ON CONFLICT DO NOTHING
+        RETURNING created_at
+    `
+	err = tx.GetContext(ctx, &follow.CreatedAt, query, follow.FollowerID, follow.FolloweeID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil // already followed, nothing to pass on
+	}
+	if err != nil {
+		return fmt.Errorf("failed to follow user: %w", domainError(err))
+	}
+	if err = addOutbox(ctx, tx, database.OutboxFollow, follow); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit follow: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteDB) UnfollowUser(ctx context.Context, follow twitter.Follow) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback() // no-op after commit
+	}()
+
+	query := `
+        DELETE FROM follows
+        WHERE follower_id = ? AND followed_id = ?
+    `
+	result, err := tx.ExecContext(ctx, query, follow.FollowerID, follow.FolloweeID)
+	if err != nil {
+		return fmt.Errorf("failed to unfollow user: %w", err)
+	}
+	if deleted, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to unfollow user: %w", err)
+	} else if deleted == 0 {
+		return nil // not followed, nothing to pass on
+	}
+	if err = addOutbox(ctx, tx, database.OutboxUnfollow, follow); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit unfollow: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteDB) IsFollowing(ctx context.Context, follow twitter.Follow) (bool, error) {
+	var following bool
+	query := `
+        SELECT EXISTS (
+            SELECT 1 FROM follows
+            WHERE follower_id = ? AND followed_id = ?
+        )
+    `
+	if err := s.db.GetContext(ctx, &following, query, follow.FollowerID, follow.FolloweeID); err != nil {
+		return false, fmt.Errorf("failed to check follow: %w", err)
+	}
+	return following, nil
+}
+
+func (s *SQLiteDB) Followers(ctx context.Context, userId int64) ([]twitter.User, error) {
+	var users []twitter.User
+	query := `
+        SELECT id, username, display_name, bio, location, website, follows.created_at AS created_at
+        FROM users
+        JOIN follows ON follows.follower_id = users.id
+        WHERE follows.followed_id = ?
+        ORDER BY follows.created_at, users.id
+    `
+	err := s.db.SelectContext(ctx, &users, query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get followers: %w", err)
+	}
+	return users, nil
+}
+
+func (s *SQLiteDB) Following(ctx context.Context, userId int64) ([]twitter.User, error) {
+	var users []twitter.User
+	query := `
+        SELECT id, username, display_name, bio, location, website, follows.created_at AS created_at
+        FROM users
+        JOIN follows ON follows.followed_id = users.id
+        WHERE follows.follower_id = ?
+        ORDER BY follows.created_at, users.id
+    `
+	err := s.db.SelectContext(ctx, &users, query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get following: %w", err)
+	}
+	return users, nil
+}
+
+///////////////////////////////////////////
+//	Likes part
+///////////////////////////////////////////
+
+func (s *SQLiteDB) LikeTweet(ctx context.Context, like twitter.Like) (bool, error) {
+	query := `
+        INSERT INTO likes (user_id, tweet_id)
+        VALUES (?, ?)
+        ON CONFLICT DO NOTHING
+    `
+	result, err := s.db.ExecContext(ctx, query, like.UserID, like.TweetID)
+	if err != nil {
+		return false, fmt.Errorf("failed to like tweet: %w", domainError(err))
+	}
+	return rowsChanged(result)
+}
+
+func (s *SQLiteDB) UnlikeTweet(ctx context.Context, like twitter.Like) (bool, error) {
+	query := `
+        DELETE FROM likes
+        WHERE user_id = ? AND tweet_id = ?
+    `
+	result, err := s.db.ExecContext(ctx, query, like.UserID, like.TweetID)
+	if err != nil {
+		return false, fmt.Errorf("failed to unlike tweet: %w", err)
+	}
+	return rowsChanged(result)
+}
+
+func (s *SQLiteDB) LikesCount(ctx context.Context, tweetIDs []int64) (map[int64]int64, error) {
+	result := make(map[int64]int64, len(tweetIDs))
+	if len(tweetIDs) == 0 {
+		return result, nil // IN () is a syntax error
+	}
+	var counts []struct {
+		TweetID int64 `db:"tweet_id"`
+		Count   int64 `db:"count"`
+	}
+	// there are no arrays in sqlite, IN gets a placeholder per id
+	query, args, err := sqlx.In(`
+        SELECT tweet_id, COUNT(*) AS count
+        FROM likes
+        WHERE tweet_id IN (?)
+        GROUP BY tweet_id
+    `, tweetIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build likes query: %w", err)
+	}
+	if err = s.db.SelectContext(ctx, &counts, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to count likes: %w", err)
+	}
+	for _, id := range tweetIDs {
+		result[id] = 0
+	}
+	for _, c := range counts {
+		result[c.TweetID] = c.Count
+	}
+	return result, nil
+}
+
+func (s *SQLiteDB) LikedBy(ctx context.Context, tweetID int64, offset, limit int) ([]twitter.User, error) {
+	var users []twitter.User
+	query := `
+        SELECT id, username, display_name, bio, location, website, likes.created_at AS created_at
+        FROM users
+        JOIN likes ON likes.user_id = users.id
+        WHERE likes.tweet_id = ?
+        ORDER BY likes.created_at DESC, users.id
+        LIMIT ? OFFSET ?
+    `
+	err := s.db.SelectContext(ctx, &users, query, tweetID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users who liked tweet: %w", err)
+	}
+	return users, nil
+}
+
+func rowsChanged(result sql.Result) (bool, error) {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return rows > 0, nil
+}
+
+///////////////////////////////////////////
+//	Outbox part
+///////////////////////////////////////////
+
+// the entry is written in the transaction of the change, so both of them are committed or none
+func addOutbox(ctx context.Context, tx *sqlx.Tx, kind database.OutboxKind, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s outbox entry: %w", kind, err)
+	}
+	query := `INSERT INTO outbox (kind, payload) VALUES (?, ?)`
+	if _, err = tx.ExecContext(ctx, query, kind, string(payload)); err != nil {
+		return fmt.Errorf("failed to insert %s outbox entry: %w", kind, err)
+	}
+	return nil
+}
+
+func (s *SQLiteDB) ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]database.OutboxEntry, error) {
+	entries := []database.OutboxEntry{}
+	// the only connection keeps other relays of the file out, there is nothing to skip
+	query := `
+        UPDATE outbox
+        SET claimed_until = strftime('%Y-%m-%d %H:%M:%f', 'now', ?)
+        WHERE id IN (
+            SELECT id
+            FROM outbox
+            WHERE claimed_until IS NULL OR claimed_until < strftime('%Y-%m-%d %H:%M:%f', 'now')
+            ORDER BY id
+            LIMIT ?
+        )
+        RETURNING id, kind, payload, created_at
+    `
+	leaseModifier := fmt.Sprintf("+%.3f seconds", lease.Seconds())
+	err := s.db.SelectContext(ctx, &entries, query, leaseModifier, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim outbox entries: %w", err)
+	}
+	// RETURNING keeps no order
+	slices.SortFunc(entries, func(a, b database.OutboxEntry) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return entries, nil
+}
+
+func (s *SQLiteDB) DeleteOutbox(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil // IN () is a syntax error
+	}
+	query, args, err := sqlx.In(`DELETE FROM outbox WHERE id IN (?)`, ids)
+	if err != nil {
+		return fmt.Errorf("failed to build outbox query: %w", err)
+	}
+	if _, err = s.db.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to delete outbox entries: %w", err)
+	}
+	return nil
+}
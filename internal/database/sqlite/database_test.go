@@ -0,0 +1,57 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"twitter-clone/internal/database/dbtest"
+	"twitter-clone/internal/domain/database"
+	"twitter-clone/internal/domain/twitter"
+
+	"github.com/stretchr/testify/require"
+)
+
+type MockDatabaseConfig struct {
+	path string
+}
+
+func (mc *MockDatabaseConfig) DatabasePath() string     { return mc.path }
+func (mc *MockDatabaseConfig) DatabasePort() string     { return "" }
+func (mc *MockDatabaseConfig) DatabasePassword() string { return "" }
+func (mc *MockDatabaseConfig) DatabaseHost() string     { return "" }
+func (mc *MockDatabaseConfig) DatabaseName() string     { return "" }
+func (mc *MockDatabaseConfig) DatabaseUser() string     { return "" }
+func (mc *MockDatabaseConfig) DatabaseDriver() string   { return "sqlite" }
+
+func TestSQLiteDBBehavior(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) database.DatabaseI {
+		db, err := NewSQLiteDB(context.Background(), &MockDatabaseConfig{
+			path: filepath.Join(t.TempDir(), "twitter.db"),
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = db.Close() // lint
+		})
+		return db
+	})
+}
+
+func TestSQLiteDBReopen(t *testing.T) {
+	ctx := context.Background()
+	config := &MockDatabaseConfig{path: filepath.Join(t.TempDir(), "twitter.db")}
+	db, err := NewSQLiteDB(ctx, config)
+	require.NoError(t, err)
+	created, err := db.CreateUser(ctx, twitter.User{Username: "alice"})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// migrations which are already applied are skipped, the data stays
+	db, err = NewSQLiteDB(ctx, config)
+	require.NoError(t, err)
+	defer func() {
+		_ = db.Close() // lint
+	}()
+	user, err := db.GetUser(ctx, created.ID)
+	require.NoError(t, err)
+	require.Equal(t, "alice", user.Username)
+}
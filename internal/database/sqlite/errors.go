@@ -0,0 +1,34 @@
+package sqlite
+
+import (
+	"errors"
+	"twitter-clone/internal/domain/twitter"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// domain errors of the violated constraints, sqlite doesn't name them in the errors
+// so they are told apart by the message, the ones missing here are internal errors
+var constraintErrors = map[string]error{
+	"UNIQUE constraint failed: tweets.id":                            twitter.ErrTweetExists,
+	"UNIQUE constraint failed: tweets.user_id, tweets.referenced_id": twitter.ErrAlreadyRetweeted,
+	"CHECK constraint failed: follower_id != followed_id":            twitter.ErrSelfFollow,
+	"UNIQUE constraint failed: users.username":                       twitter.ErrUsernameTaken,
+	"UNIQUE constraint failed: index 'idx_users_username_lower'":     twitter.ErrUsernameTaken,
+}
+
+// domainError turns a constraint violation into the domain error, so the caller can tell
+// what went wrong. Any missing row referenced by a foreign key is twitter.ErrReferenceNotFound
+func domainError(err error) error {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return err
+	}
+	if sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+		return twitter.ErrReferenceNotFound
+	}
+	if domainErr, ok := constraintErrors[sqliteErr.Error()]; ok {
+		return domainErr
+	}
+	return err
+}
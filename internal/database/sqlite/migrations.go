@@ -0,0 +1,41 @@
+package sqlite
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"twitter-clone/internal/domain/config"
+
+	"github.com/pressly/goose/v3"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// NewMigrator opens the database file of the config and returns its migrations,
+// closing the migrator closes the database
+func NewMigrator(config config.DatabaseConfig) (*goose.Provider, error) {
+	db, err := sql.Open("sqlite3", connString(config))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	provider, err := newProvider(db)
+	if err != nil {
+		_ = db.Close() // the migrations error matters
+		return nil, err
+	}
+	return provider, nil
+}
+
+func newProvider(db *sql.DB) (*goose.Provider, error) {
+	fsys, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+	provider, err := goose.NewProvider(goose.DialectSQLite3, db, fsys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+	return provider, nil
+}
@@ -0,0 +1,68 @@
+-- +goose Up
+-- +goose StatementBegin
+-- the same schema as the postgres one, timestamps are kept with milliseconds
+-- AUTOINCREMENT keeps the ids growing, the ids of deleted rows are not reused
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username VARCHAR(50) NOT NULL UNIQUE,
+    created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE TABLE tweets (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    in_reply_to INTEGER REFERENCES tweets(id) ON DELETE SET NULL,
+    -- kept when the root is deleted so the replies still make up one conversation
+    root_id INTEGER,
+    kind VARCHAR(16) NOT NULL DEFAULT 'original' CHECK (kind IN ('original', 'retweet', 'quote')),
+    -- quotes keep the content when the quoted tweet is deleted, retweets are deleted before it
+    referenced_id INTEGER REFERENCES tweets(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    edited_at TIMESTAMP,
+    CHECK (
+        (kind = 'original' AND referenced_id IS NULL)
+        OR (kind = 'retweet' AND referenced_id IS NOT NULL)
+        OR kind = 'quote'
+    )
+);
+
+CREATE TABLE follows (
+    follower_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE, -- the one who follows
+    followed_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE, -- the one being followed
+    created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    PRIMARY KEY (follower_id, followed_id),
+    CHECK (follower_id != followed_id)
+);
+
+CREATE TABLE likes (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tweet_id INTEGER NOT NULL REFERENCES tweets(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    PRIMARY KEY (user_id, tweet_id)
+);
+
+CREATE TABLE tweet_revisions (
+    tweet_id INTEGER NOT NULL REFERENCES tweets(id) ON DELETE CASCADE,
+    version INT NOT NULL,
+    content TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL, -- when this version was posted
+    PRIMARY KEY (tweet_id, version)
+);
+
+CREATE INDEX idx_tweets_user_id ON tweets(user_id);
+CREATE INDEX idx_tweets_root_id ON tweets(root_id);
+-- the same tweet can be retweeted by user only once
+CREATE UNIQUE INDEX idx_tweets_unique_retweet ON tweets(user_id, referenced_id) WHERE kind = 'retweet';
+CREATE INDEX idx_follows_followed_id ON follows(followed_id);
+CREATE INDEX idx_likes_tweet_id ON likes(tweet_id, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS tweet_revisions;
+DROP TABLE IF EXISTS likes;
+DROP TABLE IF EXISTS follows;
+DROP TABLE IF EXISTS tweets;
+DROP TABLE IF EXISTS users;
+-- +goose StatementEnd
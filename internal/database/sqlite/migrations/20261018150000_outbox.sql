@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+-- changes which have to reach the cache and the bus, written in the transaction of the change
+-- claimed_until is set by the relay which is passing the entry on, entry is deleted when it's done
+CREATE TABLE outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind VARCHAR(16) NOT NULL,
+    payload TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    claimed_until TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS outbox;
+-- +goose StatementEnd
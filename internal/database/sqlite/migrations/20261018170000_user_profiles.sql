@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN display_name VARCHAR(50) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN bio VARCHAR(160) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN location VARCHAR(30) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN website VARCHAR(100) NOT NULL DEFAULT '';
+
+-- usernames are unique regardless of the case
+CREATE UNIQUE INDEX idx_users_username_lower ON users (LOWER(username));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_users_username_lower;
+ALTER TABLE users DROP COLUMN display_name;
+ALTER TABLE users DROP COLUMN bio;
+ALTER TABLE users DROP COLUMN location;
+ALTER TABLE users DROP COLUMN website;
+-- +goose StatementEnd
@@ -8,6 +8,7 @@ import (
 type API interface {
 	GetUser(ctx context.Context, userID int64) (twitter.User, error)
 	GetFollowers(ctx context.Context, userID int64) ([]twitter.User, error)
+	GetFollowing(ctx context.Context, userID int64) ([]twitter.User, error)
 	GetTimeline(ctx context.Context, userID int64) ([]twitter.Tweet, error)
 	GetTweet(ctx context.Context, tweetID int64) (twitter.Tweet, error)
 }
@@ -0,0 +1,28 @@
+package bus
+
+import (
+	"context"
+)
+
+// Topics of the app, every bus maps them to its own channels or streams
+const (
+	TopicTweets     = "tweets"  // id of every published tweet, processed by the workers
+	TopicFollows    = "follows" // twitter.Follow, the workers backfill the follower's timeline
+	TopicDeliveries = "workers" // twitter.ChannelTweet, the websocket server sends it to the clients
+)
+
+// Message is what a subscriber gets, Ack tells the bus the message is processed.
+// Buses which keep the messages deliver the unacknowledged ones again
+type Message struct {
+	ID         string
+	Payload    []byte
+	Deliveries int // 1 the first time, buses which deliver it again count up
+	Ack        func(ctx context.Context) error
+}
+
+type Bus interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+	// Subscribe delivers the messages of the topic until ctx is done, then the channel is closed.
+	// Every group gets every message, within a group it goes to one of the subscribers
+	Subscribe(ctx context.Context, topic, group string) (<-chan Message, error)
+}
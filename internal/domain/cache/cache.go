@@ -6,26 +6,87 @@ import (
 	"twitter-clone/internal/domain/twitter"
 )
 
+// LikeCounters are the cached like counters and the versions of the missing ones,
+// every DropLikes changes the version of the tweet
+type LikeCounters struct {
+	Likes    map[int64]int64
+	Versions map[int64]int64
+}
+
+// HighFanoutFollowing are the high fan-out users among the cached followings of a user.
+// Cached is false if the followings are not cached, Version is passed to SetFollowing then
+type HighFanoutFollowing struct {
+	Followees []int64
+	Cached    bool
+	Version   int64
+}
+
+// DeadLetter is a tweet which couldn't be delivered to the user's feed.
+// UserID is 0 if the tweet couldn't be processed at all, it's processed again on replay
+type DeadLetter struct {
+	UserID   int64         `json:"user_id"`
+	Tweet    twitter.Tweet `json:"tweet"`
+	Error    string        `json:"error"`
+	FailedAt time.Time     `json:"failed_at"`
+}
+
 type Cache interface {
 	PushTweet(ctx context.Context, tweet twitter.Tweet) error
+	// pushing a tweet that's already in the feed moves it to the top instead of listing it twice
 	PushToUserFeed(ctx context.Context, userID, tweetID int64) error
+	// batched PushToUserFeed, returns the users whose feeds were not updated
+	PushToUserFeeds(ctx context.Context, userIDs []int64, tweetID int64) ([]int64, error)
+	// pushes retweet unless the original is already in the user's feed, returns false if skipped
+	PushRetweetToUserFeed(ctx context.Context, userID, retweetID, originalID int64) (bool, error)
+	// merges tweets into the cached timeline keeping it sorted and trimmed, does nothing if it's not cached
+	MergeToUserFeed(ctx context.Context, userID int64, tweetIDs []int64) error
+	GetUserTweets(ctx context.Context, userID int64) ([]int64, error) // latest tweets of the user, newest first
 
 	GetTweet(ctx context.Context, tweetID int64) (twitter.Tweet, error)
+	// removes tweet and its id from the global list and the followers timelines
+	DeleteTweet(ctx context.Context, tweet twitter.Tweet, followers []int64) error
+	UpdateTweet(ctx context.Context, tweet twitter.Tweet) error // refreshes tweet only if it's cached
 	SetActiveUser(ctx context.Context, userID int64, ttl time.Duration) error
 	GetActiveUsers(ctx context.Context) ([]string, error)
 
 	// Timeline / Feed
-	GetUserTimeline(ctx context.Context, userID int64, limit int) ([]int64, error)
+	GetUserTimeline(ctx context.Context, userID int64, cursor twitter.Cursor) ([]int64, error) // oldest first
 	CheckUserTimelineExists(ctx context.Context, userID int64) (bool, error)
 	StoreTimeline(ctx context.Context, userID int64, timeline []twitter.Tweet) error
-	PushToTweetChannel(ctx context.Context, channelTweet twitter.ChannelTweet) error
 
-	// this part should be moved to pub/sub service but no time rn
-	// subscribe to tweets channel
-	SubscribeToTweetsChannel(ctx context.Context, channel string) (<-chan string, error)
+	// Dead letters
+	// deliveries which failed even after the retries, one per user and tweet
+	PushDeadLetter(ctx context.Context, letter DeadLetter) error
+	GetDeadLetters(ctx context.Context) ([]DeadLetter, error)
+	RemoveDeadLetter(ctx context.Context, letter DeadLetter) error
+
+	// Likes
+	// a like or an unlike drops the counter, missing ones are counted in the database and set on read.
+	// SetLikes skips the counters dropped after GetLikes, so a count read before a like doesn't stay
+	DropLikes(ctx context.Context, tweetID int64) error
+	GetLikes(ctx context.Context, tweetIDs []int64) (LikeCounters, error)
+	SetLikes(ctx context.Context, likes map[int64]int64, versions map[int64]int64) error // versions as returned by GetLikes
+
+	// Hybrid fan-out
+	// tweets of users with too many followers are not pushed to the feeds, they are merged on read
+	SetHighFanout(ctx context.Context, userID int64, high bool) error
+	IsHighFanout(ctx context.Context, userID int64) (bool, error)
+	// adds the latest tweets of high fan-out followees to the timeline page, result is oldest first and fits the cursor limit
+	MergeHighFanoutTweets(ctx context.Context, timeline []int64, followees []int64, cursor twitter.Cursor) ([]int64, error)
+	// the followings of a user are cached to find the high fan-out ones without the database, a follow or an unfollow
+	// drops them. SetFollowing skips the followings dropped after they were read, same as SetLikes
+	GetHighFanoutFollowing(ctx context.Context, userID int64) (HighFanoutFollowing, error)
+	SetFollowing(ctx context.Context, userID int64, followees []int64, version int64) error
+	// returns the users whose cached followings have the followee in one lookup, the ones whose followings
+	// are not cached are returned apart
+	FollowersAmong(ctx context.Context, followeeID int64, userIDs []int64) (followers []int64, uncached []int64, err error)
 
 	// Follower
 	GetFollowers(ctx context.Context, userID int64) ([]int64, error)
+	CountFollowers(ctx context.Context, userID int64) (int, error) // without reading the whole list
 	SetFollowers(ctx context.Context, userID int64, followers []twitter.User) error
-	FollowUser(ctx context.Context, follow twitter.Follow) error
+	FollowUser(ctx context.Context, follow twitter.Follow) error // drops the cached followings of the follower
+	// removes the follower, drops its cached followings and purges the given tweets of the followee
+	// from the follower's timeline
+	UnfollowUser(ctx context.Context, follow twitter.Follow, tweets []twitter.Tweet) error
 }
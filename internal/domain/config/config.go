@@ -5,6 +5,9 @@ type Config interface {
 	DatabaseConfig
 	CacheConfig
 	MetricsConfig
+	TweetConfig
+	WorkerConfig
+	OutboxConfig
 }
 
 type APIConfig interface {
@@ -19,6 +22,7 @@ type DatabaseConfig interface {
 	DatabaseHost() string
 	DatabaseName() string
 	DatabaseUser() string
+	DatabaseDriver() string // memory, sqlite or postgres, postgres if empty
 }
 
 type CacheConfig interface {
@@ -30,6 +34,28 @@ type CacheConfig interface {
 	UserFeedExpireTimeMinutes() int
 	TweetTimelineExpireTimeMinutes() int
 	MaxTweetsTimelineItems() int
+	FanoutFollowersThreshold() int // tweets of users with more followers are merged on read, 0 disables it
+	FanoutBatchSize() int          // feeds updated in one pipeline, 0 puts all of them in one
+}
+
+type TweetConfig interface {
+	TweetEditWindowMinutes() int // how long a tweet can be edited, 0 is the default of an hour
+	TweetNodeID() int            // node part of the generated tweet ids, has to be unique for every api instance, 0-1023
+}
+
+type WorkerConfig interface {
+	WorkerPoolSize() int           // tweets processed at the same time
+	WorkerQueueSize() int          // tweets waiting for the pool, the worker stops reading new ones when it's full
+	WorkerClaimIdleSeconds() int   // tweets not acknowledged for this long are taken over by another worker
+	WorkerRetryAttempts() int      // attempts to deliver a tweet to a follower before it's a dead letter
+	WorkerRetryBackoffMillis() int // delay before the first retry, it's doubled after every attempt
+	WorkerMaxDeliveries() int      // times a tweet is taken from the bus before it's given up as a dead letter
+}
+
+type OutboxConfig interface {
+	OutboxPollIntervalMillis() int // how often the relay checks the outbox when it's empty
+	OutboxBatchSize() int          // entries claimed at once
+	OutboxLeaseSeconds() int       // entries which are claimed but not relayed for this long are claimed again
 }
 
 type WSServerConfig interface {
@@ -2,21 +2,79 @@ package database
 
 import (
 	"context"
+	"time"
 	"twitter-clone/internal/domain/twitter"
 )
 
+// OutboxKind tells what the payload of the outbox entry is
+type OutboxKind string
+
+const (
+	OutboxTweet    OutboxKind = "tweet"    // new tweet of any kind
+	OutboxEdit     OutboxKind = "edit"     // edited tweet
+	OutboxDelete   OutboxKind = "delete"   // deleted tweet with the tweets it changed
+	OutboxFollow   OutboxKind = "follow"   // new follow
+	OutboxUnfollow OutboxKind = "unfollow" // removed follow
+)
+
+// OutboxEntry is a change committed in the same transaction as the data,
+// the relay passes it to the cache and the bus
+type OutboxEntry struct {
+	ID        int64      `db:"id"` // entries are passed in the order of ids
+	Kind      OutboxKind `db:"kind"`
+	Payload   []byte     `db:"payload"` // the tweet, the deleted tweet or the follow as JSON
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+// DeletedTweet is the deleted tweet with the tweets of other users it changed:
+// its retweets are deleted together with it, its quotes keep the content and lose the reference
+type DeletedTweet struct {
+	Tweet    twitter.Tweet   `json:"tweet"`
+	Retweets []twitter.Tweet `json:"retweets"` // deleted
+	Quotes   []twitter.Tweet `json:"quotes"`   // referenced_id is nil now
+}
+
+// DatabaseI returns twitter.Error for the errors the client can act on: missing rows, broken references
+// and violated constraints (twitter.ErrReferenceNotFound, twitter.ErrAlreadyRetweeted, ...), anything else is internal
 type DatabaseI interface {
-	NewTweet(ctx context.Context, tweet twitter.Tweet) (int64, error)
-	GetTweet(ctx context.Context, id int64) (twitter.Tweet, error)
-	GetUsersTweets(ctx context.Context, userID int64) ([]twitter.Tweet, error)
-	GetTimeline(ctx context.Context, userID int64) ([]twitter.Tweet, error)
-	GetUser(ctx context.Context, id int64) (twitter.User, error)
+	NewTweet(ctx context.Context, tweet twitter.Tweet) (twitter.Tweet, error)                         // returns the saved tweet, keeps tweet.ID if it is set, adds OutboxTweet to the outbox
+	GetTweet(ctx context.Context, id int64) (twitter.Tweet, error)                                    // twitter.ErrTweetNotFound if there is no such tweet
+	GetUsersTweets(ctx context.Context, userID int64, cursor twitter.Cursor) ([]twitter.Tweet, error) // newest first, same as GetTimeline
+	GetTimeline(ctx context.Context, userID int64, cursor twitter.Cursor) ([]twitter.Tweet, error)    // newest first
+	GetConversation(ctx context.Context, rootID int64) ([]twitter.Tweet, error)                       // root tweet (unless deleted) and all replies to it ordered by id
+	// returns the deleted tweet and the changed ones, twitter.ErrTweetNotFound or twitter.ErrNotTweetAuthor.
+	// Adds OutboxDelete to the outbox
+	DeleteTweet(ctx context.Context, tweetID, userID int64) (DeletedTweet, error)
+	// keeps the previous content as a revision and returns the updated tweet,
+	// errors are the same as for DeleteTweet. Adds OutboxEdit to the outbox
+	EditTweet(ctx context.Context, tweet twitter.Tweet) (twitter.Tweet, error)
+	GetTweetRevisions(ctx context.Context, tweetID int64) ([]twitter.TweetRevision, error) // previous versions only
+	GetUser(ctx context.Context, id int64) (twitter.User, error)                           // twitter.ErrUserNotFound if there is no such user
+
+	// Likes
+	// Like & unlike return false if there was nothing to change
+	LikeTweet(ctx context.Context, like twitter.Like) (bool, error)
+	UnlikeTweet(ctx context.Context, like twitter.Like) (bool, error)
+	LikesCount(ctx context.Context, tweetIDs []int64) (map[int64]int64, error)
+	// created_at of the users is the time of the like, like the time of the follow for the followers
+	LikedBy(ctx context.Context, tweetID int64, offset, limit int) ([]twitter.User, error)
 
 	// Follow
-	FollowUser(ctx context.Context, follow twitter.Follow) error
+	FollowUser(ctx context.Context, follow twitter.Follow) error   // adds OutboxFollow to the outbox unless it's followed already
+	UnfollowUser(ctx context.Context, follow twitter.Follow) error // adds OutboxUnfollow to the outbox unless it isn't followed
+	IsFollowing(ctx context.Context, follow twitter.Follow) (bool, error)
+	// created_at of the users is the time of the follow, they are ordered by it
 	Followers(ctx context.Context, userId int64) ([]twitter.User, error)
 	Following(ctx context.Context, userId int64) ([]twitter.User, error)
 
 	// User part
-	CreateUser(ctx context.Context, user twitter.User) (int64, error)
+	CreateUser(ctx context.Context, user twitter.User) (twitter.User, error) // returns the saved user, twitter.ErrUsernameTaken if the username is taken in any case
+	// writes the profile fields of the user and returns the updated user, twitter.ErrUserNotFound if there is no such user
+	UpdateProfile(ctx context.Context, user twitter.User) (twitter.User, error)
+
+	// Outbox
+	// ClaimOutbox returns the oldest entries which are not claimed by anyone else,
+	// they stay claimed for the lease and are returned again if they are not deleted by then
+	ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]OutboxEntry, error)
+	DeleteOutbox(ctx context.Context, ids []int64) error
 }
@@ -0,0 +1,7 @@
+package idgen
+
+// Generator hands out ids which are unique across the nodes and grow with time,
+// so they can be generated without the database and still work as page cursors
+type Generator interface {
+	NextID() int64
+}
@@ -0,0 +1,53 @@
+package twitter
+
+import "errors"
+
+// Kinds of the errors the client can do something about, every Error is one of them (errors.Is),
+// the api picks the response status by the kind
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrValidation = errors.New("invalid request")
+	ErrConflict   = errors.New("conflict")
+	ErrForbidden  = errors.New("forbidden")
+)
+
+// Error is an error of the given kind, Code is stable and meant for the clients to match,
+// Message is for humans and can change
+type Error struct {
+	Kind    error
+	Code    string
+	Message string
+}
+
+func NewError(kind error, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// Is matches the errors of the same code, so the one with a detailed message is still e.g. ErrUserNotFound
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+var (
+	ErrTweetNotFound     = NewError(ErrNotFound, "tweet_not_found", "tweet not found")
+	ErrUserNotFound      = NewError(ErrNotFound, "user_not_found", "user not found")
+	ErrReferenceNotFound = NewError(ErrNotFound, "reference_not_found", "referenced user or tweet not found")
+	ErrNotTweetAuthor    = NewError(ErrForbidden, "not_tweet_author", "tweet belongs to another user")
+	ErrEditNotAllowed    = NewError(ErrForbidden, "edit_not_allowed", "tweet can't be edited anymore")
+	ErrAlreadyRetweeted  = NewError(ErrConflict, "already_retweeted", "tweet is already retweeted by the user")
+	ErrTweetExists       = NewError(ErrConflict, "tweet_exists", "tweet with this id already exists")
+	ErrSelfFollow        = NewError(ErrValidation, "self_follow", "user can't follow itself")
+	ErrContentTooLong    = NewError(ErrValidation, "content_too_long", "content too long")
+	ErrUsernameTaken     = NewError(ErrConflict, "username_taken", "username is already taken")
+	ErrInvalidUsername   = NewError(ErrValidation, "invalid_username", "invalid username")
+	ErrInvalidProfile    = NewError(ErrValidation, "invalid_profile", "invalid profile")
+)
@@ -0,0 +1,91 @@
+package twitter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// JSONID is a tweet id in JSON. Snowflake ids are above 2^53, the largest integer a JavaScript number keeps,
+// so they are written as strings. Numbers are still read: the tweets in the cache and in the outbox
+// were written as numbers before and the clients may send them either way
+type JSONID int64
+
+func (id JSONID) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(strconv.FormatInt(int64(id), 10))), nil
+}
+
+func (id *JSONID) UnmarshalJSON(data []byte) error {
+	if s, err := strconv.Unquote(string(data)); err == nil {
+		data = []byte(s)
+	}
+	parsed, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid id %s: %w", data, err)
+	}
+	*id = JSONID(parsed)
+	return nil
+}
+
+// tweetAlias has no methods, so the fields which are not ids are marshaled as they are
+type tweetAlias Tweet
+
+// tweetJSON shadows the ids of the tweet, the rest of the fields comes from the alias
+type tweetJSON struct {
+	ID           JSONID  `json:"id"`
+	InReplyTo    *JSONID `json:"in_reply_to,omitempty"`
+	RootID       *JSONID `json:"root_id,omitempty"`
+	ReferencedID *JSONID `json:"referenced_id,omitempty"`
+	*tweetAlias
+}
+
+func newTweetJSON(t *Tweet) tweetJSON {
+	return tweetJSON{
+		ID:           JSONID(t.ID),
+		InReplyTo:    (*JSONID)(t.InReplyTo),
+		RootID:       (*JSONID)(t.RootID),
+		ReferencedID: (*JSONID)(t.ReferencedID),
+		tweetAlias:   (*tweetAlias)(t),
+	}
+}
+
+// ids are set on the tweet the alias points to, the other fields are already there
+func (w tweetJSON) setIDs() {
+	w.tweetAlias.ID = int64(w.ID)
+	w.tweetAlias.InReplyTo = (*int64)(w.InReplyTo)
+	w.tweetAlias.RootID = (*int64)(w.RootID)
+	w.tweetAlias.ReferencedID = (*int64)(w.ReferencedID)
+}
+
+func (t Tweet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newTweetJSON(&t))
+}
+
+func (t *Tweet) UnmarshalJSON(data []byte) error {
+	w := tweetJSON{tweetAlias: (*tweetAlias)(t)}
+	if err := json.Unmarshal(data, &w); err != nil {
+		return err
+	}
+	w.setIDs()
+	return nil
+}
+
+// the methods of the embedded Tweet would leave the depth out
+type conversationTweetJSON struct {
+	tweetJSON
+	Depth int `json:"depth"`
+}
+
+func (t ConversationTweet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(conversationTweetJSON{tweetJSON: newTweetJSON(&t.Tweet), Depth: t.Depth})
+}
+
+func (t *ConversationTweet) UnmarshalJSON(data []byte) error {
+	w := conversationTweetJSON{tweetJSON: tweetJSON{tweetAlias: (*tweetAlias)(&t.Tweet)}}
+	if err := json.Unmarshal(data, &w); err != nil {
+		return err
+	}
+	w.setIDs()
+	t.Depth = w.Depth
+	return nil
+}
@@ -0,0 +1,75 @@
+package twitter
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTweetJSON(t *testing.T) {
+	id := int64(1<<53 + 1) // a JavaScript number can't keep it
+	parent, root := id-1, id-2
+	tweet := Tweet{
+		ID:           id,
+		UserID:       1,
+		Content:      "hello",
+		InReplyTo:    &parent,
+		RootID:       &root,
+		Kind:         TweetKindQuote,
+		ReferencedID: &root,
+		CreatedAt:    time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC),
+		Likes:        2,
+	}
+
+	data, err := json.Marshal(tweet)
+	require.NoError(t, err)
+	var fields map[string]any
+	require.NoError(t, json.Unmarshal(data, &fields))
+	require.Equal(t, "9007199254740993", fields["id"])
+	require.Equal(t, "9007199254740992", fields["in_reply_to"])
+	require.Equal(t, "9007199254740991", fields["root_id"])
+	require.Equal(t, "9007199254740991", fields["referenced_id"])
+	require.Equal(t, "hello", fields["content"])
+	require.NotContains(t, fields, "edited_at")
+
+	var decoded Tweet
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, tweet, decoded)
+
+	// numbers are read too, the ones written before are still in the cache
+	decoded = Tweet{}
+	require.NoError(t, json.Unmarshal([]byte(`{"id": 42, "in_reply_to": 41, "content": "hello"}`), &decoded))
+	require.Equal(t, int64(42), decoded.ID)
+	require.Equal(t, int64(41), *decoded.InReplyTo)
+	require.Nil(t, decoded.RootID)
+	require.Error(t, json.Unmarshal([]byte(`{"id": "abc"}`), &decoded))
+}
+
+func TestConversationJSON(t *testing.T) {
+	id := int64(1<<53 + 1)
+	conversation := Conversation{
+		RootID: id,
+		Tweets: []ConversationTweet{{Tweet: Tweet{ID: id, Content: "root"}}, {Tweet: Tweet{ID: id + 1, InReplyTo: &id}, Depth: 1}},
+	}
+	data, err := json.Marshal(conversation)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"root_id":"9007199254740993"`)
+	require.Contains(t, string(data), `"depth":1`)
+
+	var decoded Conversation
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, conversation, decoded)
+}
+
+func TestTweetPageJSON(t *testing.T) {
+	page := TweetPage{Tweets: []Tweet{}, NextCursor: 1<<53 + 1}
+	data, err := json.Marshal(page)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"tweets": [], "next_cursor": "9007199254740993"}`, string(data))
+
+	var decoded TweetPage
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, page, decoded)
+}
@@ -3,17 +3,40 @@ package twitter
 import "context"
 
 type TwitterServiceI interface {
-	NewTweet(ctx context.Context, tweetData Tweet) error
-	GetTweet(ctx context.Context, id int64) (Tweet, error)             // returns tweet with given id
-	GetUsersTweets(ctx context.Context, userId int64) ([]Tweet, error) // returns tweets made by user
-	GetTimeline(ctx context.Context, userId int64) ([]Tweet, error)    // returns tweets from users the user is following
+	NewTweet(ctx context.Context, tweetData Tweet) (Tweet, error)                       // returns the saved tweet with its id and creation time
+	GetTweet(ctx context.Context, id int64) (Tweet, error)                              // returns tweet with given id
+	GetUsersTweets(ctx context.Context, userId int64, cursor Cursor) (TweetPage, error) // returns tweets made by user
+	GetTimeline(ctx context.Context, userId int64, cursor Cursor) (TweetPage, error)    // returns tweets from users the user is following
+	DeleteTweet(ctx context.Context, userID, tweetID int64) error                       // only the author can delete the tweet
+
+	// Editing
+	// only the author can edit the tweet and only within the edit window
+	EditTweet(ctx context.Context, tweetData Tweet) (Tweet, error)
+	GetTweetHistory(ctx context.Context, tweetID int64) ([]TweetRevision, error) // oldest version first
+
+	// Retweets & quotes
+	// both return the saved tweet, like NewTweet
+	Retweet(ctx context.Context, userID, tweetID int64) (Tweet, error)
+	Quote(ctx context.Context, tweetData Tweet, quotedID int64) (Tweet, error)
+
+	// Likes
+	LikeTweet(ctx context.Context, like Like) error
+	UnlikeTweet(ctx context.Context, like Like) error
+	LikedBy(ctx context.Context, tweetID int64, offset, limit int) ([]User, error) // most recent likes first, created_at is the time of the like
+
+	// Conversations
+	// returns the reply tree the tweet belongs to, starting from its root
+	GetConversation(ctx context.Context, tweetID int64, offset, limit int) (Conversation, error)
 
 	// Followers
 	FollowUser(ctx context.Context, follow Follow) error
+	UnfollowUser(ctx context.Context, follow Follow) error
+	// created_at of the users is the time of the follow
 	Followers(ctx context.Context, userId int64) ([]User, error)
 	Following(ctx context.Context, userId int64) ([]User, error)
 
 	// User part
-	CreateUser(ctx context.Context, userData User) (int64, error)
+	CreateUser(ctx context.Context, userData User) (User, error) // returns the saved user, ErrInvalidUsername, ErrInvalidProfile or ErrUsernameTaken
 	GetUser(ctx context.Context, id int64) (User, error)
+	UpdateProfile(ctx context.Context, userID int64, update ProfileUpdate) (User, error) // returns the updated user
 }
@@ -3,25 +3,73 @@ package twitter
 import "time"
 
 type User struct {
-	ID        int64     `json:"id" db:"id"`
-	Username  string    `json:"username" db:"username"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ID          int64     `json:"id" db:"id"`
+	Username    string    `json:"username" db:"username"` // unique regardless of the case
+	DisplayName string    `json:"display_name" db:"display_name"`
+	Bio         string    `json:"bio" db:"bio"`
+	Location    string    `json:"location" db:"location"`
+	Website     string    `json:"website" db:"website"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// Limits of the user fields, the username is made of latin letters, digits and underscores
+const (
+	MinUsernameLength    = 3
+	MaxUsernameLength    = 15
+	MaxDisplayNameLength = 50
+	MaxBioLength         = 160
+	MaxLocationLength    = 30
+	MaxWebsiteLength     = 100
+)
+
+// ProfileUpdate changes the profile fields which are set, nil ones are left as they are
+type ProfileUpdate struct {
+	DisplayName *string `json:"display_name,omitempty"`
+	Bio         *string `json:"bio,omitempty"`
+	Location    *string `json:"location,omitempty"`
+	Website     *string `json:"website,omitempty"`
 }
 
-// CREATE TABLE tweets (
-//
-//	id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
-//	user_id BIGINT NOT NULL,
-//	content TEXT NOT NULL,
-//	created_at TIMESTAMP CURRENT_TIMESTAMP,
-//	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-//
-// );
+// the schema of the tables is in the migrations, see internal/database/postgres/migrations.
+// The ids are strings in JSON, see JSONID
 type Tweet struct {
-	ID        int64     `json:"id" db:"id"`
-	UserID    int64     `json:"user_id" db:"user_id"`
-	Content   string    `json:"content" db:"content"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ID           int64      `json:"id" db:"id"`
+	UserID       int64      `json:"user_id" db:"user_id"`
+	Content      string     `json:"content" db:"content"`
+	InReplyTo    *int64     `json:"in_reply_to,omitempty" db:"in_reply_to"` // parent tweet, nil for top level tweets
+	RootID       *int64     `json:"root_id,omitempty" db:"root_id"`         // first tweet of the conversation
+	Kind         TweetKind  `json:"kind,omitempty" db:"kind"`
+	ReferencedID *int64     `json:"referenced_id,omitempty" db:"referenced_id"` // retweeted or quoted tweet
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	EditedAt     *time.Time `json:"edited_at,omitempty" db:"edited_at"` // nil if the tweet was never edited
+
+	Likes int64 `json:"likes" db:"-"` // filled by the service from the counters, not stored with the tweet
+}
+
+// MaxContentLength is the longest content of a tweet, longer one is twitter.ErrContentTooLong
+const MaxContentLength = 280
+
+type TweetKind string
+
+const (
+	TweetKindOriginal TweetKind = "original"
+	TweetKindRetweet  TweetKind = "retweet" // no content, just amplifies the referenced tweet
+	TweetKindQuote    TweetKind = "quote"   // own content on top of the referenced tweet
+)
+
+// ConversationTweet is a tweet placed in the reply tree,
+// the root of the conversation has depth 0, direct replies 1 and so on
+type ConversationTweet struct {
+	Tweet
+	Depth int `json:"depth"`
+}
+
+// Conversation is a page of the reply tree flattened in depth-first order,
+// so every reply follows its parent
+type Conversation struct {
+	RootID     int64               `json:"root_id,string"`
+	Tweets     []ConversationTweet `json:"tweets"`
+	NextOffset int                 `json:"next_offset,omitempty"` // 0 if there is nothing more to read
 }
 
 type Follow struct {
@@ -30,7 +78,52 @@ type Follow struct {
 	CreatedAt  time.Time `json:"created_at" db:"created_at"`
 }
 
+// Cursor selects a page of tweets by tweet id (keyset pagination),
+// tweets in a page always go from the newest to the oldest.
+// Before reads older tweets (scrolling down), After reads the newer ones
+// right after the given tweet (new tweets on top), only one of them is used
+type Cursor struct {
+	Before int64 // only tweets older than this one, 0 starts from the newest
+	After  int64 // only tweets newer than this one
+	Limit  int
+}
+
+// TweetPage is a page of tweets and the cursors to read the pages around it,
+// the cursors are tweet ids, so they are strings in JSON same as the ids
+type TweetPage struct {
+	Tweets     []Tweet `json:"tweets"`
+	NextCursor int64   `json:"next_cursor,omitempty,string"` // "before" for older tweets, 0 if there is nothing more to read
+	PrevCursor int64   `json:"prev_cursor,omitempty,string"` // "after" for newer tweets
+}
+
+// TweetRevision is a version of the tweet content, the first one is the content
+// it was posted with, created_at is the time the version was posted or edited
+type TweetRevision struct {
+	TweetID   int64     `json:"tweet_id,string" db:"tweet_id"`
+	Version   int       `json:"version" db:"version"`
+	Content   string    `json:"content" db:"content"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type Like struct {
+	UserID    int64     `json:"user_id" db:"user_id"`
+	TweetID   int64     `json:"tweet_id,string" db:"tweet_id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
 type ChannelTweet struct {
-	UserID int64 `json:"user_id"`
-	Tweet  Tweet `json:"tweet"`
+	UserID    int64      `json:"user_id"`
+	UserIDs   []int64    `json:"user_ids,omitempty"`  // all the recipients of a fan-out, sent once instead of a message per user
+	Followers bool       `json:"followers,omitempty"` // to the author's followers, a high fan-out user has too many to list
+	Tweet     Tweet      `json:"tweet"`
+	Event     TweetEvent `json:"event,omitempty"` // empty is the same as TweetEventNew
 }
+
+// TweetEvent tells the receiver what happened with the tweet
+type TweetEvent string
+
+const (
+	TweetEventNew     TweetEvent = "new"
+	TweetEventDeleted TweetEvent = "deleted"
+	TweetEventEdited  TweetEvent = "edited"
+)
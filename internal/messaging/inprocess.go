@@ -0,0 +1,111 @@
+package messaging
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"twitter-clone/internal/domain/bus"
+)
+
+// InProcess delivers the messages within the process, so the whole app can run in one process, e.g. in tests.
+// Nothing is kept: a group without subscribers misses the message and Ack does nothing.
+// Publish waits until every group took the message
+type InProcess struct {
+	mu       sync.Mutex
+	topics   map[string]map[string]*inProcessGroup
+	sequence int64
+}
+
+// subscribers of a group get the messages in turns
+type inProcessGroup struct {
+	subscribers []*inProcessSubscriber
+	next        int
+}
+
+type inProcessSubscriber struct {
+	inbox chan bus.Message
+	done  <-chan struct{}
+}
+
+func NewInProcess() *InProcess {
+	return &InProcess{
+		topics: make(map[string]map[string]*inProcessGroup),
+	}
+}
+
+func (b *InProcess) Publish(ctx context.Context, topic string, payload []byte) error {
+	b.mu.Lock()
+	var receivers []*inProcessSubscriber
+	for _, group := range b.topics[topic] {
+		if len(group.subscribers) == 0 {
+			continue
+		}
+		group.next %= len(group.subscribers)
+		receivers = append(receivers, group.subscribers[group.next])
+		group.next++
+	}
+	b.sequence++
+	message := bus.Message{
+		ID:         strconv.FormatInt(b.sequence, 10),
+		Payload:    payload,
+		Deliveries: 1, // nothing is delivered again
+		Ack:        noAck,
+	}
+	b.mu.Unlock()
+
+	for _, receiver := range receivers {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-receiver.done: // unsubscribed in the meantime
+		case receiver.inbox <- message:
+		}
+	}
+	return nil
+}
+
+func (b *InProcess) Subscribe(ctx context.Context, topic, group string) (<-chan bus.Message, error) {
+	subscriber := &inProcessSubscriber{
+		inbox: make(chan bus.Message),
+		done:  ctx.Done(),
+	}
+	b.mu.Lock()
+	if b.topics[topic] == nil {
+		b.topics[topic] = make(map[string]*inProcessGroup)
+	}
+	if b.topics[topic][group] == nil {
+		b.topics[topic][group] = &inProcessGroup{}
+	}
+	b.topics[topic][group].subscribers = append(b.topics[topic][group].subscribers, subscriber)
+	b.mu.Unlock()
+
+	// inbox is never closed, publishers may still hold it, so the messages are passed to a channel which is
+	messages := make(chan bus.Message)
+	go func() {
+		defer close(messages)
+		defer b.unsubscribe(topic, group, subscriber)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message := <-subscriber.inbox:
+				select {
+				case <-ctx.Done():
+					return
+				case messages <- message:
+				}
+			}
+		}
+	}()
+	return messages, nil
+}
+
+func (b *InProcess) unsubscribe(topic, group string, subscriber *inProcessSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g := b.topics[topic][group]
+	g.subscribers = slices.DeleteFunc(g.subscribers, func(s *inProcessSubscriber) bool {
+		return s == subscriber
+	})
+}
@@ -0,0 +1,64 @@
+package messaging
+
+import (
+	"context"
+	"testing"
+	"time"
+	"twitter-clone/internal/domain/bus"
+
+	"github.com/stretchr/testify/require"
+)
+
+func receive(t *testing.T, messages <-chan bus.Message) string {
+	t.Helper()
+	select {
+	case message := <-messages:
+		return string(message.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("no message")
+		return ""
+	}
+}
+
+func TestInProcessGroups(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := NewInProcess()
+
+	first, err := b.Subscribe(ctx, bus.TopicTweets, "workers")
+	require.NoError(t, err)
+	second, err := b.Subscribe(ctx, bus.TopicTweets, "workers")
+	require.NoError(t, err)
+	other, err := b.Subscribe(ctx, bus.TopicTweets, "archive")
+	require.NoError(t, err)
+
+	// every group gets the message, within the group the subscribers take turns
+	published := make(chan error, 2)
+	go func() {
+		published <- b.Publish(ctx, bus.TopicTweets, []byte("1"))
+		published <- b.Publish(ctx, bus.TopicTweets, []byte("2"))
+	}()
+	require.Equal(t, "1", receive(t, other))
+	require.Equal(t, "2", receive(t, other))
+	require.Equal(t, "1", receive(t, first))
+	require.Equal(t, "2", receive(t, second))
+	require.NoError(t, <-published)
+	require.NoError(t, <-published)
+
+	// other topics are not delivered
+	require.NoError(t, b.Publish(ctx, bus.TopicFollows, []byte("3")))
+}
+
+func TestInProcessUnsubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := NewInProcess()
+
+	messages, err := b.Subscribe(ctx, bus.TopicDeliveries, "websocket")
+	require.NoError(t, err)
+	cancel()
+
+	_, ok := <-messages
+	require.False(t, ok)
+	// nobody listens anymore, so it doesn't wait
+	require.NoError(t, b.Publish(context.Background(), bus.TopicDeliveries, []byte("1")))
+}
@@ -0,0 +1,81 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"twitter-clone/internal/domain/bus"
+	"twitter-clone/internal/domain/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisPubSub sends a topic over the "<topic>:channel" channel.
+// Nothing is kept: subscribers which are not there miss the message,
+// there are no groups either, every subscriber gets every message
+type RedisPubSub struct {
+	client *redis.Client
+}
+
+func NewRedisPubSub(config config.CacheConfig) *RedisPubSub {
+	return &RedisPubSub{
+		client: redis.NewClient(&redis.Options{
+			Addr:     config.CacheAddress(),
+			Password: config.CachePassword(),
+			DB:       config.CacheDB(),
+		}),
+	}
+}
+
+func (p *RedisPubSub) Close() error {
+	return p.client.Close()
+}
+
+func channelName(topic string) string {
+	return topic + ":channel"
+}
+
+func (p *RedisPubSub) Publish(ctx context.Context, topic string, payload []byte) error {
+	if err := p.client.Publish(ctx, channelName(topic), payload).Err(); err != nil {
+		return fmt.Errorf("failed to publish to %v: %v", topic, err)
+	}
+	return nil
+}
+
+func (p *RedisPubSub) Subscribe(ctx context.Context, topic, group string) (<-chan bus.Message, error) {
+	pubsub := p.client.Subscribe(ctx, channelName(topic))
+	// the first reply confirms the subscription, so nothing published after it is missed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, fmt.Errorf("failed to subscribe to %v: %v", topic, err)
+	}
+
+	messages := make(chan bus.Message)
+	go func() {
+		defer close(messages)
+		defer func() {
+			_ = pubsub.Close() // lint issue
+		}()
+		received := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-received:
+				if !ok {
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case messages <- bus.Message{Payload: []byte(msg.Payload), Deliveries: 1, Ack: noAck}:
+				}
+			}
+		}
+	}()
+	return messages, nil
+}
+
+// messages which are not kept can't be delivered again
+func noAck(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,170 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"twitter-clone/internal/domain/bus"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+type MockCacheConfig struct {
+	address string
+}
+
+func (mc *MockCacheConfig) CacheAddress() string                { return mc.address }
+func (mc *MockCacheConfig) CachePassword() string               { return "" }
+func (mc *MockCacheConfig) CacheDB() int                        { return 0 }
+func (mc *MockCacheConfig) MaxTweets2Keep() int                 { return 1 }
+func (mc *MockCacheConfig) TweetExpireTimeMinutes() int         { return 1 }
+func (mc *MockCacheConfig) UserFeedExpireTimeMinutes() int      { return 1 }
+func (mc *MockCacheConfig) TweetTimelineExpireTimeMinutes() int { return 1 }
+func (mc *MockCacheConfig) MaxTweetsTimelineItems() int         { return 1 }
+func (mc *MockCacheConfig) FanoutFollowersThreshold() int       { return 1 }
+func (mc *MockCacheConfig) FanoutBatchSize() int                { return 2 }
+
+func TestRedisPubSub(t *testing.T) {
+	server := miniredis.RunT(t)
+	b := NewRedisPubSub(&MockCacheConfig{address: server.Addr()})
+	defer func() {
+		_ = b.Close() // lint
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	messages, err := b.Subscribe(ctx, bus.TopicDeliveries, "websocket")
+	require.NoError(t, err)
+
+	require.NoError(t, b.Publish(ctx, bus.TopicDeliveries, []byte("hello")))
+	require.Equal(t, "hello", receive(t, messages))
+	require.Equal(t, []string{"workers:channel"}, server.PubSubChannels(""))
+
+	cancel()
+	for range messages {
+	}
+}
+
+func TestRedisStream(t *testing.T) {
+	server := miniredis.RunT(t)
+	config := &MockCacheConfig{address: server.Addr()}
+	b := NewRedisStream(config, time.Second)
+	b.readBlock = 100 * time.Millisecond
+	defer func() {
+		_ = b.Close() // lint
+	}()
+	ctx := context.Background()
+
+	// published before anyone subscribed, the stream keeps it
+	require.NoError(t, b.Publish(ctx, bus.TopicTweets, []byte("1")))
+
+	firstCtx, cancelFirst := context.WithCancel(ctx)
+	first, err := b.Subscribe(firstCtx, bus.TopicTweets, "workers")
+	require.NoError(t, err)
+	message := <-first
+	require.Equal(t, "1", string(message.Payload))
+	require.Equal(t, 1, message.Deliveries)
+	// the first consumer dies without acknowledging it
+	cancelFirst()
+	for range first {
+	}
+
+	require.NoError(t, b.Publish(ctx, bus.TopicTweets, []byte("2")))
+
+	secondCtx, cancelSecond := context.WithCancel(ctx)
+	defer cancelSecond()
+	second, err := b.Subscribe(secondCtx, bus.TopicTweets, "workers")
+	require.NoError(t, err)
+	message = <-second
+	require.Equal(t, "2", string(message.Payload))
+	require.NoError(t, message.Ack(ctx))
+
+	// the pending one is claimed again once it's idle for too long
+	server.SetTime(time.Now().Add(time.Hour))
+	select {
+	case message = <-second:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stale message is not claimed")
+	}
+	require.Equal(t, "1", string(message.Payload))
+	require.Equal(t, 2, message.Deliveries)
+	require.NoError(t, message.Ack(ctx))
+
+	pending, err := b.client.XPending(ctx, "tweets:stream", "workers").Result()
+	require.NoError(t, err)
+	require.Zero(t, pending.Count)
+}
+
+func TestConsumerName(t *testing.T) {
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	// two processes on the same host don't share the name
+	first, second := consumerName(), consumerName()
+	require.NotEqual(t, first, second)
+	require.True(t, strings.HasPrefix(first, fmt.Sprintf("%s-%d-", hostname, os.Getpid())))
+}
+
+func TestRedisStreamTrim(t *testing.T) {
+	server := miniredis.RunT(t)
+	b := NewRedisStream(&MockCacheConfig{address: server.Addr()}, time.Second)
+	defer func() {
+		_ = b.Close() // lint
+	}()
+	ctx := context.Background()
+	stream := "tweets:stream"
+
+	var ids []string
+	for _, payload := range []string{"1", "2", "3"} {
+		id, err := b.client.XAdd(ctx, &redis.XAddArgs{Stream: stream, Values: map[string]any{"payload": payload}}).Result()
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	read := func(group string, count int64) {
+		require.NoError(t, b.client.XGroupCreate(ctx, stream, group, "0").Err())
+		require.NoError(t, b.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group: group, Consumer: "consumer", Streams: []string{stream, ">"}, Count: count,
+		}).Err())
+	}
+	length := func() int64 {
+		n, err := b.client.XLen(ctx, stream).Result()
+		require.NoError(t, err)
+		return n
+	}
+
+	// the first one is still pending in one group, the last one is not read by the other
+	read("workers", 3)
+	require.NoError(t, b.client.XAck(ctx, stream, "workers", ids[1], ids[2]).Err())
+	read("others", 2)
+	require.NoError(t, b.client.XAck(ctx, stream, "others", ids[0], ids[1]).Err())
+	require.NoError(t, b.trim(ctx, stream))
+	require.Equal(t, int64(3), length())
+
+	require.NoError(t, b.client.XAck(ctx, stream, "workers", ids[0]).Err())
+	require.NoError(t, b.trim(ctx, stream))
+	require.Equal(t, int64(2), length())
+}
+
+func TestRedisRoutes(t *testing.T) {
+	server := miniredis.RunT(t)
+	b := NewRedis(&MockCacheConfig{address: server.Addr()}, time.Second)
+	b.routes[bus.TopicFollows].(*RedisStream).readBlock = 100 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// follows are kept in a stream as well, a worker which wasn't listening still gets them
+	require.NoError(t, b.Publish(ctx, bus.TopicFollows, []byte(`{"follower_id":1,"followee_id":2}`)))
+
+	follows, err := b.Subscribe(ctx, bus.TopicFollows, "workers")
+	require.NoError(t, err)
+	require.Equal(t, `{"follower_id":1,"followee_id":2}`, receive(t, follows))
+
+	cancel()
+	for range follows {
+	}
+	// the stream shared by the topics is closed once
+	require.NoError(t, b.Close())
+}
@@ -0,0 +1,68 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"slices"
+	"time"
+	"twitter-clone/internal/domain/bus"
+	"twitter-clone/internal/domain/config"
+)
+
+// Router sends every topic over its own bus, the rest of them go over the fallback one
+type Router struct {
+	fallback bus.Bus
+	routes   map[string]bus.Bus
+}
+
+func NewRouter(fallback bus.Bus, routes map[string]bus.Bus) *Router {
+	return &Router{
+		fallback: fallback,
+		routes:   routes,
+	}
+}
+
+// NewRedis is the bus all the binaries share, so they have to route the topics the same way.
+// Whatever the outbox relay publishes (tweets and follows) is kept in a stream until one worker
+// processes it. Deliveries matter only to the websocket servers connected right now
+// and every one of them needs all of them, so they go over pub/sub
+func NewRedis(config config.CacheConfig, claimIdle time.Duration) *Router {
+	stream := NewRedisStream(config, claimIdle)
+	return NewRouter(NewRedisPubSub(config), map[string]bus.Bus{
+		bus.TopicTweets:  stream,
+		bus.TopicFollows: stream,
+	})
+}
+
+func (r *Router) route(topic string) bus.Bus {
+	if b, ok := r.routes[topic]; ok {
+		return b
+	}
+	return r.fallback
+}
+
+func (r *Router) Publish(ctx context.Context, topic string, payload []byte) error {
+	return r.route(topic).Publish(ctx, topic, payload)
+}
+
+func (r *Router) Subscribe(ctx context.Context, topic, group string) (<-chan bus.Message, error) {
+	return r.route(topic).Subscribe(ctx, topic, group)
+}
+
+// Close closes the buses which hold a connection, a bus of several topics is closed once
+func (r *Router) Close() error {
+	var errs []error
+	closed := make(map[bus.Bus]bool)
+	for _, b := range append([]bus.Bus{r.fallback}, slices.Collect(maps.Values(r.routes))...) {
+		if closed[b] {
+			continue
+		}
+		closed[b] = true
+		if closer, ok := b.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
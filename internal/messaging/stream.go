@@ -0,0 +1,263 @@
+package messaging
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"twitter-clone/internal/domain/bus"
+	"twitter-clone/internal/domain/config"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+// how long a read waits for new messages, it's also how long a subscription may be late to stop
+const readBlock = 5 * time.Second
+
+// how many messages are read or claimed at once
+const readCount = 10
+
+// RedisStream keeps a topic in the "<topic>:stream" stream and a group is its consumer group.
+// A message stays pending until it's acknowledged, the ones pending longer than claimIdle,
+// e.g. of a consumer which died, are taken over by the rest of the group.
+// The stream is trimmed only up to the oldest message one of the groups still needs
+type RedisStream struct {
+	client *redis.Client
+
+	consumer  string
+	claimIdle time.Duration
+	readBlock time.Duration
+}
+
+func NewRedisStream(config config.CacheConfig, claimIdle time.Duration) *RedisStream {
+	return &RedisStream{
+		client: redis.NewClient(&redis.Options{
+			Addr:     config.CacheAddress(),
+			Password: config.CachePassword(),
+			DB:       config.CacheDB(),
+		}),
+		consumer:  consumerName(),
+		claimIdle: max(claimIdle, time.Second),
+		readBlock: readBlock,
+	}
+}
+
+// consumerName tells the consumers apart. The hostname alone is shared by the processes on one host
+// and by the containers with a fixed one, so a random suffix keeps them from taking each other's messages.
+// A restarted process gets a new name, the messages left pending by the old one are claimed like the ones of a dead consumer
+func consumerName() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%08x", hostname, os.Getpid(), rand.Uint32())
+}
+
+func (s *RedisStream) Close() error {
+	return s.client.Close()
+}
+
+func streamName(topic string) string {
+	return topic + ":stream"
+}
+
+func (s *RedisStream) Publish(ctx context.Context, topic string, payload []byte) error {
+	err := s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: streamName(topic),
+		Values: map[string]any{"payload": payload},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("failed to publish to %v: %v", topic, err)
+	}
+	return nil
+}
+
+func (s *RedisStream) Subscribe(ctx context.Context, topic, group string) (<-chan bus.Message, error) {
+	stream := streamName(topic)
+	// group starts from the beginning of the stream, so messages published before the first subscriber are not lost
+	err := s.client.XGroupCreateMkStream(ctx, stream, group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, fmt.Errorf("failed to create group %v of %v: %v", group, topic, err)
+	}
+
+	messages := make(chan bus.Message)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.read(ctx, stream, group, messages)
+	}()
+	go func() {
+		defer wg.Done()
+		s.claimStale(ctx, stream, group, messages)
+	}()
+	go func() {
+		wg.Wait()
+		close(messages)
+	}()
+	return messages, nil
+}
+
+func (s *RedisStream) read(ctx context.Context, stream, group string, messages chan<- bus.Message) {
+	for ctx.Err() == nil {
+		streams, err := s.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    group,
+			Consumer: s.consumer,
+			Streams:  []string{stream, ">"},
+			Count:    readCount,
+			Block:    s.readBlock,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue // nothing new
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Error().Err(err).Msgf("Failed to read %v", stream)
+				wait(ctx, time.Second)
+			}
+			continue
+		}
+		for _, result := range streams {
+			if !s.deliver(ctx, stream, group, result.Messages, nil, messages) {
+				return
+			}
+		}
+	}
+}
+
+// messages of a consumer which died or failed to process them are delivered again,
+// the ones every group is done with are trimmed on the way
+func (s *RedisStream) claimStale(ctx context.Context, stream, group string, messages chan<- bus.Message) {
+	for wait(ctx, s.claimIdle/2) {
+		if err := s.trim(ctx, stream); err != nil {
+			log.Error().Err(err).Msgf("Failed to trim %v", stream)
+		}
+		claimed, _, err := s.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    group,
+			Consumer: s.consumer,
+			MinIdle:  s.claimIdle,
+			Start:    "0-0",
+			Count:    readCount,
+		}).Result()
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to claim stale messages of %v", stream)
+			continue
+		}
+		deliveries, err := s.deliveries(ctx, stream, group, claimed)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to count deliveries of %v", stream)
+			continue // they stay pending and are claimed again
+		}
+		if !s.deliver(ctx, stream, group, claimed, deliveries, messages) {
+			return
+		}
+	}
+}
+
+// trim drops the messages every group has acknowledged. A group needs its oldest pending message
+// and the ones it hasn't read yet, so nothing before the oldest of them is needed by anyone
+func (s *RedisStream) trim(ctx context.Context, stream string) error {
+	groups, err := s.client.XInfoGroups(ctx, stream).Result()
+	if err != nil {
+		return fmt.Errorf("failed to get groups of %v: %v", stream, err)
+	}
+	var minID string
+	for _, group := range groups {
+		needed := group.LastDeliveredID
+		if group.Pending > 0 {
+			pending, err := s.client.XPending(ctx, stream, group.Name).Result()
+			if err != nil {
+				return fmt.Errorf("failed to get pending messages of %v: %v", stream, err)
+			}
+			needed = pending.Lower
+		}
+		if minID == "" || compareIDs(needed, minID) < 0 {
+			minID = needed
+		}
+	}
+	if minID == "" {
+		return nil // no group yet, the first one reads it from the beginning
+	}
+	if err = s.client.XTrimMinID(ctx, stream, minID).Err(); err != nil {
+		return fmt.Errorf("failed to trim %v: %v", stream, err)
+	}
+	return nil
+}
+
+// compareIDs compares the "<ms>-<seq>" ids of the stream messages
+func compareIDs(a, b string) int {
+	aMillis, aSeq, _ := strings.Cut(a, "-")
+	bMillis, bSeq, _ := strings.Cut(b, "-")
+	if c := cmp.Compare(parseID(aMillis), parseID(bMillis)); c != 0 {
+		return c
+	}
+	return cmp.Compare(parseID(aSeq), parseID(bSeq))
+}
+
+func parseID(part string) uint64 {
+	n, _ := strconv.ParseUint(part, 10, 64)
+	return n
+}
+
+// deliveries returns how many times the pending messages were delivered, claiming counts as one
+func (s *RedisStream) deliveries(ctx context.Context, stream, group string, entries []redis.XMessage) (map[string]int, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	pending, err := s.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  group,
+		Start:  entries[0].ID,
+		End:    entries[len(entries)-1].ID,
+		Count:  int64(len(entries)),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending messages of %v: %v", stream, err)
+	}
+	result := make(map[string]int, len(pending))
+	for _, p := range pending {
+		result[p.ID] = int(p.RetryCount)
+	}
+	return result, nil
+}
+
+// returns false if the context is done before everything is delivered,
+// messages missing in deliveries are delivered for the first time
+func (s *RedisStream) deliver(ctx context.Context, stream, group string, entries []redis.XMessage, deliveries map[string]int, messages chan<- bus.Message) bool {
+	for _, entry := range entries {
+		id := entry.ID
+		payload, _ := entry.Values["payload"].(string) // stays empty if it's malformed
+		message := bus.Message{
+			ID:         id,
+			Payload:    []byte(payload),
+			Deliveries: max(deliveries[id], 1),
+			Ack: func(ctx context.Context) error {
+				if err := s.client.XAck(ctx, stream, group, id).Err(); err != nil {
+					return fmt.Errorf("failed to ack message %v: %v", id, err)
+				}
+				return nil
+			},
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case messages <- message:
+		}
+	}
+	return true
+}
+
+// returns false if the context is done before the time is up
+func wait(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
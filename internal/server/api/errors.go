@@ -0,0 +1,61 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"twitter-clone/internal/domain/twitter"
+
+	"github.com/rs/zerolog/log"
+)
+
+// errorResponse is the body of every failed request. Code is stable, it's one of the codes
+// of twitter.Error or "internal", Error is the message for humans and can change
+type errorResponse struct {
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+const codeInternal = "internal"
+
+// errors of the request itself, the rest comes from the service
+var (
+	errInvalidBody     = twitter.NewError(twitter.ErrValidation, "invalid_body", "Invalid request body")
+	errUserIDRequired  = twitter.NewError(twitter.ErrValidation, "user_id_required", "user ID is required")
+	errInvalidUserID   = twitter.NewError(twitter.ErrValidation, "invalid_user_id", "invalid user ID")
+	errTweetIDRequired = twitter.NewError(twitter.ErrValidation, "tweet_id_required", "tweet ID is required")
+	errInvalidTweetID  = twitter.NewError(twitter.ErrValidation, "invalid_tweet_id", "invalid tweet ID")
+	errInvalidCursor   = twitter.NewError(twitter.ErrValidation, "invalid_cursor", "invalid cursor")
+	errCursorsTogether = twitter.NewError(twitter.ErrValidation, "invalid_cursor", "before and after can't be used together")
+	errInvalidOffset   = twitter.NewError(twitter.ErrValidation, "invalid_offset", "invalid offset")
+	errInvalidLimit    = twitter.NewError(twitter.ErrValidation, "invalid_limit", "invalid limit")
+)
+
+// statuses of the error kinds, any other error is an internal one
+var kindStatuses = map[error]int{
+	twitter.ErrValidation: http.StatusBadRequest,
+	twitter.ErrForbidden:  http.StatusForbidden,
+	twitter.ErrNotFound:   http.StatusNotFound,
+	twitter.ErrConflict:   http.StatusConflict,
+}
+
+// writeError renders every error of the api, the status, the code and the message come from twitter.Error,
+// the errors it's wrapped in are only the context of the call. Details of the internal errors are only logged,
+// they mean nothing to the client
+func writeError(w http.ResponseWriter, err error) {
+	var domainErr *twitter.Error
+	if errors.As(err, &domainErr) {
+		if status, ok := kindStatuses[domainErr.Kind]; ok {
+			writeJSON(w, status, errorResponse{Code: domainErr.Code, Error: domainErr.Message})
+			return
+		}
+	}
+	log.Error().Err(err).Msg("Request failed")
+	writeJSON(w, http.StatusInternalServerError, errorResponse{Code: codeInternal, Error: "internal error"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnableCORS(t *testing.T) {
+	server := &ServerV1{router: mux.NewRouter()}
+	server.registerRoutes()
+
+	// every method a route is registered with has to pass the preflight of a browser
+	methods := map[string]bool{}
+	err := server.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		routeMethods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		for _, method := range routeMethods {
+			methods[method] = true
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.True(t, methods[http.MethodPatch])
+
+	for method := range methods {
+		t.Run(method, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodOptions, "/api/v1/profile", nil)
+			req.Header.Set("Access-Control-Request-Method", method)
+			w := httptest.NewRecorder()
+
+			enableCORS(server.router).ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+			allowed := strings.Split(w.Header().Get("Access-Control-Allow-Methods"), ", ")
+			assert.Contains(t, allowed, method)
+		})
+	}
+}
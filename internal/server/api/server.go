@@ -10,11 +10,15 @@ import (
 	"time"
 	"twitter-clone/internal/domain/config"
 	"twitter-clone/internal/domain/twitter"
+	"twitter-clone/internal/snowflake"
 
 	"github.com/gorilla/mux"
 )
 
-const TWEET_CONTENT_MAX_LENGTH = 280
+const (
+	DEFAULT_PAGE_LIMIT = 50
+	MAX_PAGE_LIMIT     = 200
+)
 
 type ServerV1 struct {
 	tweeterService twitter.TwitterServiceI
@@ -45,18 +49,31 @@ func (s *ServerV1) registerRoutes() {
 
 	// Tweets
 	router.HandleFunc("/api/v1/tweet", s.newTweet).Methods("POST")
+	router.HandleFunc("/api/v1/tweet", s.editTweet).Methods("PUT")
+	router.HandleFunc("/api/v1/tweet", s.deleteTweet).Methods("DELETE")
+	router.HandleFunc("/api/v1/tweet_history", s.getTweetHistory).Methods("GET")
 	router.HandleFunc("/api/v1/tweets", s.returnTweets).Methods("GET")
 	router.HandleFunc("/api/v1/get_tweet", s.getTweet).Methods("GET")
-	router.HandleFunc("/api/v1/tweet_by_user", s.getTweetByUser).Methods("GET") // not implemented yet
+	router.HandleFunc("/api/v1/tweet_by_user", s.getTweetByUser).Methods("GET")
+	router.HandleFunc("/api/v1/conversation", s.getConversation).Methods("GET")
+	router.HandleFunc("/api/v1/retweet", s.retweet).Methods("POST")
+	router.HandleFunc("/api/v1/quote", s.quote).Methods("POST")
+
+	// Likes
+	router.HandleFunc("/api/v1/like", s.likeTweet).Methods("POST")
+	router.HandleFunc("/api/v1/unlike", s.unlikeTweet).Methods("POST")
+	router.HandleFunc("/api/v1/liked_by", s.getLikedBy).Methods("GET")
 
 	// Follow
 	// Maybe it's better to unite them,
 	// until we are using same code and use params for logic????
 	router.HandleFunc("/api/v1/follow_user", s.followUser).Methods("GET")
+	router.HandleFunc("/api/v1/unfollow_user", s.unfollowUser).Methods("GET")
 	router.HandleFunc("/api/v1/followings", s.getFollowings).Methods("GET")
 	router.HandleFunc("/api/v1/followers", s.getFollowers).Methods("GET")
 	// Add more routes
 	router.HandleFunc("/api/v1/get_user", s.getUser).Methods("GET")
+	router.HandleFunc("/api/v1/profile", s.updateProfile).Methods("PATCH")
 
 	// Add user
 	router.HandleFunc("/api/v1/new_user", s.newUser).Methods("POST")
@@ -77,61 +94,141 @@ func (s *ServerV1) extractAndCheckUser(ctx context.Context, r *http.Request, use
 		err     error
 	)
 	if userStr = r.URL.Query().Get(userField); userStr == "" {
-		return 0, errors.New("user ID is required")
+		return 0, errUserIDRequired
 	}
 	if user, err = strconv.ParseInt(userStr, 10, 64); err != nil {
-		return 0, errors.New("invalid user ID")
+		return 0, errInvalidUserID
+	}
+	if _, err = s.tweeterService.GetUser(ctx, user); errors.Is(err, twitter.ErrNotFound) {
+		return 0, twitter.NewError(twitter.ErrNotFound, twitter.ErrUserNotFound.Code, fmt.Sprintf("user %v does not exist", user))
 	}
-	if _, err := s.tweeterService.GetUser(ctx, user); err != nil {
-		return 0, fmt.Errorf("user %v does not exist", user)
+	if err != nil {
+		return 0, err
 	}
 	return user, nil
 }
 
+func (s *ServerV1) extractTweetID(r *http.Request, tweetField string) (int64, error) {
+	var (
+		tweetStr string
+		tweetID  int64
+		err      error
+	)
+	if tweetStr = r.URL.Query().Get(tweetField); tweetStr == "" {
+		return 0, errTweetIDRequired
+	}
+	if tweetID, err = strconv.ParseInt(tweetStr, 10, 64); err != nil {
+		return 0, errInvalidTweetID
+	}
+	return tweetID, nil
+}
+
+// both params are optional, limit is capped with MAX_PAGE_LIMIT
+func (s *ServerV1) extractPagination(r *http.Request) (int, int, error) {
+	var (
+		offset = 0
+		limit  = DEFAULT_PAGE_LIMIT
+		err    error
+	)
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if offset, err = strconv.Atoi(offsetStr); err != nil || offset < 0 {
+			return 0, 0, errInvalidOffset
+		}
+	}
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit <= 0 {
+			return 0, 0, errInvalidLimit
+		}
+	}
+	return offset, min(limit, MAX_PAGE_LIMIT), nil
+}
+
+// before is the next_cursor of the previous page, after is the prev_cursor of the newest page,
+// until and since (RFC 3339) do the same with a time, as tweet ids grow with the time they are created.
+// All params are optional but only one of them selects the page
+func (s *ServerV1) extractCursor(r *http.Request) (twitter.Cursor, error) {
+	var err error
+	cursor := twitter.Cursor{Limit: DEFAULT_PAGE_LIMIT}
+	if beforeStr := r.URL.Query().Get("before"); beforeStr != "" {
+		if cursor.Before, err = strconv.ParseInt(beforeStr, 10, 64); err != nil || cursor.Before < 0 {
+			return twitter.Cursor{}, errInvalidCursor
+		}
+	}
+	if afterStr := r.URL.Query().Get("after"); afterStr != "" {
+		if cursor.After, err = strconv.ParseInt(afterStr, 10, 64); err != nil || cursor.After < 0 {
+			return twitter.Cursor{}, errInvalidCursor
+		}
+	}
+	if untilStr := r.URL.Query().Get("until"); untilStr != "" {
+		until, err := time.Parse(time.RFC3339, untilStr)
+		if err != nil || cursor.Before != 0 {
+			return twitter.Cursor{}, errInvalidCursor
+		}
+		// 0 would start from the newest, 1 is older than any tweet
+		cursor.Before = max(snowflake.FirstID(until), 1)
+	}
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		since, err := time.Parse(time.RFC3339, sinceStr)
+		if err != nil || cursor.After != 0 {
+			return twitter.Cursor{}, errInvalidCursor
+		}
+		cursor.After = max(snowflake.FirstID(since)-1, 0)
+	}
+	if cursor.Before != 0 && cursor.After != 0 {
+		return twitter.Cursor{}, errCursorsTogether
+	}
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if cursor.Limit, err = strconv.Atoi(limitStr); err != nil || cursor.Limit <= 0 {
+			return twitter.Cursor{}, errInvalidLimit
+		}
+	}
+	cursor.Limit = min(cursor.Limit, MAX_PAGE_LIMIT)
+	return cursor, nil
+}
+
 func (s *ServerV1) newUser(w http.ResponseWriter, r *http.Request) {
 	var err error
-	var userID int64
 	var user twitter.User
 	ctx := r.Context()
 
-	if r.Method != http.MethodPost {
-		result := map[string]string{
-			"error": "Method not allowed",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		_ = json.NewEncoder(w).Encode(result)
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		writeError(w, errInvalidBody)
 		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		result := map[string]string{
-			"error": "Invalid request body",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(result)
+	// taken username (in any case) is a conflict
+	if user, err = s.tweeterService.CreateUser(ctx, user); err != nil {
+		writeError(w, err)
 		return
 	}
+	writeJSON(w, http.StatusCreated, user)
+}
 
-	// TODO check if user exists
+// updateProfile changes only the fields present in the body, an empty string clears the field
+func (s *ServerV1) updateProfile(w http.ResponseWriter, r *http.Request) {
+	var (
+		err    error
+		userID int64
+		update twitter.ProfileUpdate
+		user   twitter.User
+	)
+	ctx := r.Context()
 
-	if userID, err = s.tweeterService.CreateUser(ctx, user); err != nil {
-		result := map[string]string{
-			"error": err.Error(),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		_ = json.NewEncoder(w).Encode(result)
+	if userID, err = s.extractAndCheckUser(ctx, r, "user"); err != nil {
+		writeError(w, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	_ = json.NewEncoder(w).Encode(twitter.User{
-		ID:        userID,
-		Username:  user.Username,
-		CreatedAt: time.Now(),
-	})
+
+	if err = json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeError(w, errInvalidBody)
+		return
+	}
+
+	if user, err = s.tweeterService.UpdateProfile(ctx, userID, update); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
 }
 
 func (s *ServerV1) getUser(w http.ResponseWriter, r *http.Request) {
@@ -140,27 +237,15 @@ func (s *ServerV1) getUser(w http.ResponseWriter, r *http.Request) {
 	var user twitter.User
 	ctx := r.Context()
 	if userID, err = s.extractAndCheckUser(ctx, r, "user"); err != nil {
-		result := map[string]string{
-			"error": err.Error(),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		_ = json.NewEncoder(w).Encode(result)
+		writeError(w, err)
 		return
 	}
 
 	if user, err = s.tweeterService.GetUser(ctx, userID); err != nil {
-		result := map[string]string{
-			"error": err.Error(),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		_ = json.NewEncoder(w).Encode(result)
+		writeError(w, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	_ = json.NewEncoder(w).Encode(user)
+	writeJSON(w, http.StatusOK, user)
 }
 
 /////////////////////////////////////////////////////
@@ -173,127 +258,366 @@ func (s *ServerV1) newTweet(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	if user, err = s.extractAndCheckUser(ctx, r, "user"); err != nil {
-		result := map[string]string{
-			"error": err.Error(),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound) // even if incorrect user return not found
-		_ = json.NewEncoder(w).Encode(result)
-		return
-	}
-
-	if r.Method != http.MethodPost {
-		result := map[string]string{
-			"error": "Method not allowed",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		_ = json.NewEncoder(w).Encode(result)
+		writeError(w, err)
 		return
 	}
 
 	type tweetRequest struct {
-		Content string `json:"content"`
+		Content   string          `json:"content"`
+		InReplyTo *twitter.JSONID `json:"in_reply_to,omitempty"` // string or number
 	}
 	var tweet tweetRequest
 	if err := json.NewDecoder(r.Body).Decode(&tweet); err != nil {
-		result := map[string]string{
-			"error": "Invalid request body",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(result)
+		writeError(w, errInvalidBody)
 		return
 	}
 
-	if len(tweet.Content) > TWEET_CONTENT_MAX_LENGTH {
-		result := map[string]string{
-			"error": "Content too long",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(result)
+	created, err := s.tweeterService.NewTweet(ctx, twitter.Tweet{
+		UserID:    user,
+		Content:   tweet.Content,
+		InReplyTo: (*int64)(tweet.InReplyTo),
+	})
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
-	// have to return the creation time
-	if err := s.tweeterService.NewTweet(ctx, twitter.Tweet{
-		UserID:    user,
-		Content:   tweet.Content,
-		CreatedAt: time.Now().UTC(),
+	// the id and the creation time are set when the tweet is saved, so the tweet is returned as it was saved
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/get_tweet?tweet=%d", created.ID))
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *ServerV1) editTweet(w http.ResponseWriter, r *http.Request) {
+	var (
+		err     error
+		user    int64
+		tweetID int64
+		edited  twitter.Tweet
+	)
+	ctx := r.Context()
+
+	if user, err = s.extractAndCheckUser(ctx, r, "user"); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if tweetID, err = s.extractTweetID(r, "tweet"); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	type editRequest struct {
+		Content string `json:"content"`
+	}
+	var edit editRequest
+	if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+		writeError(w, errInvalidBody)
+		return
+	}
+
+	if edited, err = s.tweeterService.EditTweet(ctx, twitter.Tweet{
+		ID:      tweetID,
+		UserID:  user,
+		Content: edit.Content,
 	}); err != nil {
-		result := map[string]string{
-			"error": "Failed to create tweet",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		_ = json.NewEncoder(w).Encode(result)
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, edited)
+}
+
+func (s *ServerV1) getTweetHistory(w http.ResponseWriter, r *http.Request) {
+	var (
+		err       error
+		tweetID   int64
+		revisions []twitter.TweetRevision
+	)
+	ctx := r.Context()
+
+	if tweetID, err = s.extractTweetID(r, "tweet"); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if revisions, err = s.tweeterService.GetTweetHistory(ctx, tweetID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, revisions)
+}
+
+func (s *ServerV1) deleteTweet(w http.ResponseWriter, r *http.Request) {
+	var (
+		err     error
+		user    int64
+		tweetID int64
+	)
+	ctx := r.Context()
+
+	if user, err = s.extractAndCheckUser(ctx, r, "user"); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if tweetID, err = s.extractTweetID(r, "tweet"); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err = s.tweeterService.DeleteTweet(ctx, user, tweetID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"message": "Tweet deleted successfully",
+	})
+}
+
+func (s *ServerV1) retweet(w http.ResponseWriter, r *http.Request) {
+	var (
+		err     error
+		user    int64
+		tweetID int64
+		created twitter.Tweet
+	)
+	ctx := r.Context()
+
+	if user, err = s.extractAndCheckUser(ctx, r, "user"); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if tweetID, err = s.extractTweetID(r, "tweet"); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if created, err = s.tweeterService.Retweet(ctx, user, tweetID); err != nil {
+		writeError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/get_tweet?tweet=%d", created.ID))
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *ServerV1) quote(w http.ResponseWriter, r *http.Request) {
+	var (
+		err     error
+		user    int64
+		tweetID int64
+		created twitter.Tweet
+	)
+	ctx := r.Context()
+
+	if user, err = s.extractAndCheckUser(ctx, r, "user"); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if tweetID, err = s.extractTweetID(r, "tweet"); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	type quoteRequest struct {
+		Content string `json:"content"`
+	}
+	var quote quoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&quote); err != nil {
+		writeError(w, errInvalidBody)
+		return
+	}
 
-	response := map[string]string{
-		"message": "Tweet created successfully",
+	if created, err = s.tweeterService.Quote(ctx, twitter.Tweet{
+		UserID:  user,
+		Content: quote.Content,
+	}, tweetID); err != nil {
+		writeError(w, err)
+		return
 	}
-	_ = json.NewEncoder(w).Encode(response)
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/get_tweet?tweet=%d", created.ID))
+	writeJSON(w, http.StatusCreated, created)
 }
 
 func (s *ServerV1) returnTweets(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	var err error
 	var user int64
-	var tweets []twitter.Tweet
+	var cursor twitter.Cursor
+	var page twitter.TweetPage
 	if user, err = s.extractAndCheckUser(ctx, r, "user"); err != nil {
-		result := map[string]string{
-			"error": err.Error(),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound) // even if incorrect user return not found
-		_ = json.NewEncoder(w).Encode(result)
+		writeError(w, err)
 		return
 	}
 
-	if tweets, err = s.tweeterService.GetTimeline(ctx, user); err != nil {
-		result := map[string]string{
-			"error": err.Error(),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound) // even if incorrect user return not found
-		_ = json.NewEncoder(w).Encode(result)
+	if cursor, err = s.extractCursor(r); err != nil {
+		writeError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(tweets)
+	if page, err = s.tweeterService.GetTimeline(ctx, user, cursor); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
 }
 
 func (s *ServerV1) getTweetByUser(w http.ResponseWriter, r *http.Request) {
-	panic("implement me")
+	var (
+		err    error
+		user   int64
+		cursor twitter.Cursor
+		page   twitter.TweetPage
+	)
+	ctx := r.Context()
+
+	if user, err = s.extractAndCheckUser(ctx, r, "user"); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if cursor, err = s.extractCursor(r); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if page, err = s.tweeterService.GetUsersTweets(ctx, user, cursor); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
 }
 
 func (s *ServerV1) getTweet(w http.ResponseWriter, r *http.Request) {
+	var (
+		err     error
+		tweetID int64
+		tweet   twitter.Tweet
+	)
 	ctx := r.Context()
-	var tweetStr string
-	var tweetID int64
-	var err error
-	var tweet twitter.Tweet
-	if tweetStr = r.URL.Query().Get("tweet"); tweetStr == "" {
-		return // TODO return error
-	}
-	if tweetID, err = strconv.ParseInt(tweetStr, 10, 64); err != nil {
-		return // TODO return error
+
+	if tweetID, err = s.extractTweetID(r, "tweet"); err != nil {
+		writeError(w, err)
+		return
 	}
 
 	if tweet, err = s.tweeterService.GetTweet(ctx, tweetID); err != nil {
-		return // TODO return error
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tweet)
+}
+
+func (s *ServerV1) getConversation(w http.ResponseWriter, r *http.Request) {
+	var (
+		err           error
+		tweetID       int64
+		offset, limit int
+		conversation  twitter.Conversation
+	)
+	ctx := r.Context()
+
+	if tweetID, err = s.extractTweetID(r, "tweet"); err != nil {
+		writeError(w, err)
+		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(tweet)
 
+	if offset, limit, err = s.extractPagination(r); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if conversation, err = s.tweeterService.GetConversation(ctx, tweetID, offset, limit); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, conversation)
+}
+
+/////////////////////////////////////////////////////
+// 				LIKES PART
+/////////////////////////////////////////////////////
+
+func (s *ServerV1) likeTweet(w http.ResponseWriter, r *http.Request) {
+	s.changeLike(w, r, true)
+}
+
+func (s *ServerV1) unlikeTweet(w http.ResponseWriter, r *http.Request) {
+	s.changeLike(w, r, false)
+}
+
+// like & unlike are the same except the service call
+func (s *ServerV1) changeLike(w http.ResponseWriter, r *http.Request, like bool) {
+	var (
+		err     error
+		user    int64
+		tweetID int64
+	)
+	ctx := r.Context()
+
+	if user, err = s.extractAndCheckUser(ctx, r, "user"); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if tweetID, err = s.extractTweetID(r, "tweet"); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// the time of the like is set by the database, an unlike has none
+	likeData := twitter.Like{
+		UserID:  user,
+		TweetID: tweetID,
+	}
+	message := "Tweet liked successfully"
+	if like {
+		err = s.tweeterService.LikeTweet(ctx, likeData)
+	} else {
+		err = s.tweeterService.UnlikeTweet(ctx, likeData)
+		message = "Tweet unliked successfully"
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"message": message,
+	})
+}
+
+func (s *ServerV1) getLikedBy(w http.ResponseWriter, r *http.Request) {
+	var (
+		err           error
+		tweetID       int64
+		offset, limit int
+		users         []twitter.User
+	)
+	ctx := r.Context()
+
+	if tweetID, err = s.extractTweetID(r, "tweet"); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if offset, limit, err = s.extractPagination(r); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if users, err = s.tweeterService.LikedBy(ctx, tweetID, offset, limit); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, users)
 }
 
 /////////////////////////////////////////////////////
@@ -307,28 +631,15 @@ func (s *ServerV1) getFollowings(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	if user, err = s.extractAndCheckUser(ctx, r, "user"); err != nil {
-		result := map[string]string{
-			"error": err.Error(),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound) // even if incorrect user return not found
-		_ = json.NewEncoder(w).Encode(result)
+		writeError(w, err)
 		return
 	}
 
 	if users, err = s.tweeterService.Following(ctx, user); err != nil {
-		result := map[string]string{
-			"error": err.Error(),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		// for better distinguish between not found and internal error -- better errors
-		w.WriteHeader(http.StatusInternalServerError)
-		_ = json.NewEncoder(w).Encode(result)
+		writeError(w, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(users)
+	writeJSON(w, http.StatusOK, users)
 
 }
 
@@ -339,28 +650,15 @@ func (s *ServerV1) getFollowers(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	if user, err = s.extractAndCheckUser(ctx, r, "user"); err != nil {
-		result := map[string]string{
-			"error": err.Error(),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound) // even if incorrect user return not found
-		_ = json.NewEncoder(w).Encode(result)
+		writeError(w, err)
 		return
 	}
 
 	if users, err = s.tweeterService.Followers(ctx, user); err != nil {
-		result := map[string]string{
-			"error": err.Error(),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		// for better distinguish between not found and internal error -- better errors
-		w.WriteHeader(http.StatusInternalServerError)
-		_ = json.NewEncoder(w).Encode(result)
+		writeError(w, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(users)
+	writeJSON(w, http.StatusOK, users)
 }
 
 func (s *ServerV1) followUser(w http.ResponseWriter, r *http.Request) {
@@ -372,22 +670,12 @@ func (s *ServerV1) followUser(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	if user, err = s.extractAndCheckUser(ctx, r, "user"); err != nil {
-		result := map[string]string{
-			"error": err.Error(),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		_ = json.NewEncoder(w).Encode(result)
+		writeError(w, err)
 		return
 	}
 
 	if followee, err = s.extractAndCheckUser(ctx, r, "followee"); err != nil {
-		result := map[string]string{
-			"error": err.Error(),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		_ = json.NewEncoder(w).Encode(result)
+		writeError(w, err)
 		return
 	}
 
@@ -398,14 +686,39 @@ func (s *ServerV1) followUser(w http.ResponseWriter, r *http.Request) {
 	}
 	// maybe it's better to return the follow struct also
 	if err = s.tweeterService.FollowUser(ctx, followResult); err != nil {
-		result := map[string]string{
-			"error": fmt.Sprintf("Failed to follow user: %v", err),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		_ = json.NewEncoder(w).Encode(result)
+		writeError(w, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(followResult)
+	writeJSON(w, http.StatusOK, followResult)
+}
+
+func (s *ServerV1) unfollowUser(w http.ResponseWriter, r *http.Request) {
+	var (
+		err      error
+		user     int64
+		followee int64
+	)
+	ctx := r.Context()
+
+	if user, err = s.extractAndCheckUser(ctx, r, "user"); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if followee, err = s.extractAndCheckUser(ctx, r, "followee"); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	unfollow := twitter.Follow{
+		FollowerID: user,
+		FolloweeID: followee,
+	}
+	if err = s.tweeterService.UnfollowUser(ctx, unfollow); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"message": "User unfollowed successfully",
+	})
 }
@@ -4,10 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 	"twitter-clone/internal/domain/twitter"
+	"twitter-clone/internal/snowflake"
 
 	app "twitter-clone/internal/app/twitter"
 
@@ -15,27 +19,83 @@ import (
 )
 
 func TestNewTweet(t *testing.T) {
-	var mockNewTweetFuncNil = func(ctx context.Context, tweetData twitter.Tweet) error { return nil }
+	createdAt := time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)
+	// saves the tweet the way the database does, with the id and the creation time
+	var mockNewTweetFuncOK = func(ctx context.Context, tweetData twitter.Tweet) (twitter.Tweet, error) {
+		tweetData.ID = 42
+		tweetData.Kind = twitter.TweetKindOriginal
+		tweetData.CreatedAt = createdAt
+		return tweetData, nil
+	}
+	var mockGetUserFuncOK = func(ctx context.Context, id int64) (twitter.User, error) {
+		return twitter.User{ID: id}, nil
+	}
+	bigID := int64(1<<53 + 1) // a JavaScript number can't keep it
 	tests := []struct {
 		name             string
 		queryParams      string
+		body             string
 		mockNewTweetFunc app.NewTweetFunc
 		mockGetUserFunc  app.GetUserFunc
 		expectedStatus   int
 		expectedBody     map[string]string
-		expectedFollow   twitter.Follow
-		method           string
+		expectedTweet    twitter.Tweet
+		expectedLocation string
 	}{
+		{
+			name:             "Valid input",
+			queryParams:      "user=1",
+			body:             `{"content": "hello"}`,
+			mockNewTweetFunc: mockNewTweetFuncOK,
+			mockGetUserFunc:  mockGetUserFuncOK,
+			expectedStatus:   http.StatusCreated,
+			expectedTweet: twitter.Tweet{
+				ID:        42,
+				UserID:    1,
+				Content:   "hello",
+				Kind:      twitter.TweetKindOriginal,
+				CreatedAt: createdAt,
+			},
+			expectedLocation: "/api/v1/get_tweet?tweet=42",
+		},
+		{
+			name:             "Reply to an id above 2^53",
+			queryParams:      "user=1",
+			body:             `{"content": "hello", "in_reply_to": "9007199254740993"}`,
+			mockNewTweetFunc: mockNewTweetFuncOK,
+			mockGetUserFunc:  mockGetUserFuncOK,
+			expectedStatus:   http.StatusCreated,
+			expectedTweet: twitter.Tweet{
+				ID:        42,
+				UserID:    1,
+				Content:   "hello",
+				InReplyTo: &bigID,
+				Kind:      twitter.TweetKindOriginal,
+				CreatedAt: createdAt,
+			},
+			expectedLocation: "/api/v1/get_tweet?tweet=42",
+		},
 		{
 			name:             "Unknown user",
 			queryParams:      "user=1000&followee=2",
-			mockNewTweetFunc: mockNewTweetFuncNil,
+			body:             `{"content": "hello"}`,
+			mockNewTweetFunc: mockNewTweetFuncOK,
 			mockGetUserFunc: func(ctx context.Context, id int64) (twitter.User, error) {
-				return twitter.User{}, errors.New("user not found")
+				return twitter.User{}, twitter.ErrUserNotFound
 			},
 			expectedStatus: http.StatusNotFound,
-			expectedBody:   map[string]string{"error": "user 1000 does not exist"},
-			method:         "POST",
+			expectedBody:   map[string]string{"code": "user_not_found", "error": "user 1000 does not exist"},
+		},
+		{
+			name:        "Service error",
+			queryParams: "user=1",
+			body:        `{"content": "hello"}`,
+			mockNewTweetFunc: func(ctx context.Context, tweetData twitter.Tweet) (twitter.Tweet, error) {
+				return twitter.Tweet{}, errors.New("database is down")
+			},
+			mockGetUserFunc: mockGetUserFuncOK,
+			expectedStatus:  http.StatusInternalServerError,
+			expectedBody:    map[string]string{"code": "internal", "error": "internal error"},
 		},
 	}
 
@@ -44,7 +104,7 @@ func TestNewTweet(t *testing.T) {
 			mockService := app.NewMockTweeterService(tt.mockNewTweetFunc, nil, tt.mockGetUserFunc)
 			server := &ServerV1{tweeterService: mockService}
 
-			req := httptest.NewRequest(http.MethodGet, "/follow?"+tt.queryParams, nil)
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/tweet?"+tt.queryParams, strings.NewReader(tt.body))
 			w := httptest.NewRecorder()
 
 			server.newTweet(w, req)
@@ -57,14 +117,13 @@ func TestNewTweet(t *testing.T) {
 				assert.NoError(t, err)
 				assert.Equal(t, tt.expectedBody, result)
 			} else {
-				var result twitter.Follow
+				var result twitter.Tweet
 				err := json.NewDecoder(w.Body).Decode(&result)
 				assert.NoError(t, err)
-				assert.Equal(t, tt.expectedFollow.FollowerID, result.FollowerID)
-				assert.Equal(t, tt.expectedFollow.FolloweeID, result.FolloweeID)
-				assert.False(t, result.CreatedAt.IsZero())
+				assert.Equal(t, tt.expectedTweet, result)
 			}
 
+			assert.Equal(t, tt.expectedLocation, w.Header().Get("Location"))
 			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
 		})
 	}
@@ -100,16 +159,16 @@ func TestFollowUser(t *testing.T) {
 			queryParams:     "user=1",
 			mockFollowFunc:  mockFollowFuncNil,
 			mockGetUserFunc: mockGetUserFuncOK,
-			expectedStatus:  http.StatusNotFound,
-			expectedBody:    map[string]string{"error": "user ID is required"},
+			expectedStatus:  http.StatusBadRequest,
+			expectedBody:    map[string]string{"code": "user_id_required", "error": "user ID is required"},
 		},
 		{
 			name:            "Invalid user ID",
 			queryParams:     "user=invalid&followee=2",
 			mockFollowFunc:  mockFollowFuncNil,
 			mockGetUserFunc: mockGetUserFuncOK,
-			expectedStatus:  http.StatusNotFound,
-			expectedBody:    map[string]string{"error": "invalid user ID"},
+			expectedStatus:  http.StatusBadRequest,
+			expectedBody:    map[string]string{"code": "invalid_user_id", "error": "invalid user ID"},
 		},
 		{
 			name:        "Service failure",
@@ -119,17 +178,17 @@ func TestFollowUser(t *testing.T) {
 			},
 			mockGetUserFunc: mockGetUserFuncOK,
 			expectedStatus:  http.StatusInternalServerError,
-			expectedBody:    map[string]string{"error": "Failed to follow user: database error"},
+			expectedBody:    map[string]string{"code": "internal", "error": "internal error"},
 		},
 		{
 			name:           "Unknown user",
 			queryParams:    "user=1000&followee=2",
 			mockFollowFunc: mockFollowFuncNil,
 			mockGetUserFunc: func(ctx context.Context, id int64) (twitter.User, error) {
-				return twitter.User{}, errors.New("user not found")
+				return twitter.User{}, twitter.ErrUserNotFound
 			},
 			expectedStatus: http.StatusNotFound,
-			expectedBody:   map[string]string{"error": "user 1000 does not exist"},
+			expectedBody:   map[string]string{"code": "user_not_found", "error": "user 1000 does not exist"},
 		},
 	}
 
@@ -163,3 +222,1074 @@ func TestFollowUser(t *testing.T) {
 		})
 	}
 }
+
+func TestGetConversation(t *testing.T) {
+	var mockGetConversationOK = func(ctx context.Context, tweetID int64, offset, limit int) (twitter.Conversation, error) {
+		return twitter.Conversation{
+			RootID: tweetID,
+			Tweets: []twitter.ConversationTweet{
+				{Tweet: twitter.Tweet{ID: tweetID}},
+			},
+			NextOffset: offset + limit,
+		}, nil
+	}
+	tests := []struct {
+		name                 string
+		queryParams          string
+		mockGetConversation  app.GetConversationFunc
+		expectedStatus       int
+		expectedBody         map[string]string
+		expectedConversation twitter.Conversation
+	}{
+		{
+			name:                "Valid input",
+			queryParams:         "tweet=10&offset=5&limit=20",
+			mockGetConversation: mockGetConversationOK,
+			expectedStatus:      http.StatusOK,
+			expectedConversation: twitter.Conversation{
+				RootID: 10,
+				Tweets: []twitter.ConversationTweet{
+					{Tweet: twitter.Tweet{ID: 10}},
+				},
+				NextOffset: 25,
+			},
+		},
+		{
+			name:                "Limit is capped",
+			queryParams:         "tweet=10&limit=100000",
+			mockGetConversation: mockGetConversationOK,
+			expectedStatus:      http.StatusOK,
+			expectedConversation: twitter.Conversation{
+				RootID: 10,
+				Tweets: []twitter.ConversationTweet{
+					{Tweet: twitter.Tweet{ID: 10}},
+				},
+				NextOffset: MAX_PAGE_LIMIT,
+			},
+		},
+		{
+			name:                "Missing tweet ID",
+			queryParams:         "",
+			mockGetConversation: mockGetConversationOK,
+			expectedStatus:      http.StatusBadRequest,
+			expectedBody:        map[string]string{"code": "tweet_id_required", "error": "tweet ID is required"},
+		},
+		{
+			name:                "Invalid limit",
+			queryParams:         "tweet=10&limit=-1",
+			mockGetConversation: mockGetConversationOK,
+			expectedStatus:      http.StatusBadRequest,
+			expectedBody:        map[string]string{"code": "invalid_limit", "error": "invalid limit"},
+		},
+		{
+			name:        "Unknown tweet",
+			queryParams: "tweet=10",
+			mockGetConversation: func(ctx context.Context, tweetID int64, offset, limit int) (twitter.Conversation, error) {
+				return twitter.Conversation{}, fmt.Errorf("failed to get tweet: %w", twitter.ErrTweetNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]string{"code": "tweet_not_found", "error": "tweet not found"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := app.NewMockTweeterService(nil, nil, nil, app.WithGetConversation(tt.mockGetConversation))
+			server := &ServerV1{tweeterService: mockService}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/conversation?"+tt.queryParams, nil)
+			w := httptest.NewRecorder()
+
+			server.getConversation(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			if tt.expectedBody != nil {
+				var result map[string]string
+				err := json.NewDecoder(w.Body).Decode(&result)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedBody, result)
+			} else {
+				var result twitter.Conversation
+				err := json.NewDecoder(w.Body).Decode(&result)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedConversation, result)
+			}
+
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestGetTweet(t *testing.T) {
+	var mockGetTweetOK = func(ctx context.Context, id int64) (twitter.Tweet, error) {
+		if id != 10 {
+			return twitter.Tweet{}, fmt.Errorf("failed to get tweet from db: %w", twitter.ErrTweetNotFound)
+		}
+		return twitter.Tweet{ID: 10, UserID: 1, Content: "hello"}, nil
+	}
+	tests := []struct {
+		name           string
+		queryParams    string
+		expectedStatus int
+		expectedBody   map[string]string
+		expectedTweet  twitter.Tweet
+	}{
+		{
+			name:           "Valid input",
+			queryParams:    "tweet=10",
+			expectedStatus: http.StatusOK,
+			expectedTweet:  twitter.Tweet{ID: 10, UserID: 1, Content: "hello"},
+		},
+		{
+			name:           "Missing tweet ID",
+			queryParams:    "",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"code": "tweet_id_required", "error": "tweet ID is required"},
+		},
+		{
+			name:           "Invalid tweet ID",
+			queryParams:    "tweet=abc",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"code": "invalid_tweet_id", "error": "invalid tweet ID"},
+		},
+		{
+			name:           "Unknown tweet",
+			queryParams:    "tweet=11",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]string{"code": "tweet_not_found", "error": "tweet not found"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := app.NewMockTweeterService(nil, nil, nil, app.WithGetTweet(mockGetTweetOK))
+			server := &ServerV1{tweeterService: mockService}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/get_tweet?"+tt.queryParams, nil)
+			w := httptest.NewRecorder()
+
+			server.getTweet(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			if tt.expectedBody != nil {
+				var result map[string]string
+				err := json.NewDecoder(w.Body).Decode(&result)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedBody, result)
+			} else {
+				var result twitter.Tweet
+				err := json.NewDecoder(w.Body).Decode(&result)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedTweet, result)
+			}
+
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		})
+	}
+}
+
+// every kind of the domain errors has its status, the rest hides the details
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expectedBody   map[string]string
+	}{
+		{
+			name:           "Validation",
+			err:            twitter.ErrContentTooLong,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"code": "content_too_long", "error": "content too long"},
+		},
+		{
+			name:           "Forbidden",
+			err:            fmt.Errorf("failed to edit tweet: %w", twitter.ErrNotTweetAuthor),
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   map[string]string{"code": "not_tweet_author", "error": "tweet belongs to another user"},
+		},
+		{
+			name:           "Not found",
+			err:            fmt.Errorf("failed to get user: %w", twitter.ErrUserNotFound),
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]string{"code": "user_not_found", "error": "user not found"},
+		},
+		{
+			name:           "Conflict",
+			err:            twitter.ErrAlreadyRetweeted,
+			expectedStatus: http.StatusConflict,
+			expectedBody:   map[string]string{"code": "already_retweeted", "error": "tweet is already retweeted by the user"},
+		},
+		{
+			name:           "Internal",
+			err:            errors.New("connection refused"),
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   map[string]string{"code": "internal", "error": "internal error"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+
+			writeError(w, tt.err)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			var result map[string]string
+			err := json.NewDecoder(w.Body).Decode(&result)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedBody, result)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestRetweet(t *testing.T) {
+	createdAt := time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)
+	var mockGetUserFuncOK = func(ctx context.Context, id int64) (twitter.User, error) {
+		return twitter.User{}, nil
+	}
+	// saves the retweet the way the database does, with the id and the creation time
+	var mockRetweetOK = func(ctx context.Context, userID, tweetID int64) (twitter.Tweet, error) {
+		return twitter.Tweet{
+			ID:           42,
+			UserID:       userID,
+			Kind:         twitter.TweetKindRetweet,
+			ReferencedID: &tweetID,
+			CreatedAt:    createdAt,
+		}, nil
+	}
+	originalID := int64(2)
+	tests := []struct {
+		name             string
+		queryParams      string
+		mockRetweet      app.RetweetFunc
+		mockGetUserFunc  app.GetUserFunc
+		expectedStatus   int
+		expectedBody     map[string]string
+		expectedTweet    twitter.Tweet
+		expectedLocation string
+	}{
+		{
+			name:            "Valid input",
+			queryParams:     "user=1&tweet=2",
+			mockRetweet:     mockRetweetOK,
+			mockGetUserFunc: mockGetUserFuncOK,
+			expectedStatus:  http.StatusCreated,
+			expectedTweet: twitter.Tweet{
+				ID:           42,
+				UserID:       1,
+				Kind:         twitter.TweetKindRetweet,
+				ReferencedID: &originalID,
+				CreatedAt:    createdAt,
+			},
+			expectedLocation: "/api/v1/get_tweet?tweet=42",
+		},
+		{
+			name:            "Invalid tweet ID",
+			queryParams:     "user=1&tweet=abc",
+			mockRetweet:     mockRetweetOK,
+			mockGetUserFunc: mockGetUserFuncOK,
+			expectedStatus:  http.StatusBadRequest,
+			expectedBody:    map[string]string{"code": "invalid_tweet_id", "error": "invalid tweet ID"},
+		},
+		{
+			name:        "Already retweeted",
+			queryParams: "user=1&tweet=2",
+			mockRetweet: func(ctx context.Context, userID, tweetID int64) (twitter.Tweet, error) {
+				return twitter.Tweet{}, fmt.Errorf("failed to insert tweet: %w", twitter.ErrAlreadyRetweeted)
+			},
+			mockGetUserFunc: mockGetUserFuncOK,
+			expectedStatus:  http.StatusConflict,
+			expectedBody:    map[string]string{"code": "already_retweeted", "error": "tweet is already retweeted by the user"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := app.NewMockTweeterService(nil, nil, tt.mockGetUserFunc, app.WithRetweet(tt.mockRetweet))
+			server := &ServerV1{tweeterService: mockService}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/retweet?"+tt.queryParams, nil)
+			w := httptest.NewRecorder()
+
+			server.retweet(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			if tt.expectedBody != nil {
+				var result map[string]string
+				err := json.NewDecoder(w.Body).Decode(&result)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedBody, result)
+			} else {
+				var result twitter.Tweet
+				err := json.NewDecoder(w.Body).Decode(&result)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedTweet, result)
+			}
+
+			assert.Equal(t, tt.expectedLocation, w.Header().Get("Location"))
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestQuote(t *testing.T) {
+	createdAt := time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)
+	var mockGetUserFuncOK = func(ctx context.Context, id int64) (twitter.User, error) {
+		return twitter.User{}, nil
+	}
+	// the creation time is left to the database
+	var mockQuoteOK = func(ctx context.Context, tweetData twitter.Tweet, quotedID int64) (twitter.Tweet, error) {
+		if !tweetData.CreatedAt.IsZero() {
+			return twitter.Tweet{}, errors.New("creation time is set by the handler")
+		}
+		tweetData.ID = 42
+		tweetData.Kind = twitter.TweetKindQuote
+		tweetData.ReferencedID = &quotedID
+		tweetData.CreatedAt = createdAt
+		return tweetData, nil
+	}
+	quotedID := int64(2)
+	tests := []struct {
+		name             string
+		queryParams      string
+		body             string
+		mockQuote        app.QuoteFunc
+		expectedStatus   int
+		expectedBody     map[string]string
+		expectedTweet    twitter.Tweet
+		expectedLocation string
+	}{
+		{
+			name:           "Valid input",
+			queryParams:    "user=1&tweet=2",
+			body:           `{"content": "so true"}`,
+			mockQuote:      mockQuoteOK,
+			expectedStatus: http.StatusCreated,
+			expectedTweet: twitter.Tweet{
+				ID:           42,
+				UserID:       1,
+				Content:      "so true",
+				Kind:         twitter.TweetKindQuote,
+				ReferencedID: &quotedID,
+				CreatedAt:    createdAt,
+			},
+			expectedLocation: "/api/v1/get_tweet?tweet=42",
+		},
+		{
+			name:           "Invalid body",
+			queryParams:    "user=1&tweet=2",
+			body:           `{"content":`,
+			mockQuote:      mockQuoteOK,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"code": "invalid_body", "error": "Invalid request body"},
+		},
+		{
+			name:        "Deleted tweet",
+			queryParams: "user=1&tweet=2",
+			body:        `{"content": "so true"}`,
+			mockQuote: func(ctx context.Context, tweetData twitter.Tweet, quotedID int64) (twitter.Tweet, error) {
+				return twitter.Tweet{}, fmt.Errorf("failed to get referenced tweet: %w", twitter.ErrTweetNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]string{"code": "tweet_not_found", "error": "tweet not found"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := app.NewMockTweeterService(nil, nil, mockGetUserFuncOK, app.WithQuote(tt.mockQuote))
+			server := &ServerV1{tweeterService: mockService}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/quote?"+tt.queryParams, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			server.quote(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			if tt.expectedBody != nil {
+				var result map[string]string
+				err := json.NewDecoder(w.Body).Decode(&result)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedBody, result)
+			} else {
+				var result twitter.Tweet
+				err := json.NewDecoder(w.Body).Decode(&result)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedTweet, result)
+			}
+
+			assert.Equal(t, tt.expectedLocation, w.Header().Get("Location"))
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestLikeTweet(t *testing.T) {
+	var mockGetUserFuncOK = func(ctx context.Context, id int64) (twitter.User, error) {
+		return twitter.User{}, nil
+	}
+	var mockLikeNil = func(ctx context.Context, like twitter.Like) error { return nil }
+	tests := []struct {
+		name            string
+		path            string
+		queryParams     string
+		mockLike        app.LikeFunc
+		mockGetUserFunc app.GetUserFunc
+		expectedStatus  int
+		expectedBody    map[string]string
+	}{
+		{
+			name:        "Valid input",
+			path:        "like",
+			queryParams: "user=1&tweet=2",
+			mockLike: func(ctx context.Context, like twitter.Like) error {
+				if like != (twitter.Like{UserID: 1, TweetID: 2}) {
+					return fmt.Errorf("unexpected like %+v", like)
+				}
+				return nil
+			},
+			mockGetUserFunc: mockGetUserFuncOK,
+			expectedStatus:  http.StatusOK,
+			expectedBody:    map[string]string{"message": "Tweet liked successfully"},
+		},
+		{
+			name:            "Unlike",
+			path:            "unlike",
+			queryParams:     "user=1&tweet=2",
+			mockLike:        mockLikeNil,
+			mockGetUserFunc: mockGetUserFuncOK,
+			expectedStatus:  http.StatusOK,
+			expectedBody:    map[string]string{"message": "Tweet unliked successfully"},
+		},
+		{
+			name:            "Missing tweet ID",
+			path:            "like",
+			queryParams:     "user=1",
+			mockLike:        mockLikeNil,
+			mockGetUserFunc: mockGetUserFuncOK,
+			expectedStatus:  http.StatusBadRequest,
+			expectedBody:    map[string]string{"code": "tweet_id_required", "error": "tweet ID is required"},
+		},
+		{
+			name:        "Service failure",
+			path:        "like",
+			queryParams: "user=1&tweet=2",
+			mockLike: func(ctx context.Context, like twitter.Like) error {
+				return errors.New("database error")
+			},
+			mockGetUserFunc: mockGetUserFuncOK,
+			expectedStatus:  http.StatusInternalServerError,
+			expectedBody:    map[string]string{"code": "internal", "error": "internal error"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := app.NewMockTweeterService(nil, nil, tt.mockGetUserFunc, app.WithLikeTweet(tt.mockLike), app.WithUnlikeTweet(tt.mockLike))
+			server := &ServerV1{tweeterService: mockService}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/"+tt.path+"?"+tt.queryParams, nil)
+			w := httptest.NewRecorder()
+
+			if tt.path == "unlike" {
+				server.unlikeTweet(w, req)
+			} else {
+				server.likeTweet(w, req)
+			}
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			var result map[string]string
+			err := json.NewDecoder(w.Body).Decode(&result)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedBody, result)
+
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestDeleteTweet(t *testing.T) {
+	var mockGetUserFuncOK = func(ctx context.Context, id int64) (twitter.User, error) {
+		return twitter.User{}, nil
+	}
+	tests := []struct {
+		name            string
+		queryParams     string
+		mockDeleteTweet app.DeleteTweetFunc
+		expectedStatus  int
+		expectedBody    map[string]string
+	}{
+		{
+			name:        "Valid input",
+			queryParams: "user=1&tweet=2",
+			mockDeleteTweet: func(ctx context.Context, userID, tweetID int64) error {
+				return nil
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]string{"message": "Tweet deleted successfully"},
+		},
+		{
+			name:        "Unknown tweet",
+			queryParams: "user=1&tweet=2",
+			mockDeleteTweet: func(ctx context.Context, userID, tweetID int64) error {
+				return fmt.Errorf("failed to delete tweet: %w", twitter.ErrTweetNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]string{"code": "tweet_not_found", "error": "tweet not found"},
+		},
+		{
+			name:        "Someone else's tweet",
+			queryParams: "user=1&tweet=2",
+			mockDeleteTweet: func(ctx context.Context, userID, tweetID int64) error {
+				return fmt.Errorf("failed to delete tweet: %w", twitter.ErrNotTweetAuthor)
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   map[string]string{"code": "not_tweet_author", "error": "tweet belongs to another user"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := app.NewMockTweeterService(nil, nil, mockGetUserFuncOK, app.WithDeleteTweet(tt.mockDeleteTweet))
+			server := &ServerV1{tweeterService: mockService}
+
+			req := httptest.NewRequest(http.MethodDelete, "/api/v1/tweet?"+tt.queryParams, nil)
+			w := httptest.NewRecorder()
+
+			server.deleteTweet(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			var result map[string]string
+			err := json.NewDecoder(w.Body).Decode(&result)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedBody, result)
+
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestEditTweet(t *testing.T) {
+	var mockGetUserFuncOK = func(ctx context.Context, id int64) (twitter.User, error) {
+		return twitter.User{}, nil
+	}
+	tests := []struct {
+		name           string
+		queryParams    string
+		body           string
+		mockEditTweet  app.EditTweetFunc
+		expectedStatus int
+		expectedBody   map[string]string
+		expectedTweet  twitter.Tweet
+	}{
+		{
+			name:        "Valid input",
+			queryParams: "user=1&tweet=2",
+			body:        `{"content": "fixed typo"}`,
+			mockEditTweet: func(ctx context.Context, tweetData twitter.Tweet) (twitter.Tweet, error) {
+				return tweetData, nil
+			},
+			expectedStatus: http.StatusOK,
+			expectedTweet: twitter.Tweet{
+				ID:      2,
+				UserID:  1,
+				Content: "fixed typo",
+			},
+		},
+		{
+			name:        "Edit window is over",
+			queryParams: "user=1&tweet=2",
+			body:        `{"content": "fixed typo"}`,
+			mockEditTweet: func(ctx context.Context, tweetData twitter.Tweet) (twitter.Tweet, error) {
+				return twitter.Tweet{}, twitter.ErrEditNotAllowed
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   map[string]string{"code": "edit_not_allowed", "error": "tweet can't be edited anymore"},
+		},
+		{
+			name:        "Invalid body",
+			queryParams: "user=1&tweet=2",
+			body:        `not json`,
+			mockEditTweet: func(ctx context.Context, tweetData twitter.Tweet) (twitter.Tweet, error) {
+				return tweetData, nil
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"code": "invalid_body", "error": "Invalid request body"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := app.NewMockTweeterService(nil, nil, mockGetUserFuncOK, app.WithEditTweet(tt.mockEditTweet))
+			server := &ServerV1{tweeterService: mockService}
+
+			req := httptest.NewRequest(http.MethodPut, "/api/v1/tweet?"+tt.queryParams, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			server.editTweet(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			if tt.expectedBody != nil {
+				var result map[string]string
+				err := json.NewDecoder(w.Body).Decode(&result)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedBody, result)
+			} else {
+				var result twitter.Tweet
+				err := json.NewDecoder(w.Body).Decode(&result)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedTweet, result)
+			}
+
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestGetTweetByUser(t *testing.T) {
+	var mockGetUserFuncOK = func(ctx context.Context, id int64) (twitter.User, error) {
+		return twitter.User{}, nil
+	}
+	// echoes the cursor back so it can be checked
+	var mockGetUsersTweetsOK = func(ctx context.Context, userId int64, cursor twitter.Cursor) (twitter.TweetPage, error) {
+		return twitter.TweetPage{
+			Tweets:     []twitter.Tweet{{ID: cursor.Before - 1, UserID: userId}},
+			NextCursor: int64(cursor.Limit),
+		}, nil
+	}
+	tests := []struct {
+		name           string
+		queryParams    string
+		expectedStatus int
+		expectedBody   map[string]string
+		expectedPage   twitter.TweetPage
+	}{
+		{
+			name:           "Valid input",
+			queryParams:    "user=1&before=10&limit=5",
+			expectedStatus: http.StatusOK,
+			expectedPage: twitter.TweetPage{
+				Tweets:     []twitter.Tweet{{ID: 9, UserID: 1}},
+				NextCursor: 5,
+			},
+		},
+		{
+			name:           "Default limit",
+			queryParams:    "user=1&before=10",
+			expectedStatus: http.StatusOK,
+			expectedPage: twitter.TweetPage{
+				Tweets:     []twitter.Tweet{{ID: 9, UserID: 1}},
+				NextCursor: DEFAULT_PAGE_LIMIT,
+			},
+		},
+		{
+			name:           "Invalid cursor",
+			queryParams:    "user=1&before=abc",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"code": "invalid_cursor", "error": "invalid cursor"},
+		},
+		{
+			name:           "Missing user",
+			queryParams:    "before=10",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"code": "user_id_required", "error": "user ID is required"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := app.NewMockTweeterService(nil, nil, mockGetUserFuncOK, app.WithGetUsersTweets(mockGetUsersTweetsOK))
+			server := &ServerV1{tweeterService: mockService}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/tweet_by_user?"+tt.queryParams, nil)
+			w := httptest.NewRecorder()
+
+			server.getTweetByUser(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			if tt.expectedBody != nil {
+				var result map[string]string
+				err := json.NewDecoder(w.Body).Decode(&result)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedBody, result)
+			} else {
+				var result twitter.TweetPage
+				err := json.NewDecoder(w.Body).Decode(&result)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedPage, result)
+			}
+
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestReturnTweets(t *testing.T) {
+	var mockGetUserFuncOK = func(ctx context.Context, id int64) (twitter.User, error) {
+		return twitter.User{}, nil
+	}
+	// echoes the cursor back so it can be checked
+	var mockGetTimelineOK = func(ctx context.Context, userId int64, cursor twitter.Cursor) (twitter.TweetPage, error) {
+		return twitter.TweetPage{
+			Tweets:     []twitter.Tweet{{ID: cursor.After + 1, UserID: userId}},
+			NextCursor: int64(cursor.Limit),
+			PrevCursor: cursor.Before,
+		}, nil
+	}
+	tests := []struct {
+		name           string
+		queryParams    string
+		expectedStatus int
+		expectedBody   map[string]string
+		expectedPage   twitter.TweetPage
+	}{
+		{
+			name:           "Before cursor",
+			queryParams:    "user=1&before=10&limit=5",
+			expectedStatus: http.StatusOK,
+			expectedPage: twitter.TweetPage{
+				Tweets:     []twitter.Tweet{{ID: 1, UserID: 1}},
+				NextCursor: 5,
+				PrevCursor: 10,
+			},
+		},
+		{
+			name:           "After cursor",
+			queryParams:    "user=1&after=10",
+			expectedStatus: http.StatusOK,
+			expectedPage: twitter.TweetPage{
+				Tweets:     []twitter.Tweet{{ID: 11, UserID: 1}},
+				NextCursor: DEFAULT_PAGE_LIMIT,
+			},
+		},
+		{
+			name:           "Limit is capped",
+			queryParams:    "user=1&limit=100000",
+			expectedStatus: http.StatusOK,
+			expectedPage: twitter.TweetPage{
+				Tweets:     []twitter.Tweet{{ID: 1, UserID: 1}},
+				NextCursor: MAX_PAGE_LIMIT,
+			},
+		},
+		{
+			name:           "Until time",
+			queryParams:    "user=1&until=2026-10-18T12:00:00Z",
+			expectedStatus: http.StatusOK,
+			expectedPage: twitter.TweetPage{
+				Tweets:     []twitter.Tweet{{ID: 1, UserID: 1}},
+				NextCursor: DEFAULT_PAGE_LIMIT,
+				PrevCursor: snowflake.FirstID(time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)),
+			},
+		},
+		{
+			name:           "Since time",
+			queryParams:    "user=1&since=2026-10-18T12:00:00Z",
+			expectedStatus: http.StatusOK,
+			expectedPage: twitter.TweetPage{
+				Tweets:     []twitter.Tweet{{ID: snowflake.FirstID(time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)), UserID: 1}},
+				NextCursor: DEFAULT_PAGE_LIMIT,
+			},
+		},
+		{
+			name:           "Invalid time",
+			queryParams:    "user=1&until=yesterday",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"code": "invalid_cursor", "error": "invalid cursor"},
+		},
+		{
+			name:           "Before and until",
+			queryParams:    "user=1&before=10&until=2026-10-18T12:00:00Z",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"code": "invalid_cursor", "error": "invalid cursor"},
+		},
+		{
+			name:           "Both cursors",
+			queryParams:    "user=1&before=10&after=5",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"code": "invalid_cursor", "error": "before and after can't be used together"},
+		},
+		{
+			name:           "Invalid cursor",
+			queryParams:    "user=1&after=-1",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"code": "invalid_cursor", "error": "invalid cursor"},
+		},
+		{
+			name:           "Invalid limit",
+			queryParams:    "user=1&limit=0",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"code": "invalid_limit", "error": "invalid limit"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := app.NewMockTweeterService(nil, nil, mockGetUserFuncOK, app.WithGetTimeline(mockGetTimelineOK))
+			server := &ServerV1{tweeterService: mockService}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/tweets?"+tt.queryParams, nil)
+			w := httptest.NewRecorder()
+
+			server.returnTweets(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			if tt.expectedBody != nil {
+				var result map[string]string
+				err := json.NewDecoder(w.Body).Decode(&result)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedBody, result)
+			} else {
+				var result twitter.TweetPage
+				err := json.NewDecoder(w.Body).Decode(&result)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedPage, result)
+			}
+
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestUnfollowUser(t *testing.T) {
+	var mockGetUserFuncOK = func(ctx context.Context, id int64) (twitter.User, error) {
+		return twitter.User{}, nil
+	}
+	tests := []struct {
+		name             string
+		queryParams      string
+		mockUnfollowFunc app.FollowFunc
+		expectedStatus   int
+		expectedBody     map[string]string
+	}{
+		{
+			name:        "Valid input",
+			queryParams: "user=1&followee=2",
+			mockUnfollowFunc: func(ctx context.Context, follow twitter.Follow) error {
+				if follow.FollowerID != 1 || follow.FolloweeID != 2 {
+					return errors.New("wrong follow")
+				}
+				return nil
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]string{"message": "User unfollowed successfully"},
+		},
+		{
+			name:           "Missing followee ID",
+			queryParams:    "user=1",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"code": "user_id_required", "error": "user ID is required"},
+		},
+		{
+			name:        "Service failure",
+			queryParams: "user=1&followee=2",
+			mockUnfollowFunc: func(ctx context.Context, follow twitter.Follow) error {
+				return errors.New("database error")
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   map[string]string{"code": "internal", "error": "internal error"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := app.NewMockTweeterService(nil, nil, mockGetUserFuncOK, app.WithUnfollowUser(tt.mockUnfollowFunc))
+			server := &ServerV1{tweeterService: mockService}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/unfollow_user?"+tt.queryParams, nil)
+			w := httptest.NewRecorder()
+
+			server.unfollowUser(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			var result map[string]string
+			err := json.NewDecoder(w.Body).Decode(&result)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedBody, result)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestNewUser(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		mockCreateUser app.CreateUserFunc
+		expectedStatus int
+		expectedBody   map[string]string
+		expectedUser   twitter.User
+	}{
+		{
+			name: "Valid input",
+			body: `{"username": "alice", "display_name": "Alice"}`,
+			mockCreateUser: func(ctx context.Context, user twitter.User) (twitter.User, error) {
+				user.ID = 7
+				user.CreatedAt = time.Date(2025, 7, 29, 16, 31, 38, 0, time.UTC)
+				return user, nil
+			},
+			expectedStatus: http.StatusCreated,
+			expectedUser: twitter.User{
+				ID:          7,
+				Username:    "alice",
+				DisplayName: "Alice",
+				CreatedAt:   time.Date(2025, 7, 29, 16, 31, 38, 0, time.UTC),
+			},
+		},
+		{
+			name: "Username is taken",
+			body: `{"username": "Alice"}`,
+			mockCreateUser: func(ctx context.Context, user twitter.User) (twitter.User, error) {
+				return twitter.User{}, fmt.Errorf("failed to create user: %w", twitter.ErrUsernameTaken)
+			},
+			expectedStatus: http.StatusConflict,
+			expectedBody:   map[string]string{"code": "username_taken", "error": "username is already taken"},
+		},
+		{
+			name: "Invalid username",
+			body: `{"username": "al ice"}`,
+			mockCreateUser: func(ctx context.Context, user twitter.User) (twitter.User, error) {
+				return twitter.User{}, twitter.NewError(twitter.ErrValidation, twitter.ErrInvalidUsername.Code, "username has to be 3 to 15 latin letters, digits or underscores")
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: map[string]string{
+				"code":  "invalid_username",
+				"error": "username has to be 3 to 15 latin letters, digits or underscores",
+			},
+		},
+		{
+			name:           "Invalid body",
+			body:           `not json`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"code": "invalid_body", "error": "Invalid request body"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := app.NewMockTweeterService(nil, nil, nil, app.WithCreateUser(tt.mockCreateUser))
+			server := &ServerV1{tweeterService: mockService}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/new_user", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			server.newUser(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			if tt.expectedBody != nil {
+				var result map[string]string
+				err := json.NewDecoder(w.Body).Decode(&result)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedBody, result)
+			} else {
+				var result twitter.User
+				err := json.NewDecoder(w.Body).Decode(&result)
+				assert.NoError(t, err)
+				// created_at is the one the database stored
+				assert.True(t, tt.expectedUser.CreatedAt.Equal(result.CreatedAt))
+				result.CreatedAt = tt.expectedUser.CreatedAt
+				assert.Equal(t, tt.expectedUser, result)
+			}
+
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestUpdateProfile(t *testing.T) {
+	var mockGetUserFunc = func(ctx context.Context, id int64) (twitter.User, error) {
+		if id == 1 {
+			return twitter.User{ID: 1, Username: "alice"}, nil
+		}
+		return twitter.User{}, twitter.ErrUserNotFound
+	}
+	tests := []struct {
+		name              string
+		queryParams       string
+		body              string
+		mockUpdateProfile app.UpdateProfileFunc
+		expectedStatus    int
+		expectedBody      map[string]string
+		expectedUser      twitter.User
+	}{
+		{
+			name:        "Valid input",
+			queryParams: "user=1",
+			body:        `{"bio": "hello", "website": ""}`,
+			mockUpdateProfile: func(ctx context.Context, userID int64, update twitter.ProfileUpdate) (twitter.User, error) {
+				// only the fields of the body are in the update
+				if update.DisplayName != nil || update.Location != nil || update.Bio == nil || update.Website == nil {
+					return twitter.User{}, errors.New("unexpected update")
+				}
+				return twitter.User{ID: userID, Username: "alice", Bio: *update.Bio, Website: *update.Website}, nil
+			},
+			expectedStatus: http.StatusOK,
+			expectedUser:   twitter.User{ID: 1, Username: "alice", Bio: "hello"},
+		},
+		{
+			name:        "Invalid profile",
+			queryParams: "user=1",
+			body:        `{"website": "ftp://alice.example"}`,
+			mockUpdateProfile: func(ctx context.Context, userID int64, update twitter.ProfileUpdate) (twitter.User, error) {
+				return twitter.User{}, fmt.Errorf("failed to update profile: %w",
+					twitter.NewError(twitter.ErrValidation, twitter.ErrInvalidProfile.Code, "website has to be an http or https url"))
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"code": "invalid_profile", "error": "website has to be an http or https url"},
+		},
+		{
+			name:           "Unknown user",
+			queryParams:    "user=2",
+			body:           `{"bio": "hello"}`,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]string{"code": "user_not_found", "error": "user 2 does not exist"},
+		},
+		{
+			name:           "Invalid body",
+			queryParams:    "user=1",
+			body:           `not json`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]string{"code": "invalid_body", "error": "Invalid request body"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := app.NewMockTweeterService(nil, nil, mockGetUserFunc, app.WithUpdateProfile(tt.mockUpdateProfile))
+			server := &ServerV1{tweeterService: mockService}
+
+			req := httptest.NewRequest(http.MethodPatch, "/api/v1/profile?"+tt.queryParams, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			server.updateProfile(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			if tt.expectedBody != nil {
+				var result map[string]string
+				err := json.NewDecoder(w.Body).Decode(&result)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedBody, result)
+			} else {
+				var result twitter.User
+				err := json.NewDecoder(w.Body).Decode(&result)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedUser, result)
+			}
+
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package relay
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// served by the metrics server from the default registry
+var (
+	relayedEntries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "outbox",
+		Name:      "relayed_total",
+		Help:      "Outbox entries passed to the cache and the bus",
+	}, []string{"kind"})
+	failedEntries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "outbox",
+		Name:      "failed_total",
+		Help:      "Outbox entries which failed to be relayed, they are retried when their lease runs out",
+	}, []string{"kind"})
+)
@@ -0,0 +1,313 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+	"twitter-clone/internal/domain/bus"
+	"twitter-clone/internal/domain/cache"
+	"twitter-clone/internal/domain/config"
+	"twitter-clone/internal/domain/database"
+	"twitter-clone/internal/domain/twitter"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Relay passes the outbox entries, committed together with the tweets and the follows,
+// to the cache and the bus. An entry is deleted only when it's passed on, after a failure
+// or a crash it's claimed again once its lease runs out, so it's delivered at least once.
+// A retried entry can come after the newer ones, so the changes which are gone from
+// the database by then are skipped, they are not brought back to the cache
+type Relay struct {
+	db    database.DatabaseI
+	cache cache.Cache
+	bus   bus.Bus
+
+	interval  time.Duration
+	batchSize int
+	lease     time.Duration
+}
+
+func NewRelay(db database.DatabaseI, cache cache.Cache, bus bus.Bus, config config.OutboxConfig) *Relay {
+	return &Relay{
+		db:        db,
+		cache:     cache,
+		bus:       bus,
+		interval:  max(time.Duration(config.OutboxPollIntervalMillis())*time.Millisecond, 10*time.Millisecond),
+		batchSize: max(config.OutboxBatchSize(), 1),
+		lease:     max(time.Duration(config.OutboxLeaseSeconds())*time.Second, time.Second),
+	}
+}
+
+func (r *Relay) Start(ctx context.Context) error {
+	for {
+		relayed, err := r.RelayBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Error().Err(err).Msg("Failed to relay outbox")
+		}
+		// a full batch means there are more entries waiting
+		if err == nil && relayed == r.batchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.interval):
+		}
+	}
+}
+
+// RelayBatch claims a batch of entries and passes them on in order. It stops at the first
+// failure, the rest of the batch stays claimed and is retried when the lease runs out
+func (r *Relay) RelayBatch(ctx context.Context) (int, error) {
+	entries, err := r.db.ClaimOutbox(ctx, r.batchSize, r.lease)
+	if err != nil {
+		return 0, err
+	}
+	relayed := make([]int64, 0, len(entries))
+	for _, entry := range entries {
+		if err = r.relay(ctx, entry); err != nil {
+			failedEntries.WithLabelValues(string(entry.Kind)).Inc()
+			err = fmt.Errorf("failed to relay outbox entry %d: %w", entry.ID, err)
+			break
+		}
+		relayedEntries.WithLabelValues(string(entry.Kind)).Inc()
+		relayed = append(relayed, entry.ID)
+	}
+	if len(relayed) == 0 {
+		return 0, err
+	}
+	// if it fails the entries are relayed again, that's what at least once means
+	if deleteErr := r.db.DeleteOutbox(ctx, relayed); deleteErr != nil {
+		return 0, errors.Join(err, deleteErr)
+	}
+	return len(relayed), err
+}
+
+func (r *Relay) relay(ctx context.Context, entry database.OutboxEntry) error {
+	switch entry.Kind {
+	case database.OutboxTweet:
+		var tweet twitter.Tweet
+		if err := json.Unmarshal(entry.Payload, &tweet); err != nil {
+			log.Error().Err(err).Msgf("Dropped malformed outbox entry %d", entry.ID) // there is nothing to retry
+			return nil
+		}
+		// the tweet may be deleted before it's relayed, it's not brought back to the cache then
+		if deleted, err := r.tweetDeleted(ctx, tweet.ID); err != nil || deleted {
+			return err
+		}
+		if err := r.cache.PushTweet(ctx, tweet); err != nil {
+			return fmt.Errorf("failed to push tweet %d to cache: %w", tweet.ID, err)
+		}
+		// a delete committed since the check is relayed on its own and may be relayed before this push,
+		// so the tweet is evicted here if it's deleted by now
+		if deleted, err := r.tweetDeleted(ctx, tweet.ID); err != nil {
+			return err
+		} else if deleted {
+			return r.evictTweet(ctx, tweet)
+		}
+		// workers get the tweet itself from the cache
+		if err := r.bus.Publish(ctx, bus.TopicTweets, []byte(strconv.FormatInt(tweet.ID, 10))); err != nil {
+			return fmt.Errorf("failed to publish tweet %d: %w", tweet.ID, err)
+		}
+	case database.OutboxEdit:
+		var tweet twitter.Tweet
+		if err := json.Unmarshal(entry.Payload, &tweet); err != nil {
+			log.Error().Err(err).Msgf("Dropped malformed outbox entry %d", entry.ID)
+			return nil
+		}
+		if err := r.updateTweet(ctx, tweet.ID); err != nil {
+			return err
+		}
+	case database.OutboxDelete:
+		var deleted database.DeletedTweet
+		if err := json.Unmarshal(entry.Payload, &deleted); err != nil {
+			log.Error().Err(err).Msgf("Dropped malformed outbox entry %d", entry.ID)
+			return nil
+		}
+		// retweets were deleted with the tweet and quotes lost the reference,
+		// their followers are told about it as well
+		for _, tweet := range append([]twitter.Tweet{deleted.Tweet}, deleted.Retweets...) {
+			if err := r.retractTweet(ctx, tweet); err != nil {
+				return err
+			}
+		}
+		for _, quote := range deleted.Quotes {
+			if err := r.updateTweet(ctx, quote.ID); err != nil {
+				return err
+			}
+		}
+	case database.OutboxFollow:
+		var follow twitter.Follow
+		if err := json.Unmarshal(entry.Payload, &follow); err != nil {
+			log.Error().Err(err).Msgf("Dropped malformed outbox entry %d", entry.ID)
+			return nil
+		}
+		// unfollowed before it's relayed, the follower is not brought back to the cache
+		if following, err := r.db.IsFollowing(ctx, follow); err != nil {
+			return err
+		} else if !following {
+			return nil
+		}
+		if err := r.cache.FollowUser(ctx, follow); err != nil {
+			return fmt.Errorf("failed to follow user in cache: %w", err)
+		}
+		// worker backfills the follower's timeline
+		if err := r.bus.Publish(ctx, bus.TopicFollows, entry.Payload); err != nil {
+			return fmt.Errorf("failed to publish follow: %w", err)
+		}
+	case database.OutboxUnfollow:
+		var follow twitter.Follow
+		if err := json.Unmarshal(entry.Payload, &follow); err != nil {
+			log.Error().Err(err).Msgf("Dropped malformed outbox entry %d", entry.ID)
+			return nil
+		}
+		// followed again before it's relayed, the newer follow stays
+		if following, err := r.db.IsFollowing(ctx, follow); err != nil {
+			return err
+		} else if following {
+			return nil
+		}
+		tweets, err := r.feedTweets(ctx, follow)
+		if err != nil {
+			return err
+		}
+		// worker reads the followers from the cache, so no new tweets are pushed after this
+		if err := r.cache.UnfollowUser(ctx, follow, tweets); err != nil {
+			return fmt.Errorf("failed to unfollow user in cache: %w", err)
+		}
+	default:
+		log.Error().Msgf("Dropped outbox entry %d of unknown kind %q", entry.ID, entry.Kind)
+	}
+	return nil
+}
+
+// feedTweets returns the followee's tweets as old as the follower's cached timeline, the cached
+// copies of the tweets expire before the timeline, so they are taken from the database
+func (r *Relay) feedTweets(ctx context.Context, follow twitter.Follow) ([]twitter.Tweet, error) {
+	// with a cursor the whole timeline is read
+	timeline, err := r.cache.GetUserTimeline(ctx, follow.FollowerID, twitter.Cursor{Before: math.MaxInt64, Limit: math.MaxInt})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get timeline of user %d: %w", follow.FollowerID, err)
+	}
+	if len(timeline) == 0 {
+		return nil, nil
+	}
+	oldest := slices.Min(timeline)
+
+	var result []twitter.Tweet
+	cursor := twitter.Cursor{Limit: r.batchSize}
+	for {
+		tweets, err := r.db.GetUsersTweets(ctx, follow.FolloweeID, cursor)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get tweets of user %d: %w", follow.FolloweeID, err)
+		}
+		for _, tweet := range tweets {
+			if tweet.ID < oldest {
+				return result, nil
+			}
+			result = append(result, tweet)
+		}
+		if len(tweets) < cursor.Limit {
+			return result, nil
+		}
+		cursor.Before = tweets[len(tweets)-1].ID
+	}
+}
+
+func (r *Relay) tweetDeleted(ctx context.Context, tweetID int64) (bool, error) {
+	_, err := r.db.GetTweet(ctx, tweetID)
+	if errors.Is(err, twitter.ErrTweetNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check tweet %d: %w", tweetID, err)
+	}
+	return false, nil
+}
+
+// evictTweet takes the deleted tweet pushed by the relay out of the cache again,
+// the feeds of the followers too as a retried entry may have been fanned out already
+func (r *Relay) evictTweet(ctx context.Context, tweet twitter.Tweet) error {
+	_, _, err := r.deleteFromCache(ctx, tweet)
+	return err
+}
+
+// retractTweet removes the deleted tweet from the cache and the clients of the followers
+func (r *Relay) retractTweet(ctx context.Context, tweet twitter.Tweet) error {
+	followers, high, err := r.deleteFromCache(ctx, tweet)
+	if err != nil {
+		return err
+	}
+	return r.notifyFollowers(ctx, twitter.ChannelTweet{Tweet: tweet, UserIDs: followers, Followers: high, Event: twitter.TweetEventDeleted})
+}
+
+// deleteFromCache removes the tweet and takes it out of the feeds of the followers. The tweets of
+// a high fan-out user are merged on read, so its followers are not read, high is true then
+func (r *Relay) deleteFromCache(ctx context.Context, tweet twitter.Tweet) ([]int64, bool, error) {
+	followers, high, err := r.feedFollowers(ctx, tweet.UserID)
+	if err != nil {
+		return nil, false, err
+	}
+	if err = r.cache.DeleteTweet(ctx, tweet, followers); err != nil {
+		return nil, false, fmt.Errorf("failed to delete tweet %d from cache: %w", tweet.ID, err)
+	}
+	return followers, high, nil
+}
+
+// updateTweet puts the tweet as it's in the database now into the cache and the clients of the followers,
+// so an older entry relayed again doesn't bring back an older version. A deleted tweet is skipped
+func (r *Relay) updateTweet(ctx context.Context, tweetID int64) error {
+	tweet, err := r.db.GetTweet(ctx, tweetID)
+	if errors.Is(err, twitter.ErrTweetNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get tweet %d: %w", tweetID, err)
+	}
+	if err = r.cache.UpdateTweet(ctx, tweet); err != nil {
+		return fmt.Errorf("failed to update tweet %d in cache: %w", tweet.ID, err)
+	}
+	followers, high, err := r.feedFollowers(ctx, tweet.UserID)
+	if err != nil {
+		return err
+	}
+	return r.notifyFollowers(ctx, twitter.ChannelTweet{Tweet: tweet, UserIDs: followers, Followers: high, Event: twitter.TweetEventEdited})
+}
+
+// feedFollowers returns the followers the tweets of the user are pushed to,
+// none for a high fan-out user as its followers are too many to list
+func (r *Relay) feedFollowers(ctx context.Context, userID int64) ([]int64, bool, error) {
+	high, err := r.cache.IsHighFanout(ctx, userID)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to check high fan-out for user %d: %w", userID, err)
+	}
+	if high {
+		return nil, true, nil
+	}
+	followers, err := r.cache.GetFollowers(ctx, userID)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get followers of user %d: %w", userID, err)
+	}
+	return followers, false, nil
+}
+
+// notifyFollowers sends the event to the websocket servers, they pass it to the followers which are online
+func (r *Relay) notifyFollowers(ctx context.Context, channelTweet twitter.ChannelTweet) error {
+	if len(channelTweet.UserIDs) == 0 && !channelTweet.Followers {
+		return nil
+	}
+	data, err := json.Marshal(channelTweet)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event of tweet %d: %w", channelTweet.Event, channelTweet.Tweet.ID, err)
+	}
+	if err = r.bus.Publish(ctx, bus.TopicDeliveries, data); err != nil {
+		return fmt.Errorf("failed to publish %s event of tweet %d: %w", channelTweet.Event, channelTweet.Tweet.ID, err)
+	}
+	return nil
+}
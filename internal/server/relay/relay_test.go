@@ -0,0 +1,361 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+	"twitter-clone/internal/cache/cachetest"
+	cache_inmemory "twitter-clone/internal/cache/inmemory"
+	db_inmemory "twitter-clone/internal/database/inmemory"
+	"twitter-clone/internal/domain/bus"
+	"twitter-clone/internal/domain/database"
+	"twitter-clone/internal/domain/twitter"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordingBus keeps the published payloads by topic, as many publishes as failures fail first
+type recordingBus struct {
+	bus.Bus
+	mu        sync.Mutex
+	published map[string][]string
+	failures  int
+}
+
+func (b *recordingBus) Publish(ctx context.Context, topic string, payload []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures > 0 {
+		b.failures--
+		return errors.New("bus is down")
+	}
+	if b.published == nil {
+		b.published = make(map[string][]string)
+	}
+	b.published[topic] = append(b.published[topic], string(payload))
+	return nil
+}
+
+func (b *recordingBus) payloads(topic string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.published[topic]
+}
+
+func newTestRelay(b bus.Bus) (*Relay, *db_inmemory.InMemoryDB) {
+	db := db_inmemory.NewInMemoryDB()
+	return &Relay{
+		db:        db,
+		cache:     cache_inmemory.NewInMemoryCache(&cachetest.Config{}),
+		bus:       b,
+		interval:  10 * time.Millisecond,
+		batchSize: 10,
+		lease:     20 * time.Millisecond,
+	}, db
+}
+
+// alice tweets and bob follows her
+func tweetAndFollow(t *testing.T, db *db_inmemory.InMemoryDB) (twitter.Tweet, twitter.Follow) {
+	ctx := context.Background()
+	alice, err := db.CreateUser(ctx, twitter.User{Username: "alice"})
+	require.NoError(t, err)
+	bob, err := db.CreateUser(ctx, twitter.User{Username: "bob"})
+	require.NoError(t, err)
+	tweet, err := db.NewTweet(ctx, twitter.Tweet{UserID: alice.ID, Content: "hello", Kind: twitter.TweetKindOriginal})
+	require.NoError(t, err)
+	follow := twitter.Follow{FollowerID: bob.ID, FolloweeID: alice.ID}
+	require.NoError(t, db.FollowUser(ctx, follow))
+	return tweet, follow
+}
+
+func TestRelayBatch(t *testing.T) {
+	ctx := context.Background()
+	b := &recordingBus{}
+	r, db := newTestRelay(b)
+	tweet, follow := tweetAndFollow(t, db)
+
+	relayed, err := r.RelayBatch(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, relayed)
+
+	cached, err := r.cache.GetTweet(ctx, tweet.ID)
+	require.NoError(t, err)
+	require.Equal(t, tweet.Content, cached.Content)
+	require.True(t, tweet.CreatedAt.Equal(cached.CreatedAt))
+	followers, err := r.cache.GetFollowers(ctx, follow.FolloweeID)
+	require.NoError(t, err)
+	require.Equal(t, []int64{follow.FollowerID}, followers)
+
+	require.Equal(t, []string{strconv.FormatInt(tweet.ID, 10)}, b.payloads(bus.TopicTweets))
+	require.Len(t, b.payloads(bus.TopicFollows), 1)
+	var published twitter.Follow
+	require.NoError(t, json.Unmarshal([]byte(b.payloads(bus.TopicFollows)[0]), &published))
+	require.Equal(t, follow.FollowerID, published.FollowerID)
+	require.Equal(t, follow.FolloweeID, published.FolloweeID)
+
+	// nothing is left to relay
+	relayed, err = r.RelayBatch(ctx)
+	require.NoError(t, err)
+	require.Zero(t, relayed)
+}
+
+func TestRelayRetriesFailures(t *testing.T) {
+	ctx := context.Background()
+	b := &recordingBus{failures: 1}
+	r, db := newTestRelay(b)
+	tweet, _ := tweetAndFollow(t, db)
+
+	// the tweet fails and the follow waits for it, so they stay in order
+	relayed, err := r.RelayBatch(ctx)
+	require.Error(t, err)
+	require.Zero(t, relayed)
+	require.Empty(t, b.payloads(bus.TopicFollows))
+
+	// both are relayed once the lease runs out
+	require.Eventually(t, func() bool {
+		relayed, err = r.RelayBatch(ctx)
+		return err == nil && relayed == 2
+	}, 2*time.Second, 5*time.Millisecond)
+	require.Equal(t, []string{strconv.FormatInt(tweet.ID, 10)}, b.payloads(bus.TopicTweets))
+	require.Len(t, b.payloads(bus.TopicFollows), 1)
+
+	// the tweet was pushed to the cache twice, it's listed once
+	ids, err := r.cache.GetUserTweets(ctx, tweet.UserID)
+	require.NoError(t, err)
+	require.Equal(t, []int64{tweet.ID}, ids)
+}
+
+func TestRelaySkipsDeletedTweets(t *testing.T) {
+	ctx := context.Background()
+	b := &recordingBus{}
+	r, db := newTestRelay(b)
+	tweet, _ := tweetAndFollow(t, db)
+	_, err := db.DeleteTweet(ctx, tweet.ID, tweet.UserID)
+	require.NoError(t, err)
+
+	relayed, err := r.RelayBatch(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 3, relayed)
+
+	// the deleted tweet is not cached and not announced, the follow goes on
+	_, err = r.cache.GetTweet(ctx, tweet.ID)
+	require.Error(t, err)
+	require.Empty(t, b.payloads(bus.TopicTweets))
+	require.Len(t, b.payloads(bus.TopicFollows), 1)
+}
+
+func TestRelayEdit(t *testing.T) {
+	ctx := context.Background()
+	b := &recordingBus{}
+	r, db := newTestRelay(b)
+	tweet, follow := tweetAndFollow(t, db)
+	_, err := r.RelayBatch(ctx)
+	require.NoError(t, err)
+
+	for _, content := range []string{"v2", "v3"} {
+		_, err = db.EditTweet(ctx, twitter.Tweet{ID: tweet.ID, UserID: tweet.UserID, Content: content})
+		require.NoError(t, err)
+	}
+	entries, err := db.ClaimOutbox(ctx, 10, time.Hour)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	// the older edit relayed last doesn't bring back the older content
+	require.NoError(t, r.relay(ctx, entries[1]))
+	require.NoError(t, r.relay(ctx, entries[0]))
+
+	cached, err := r.cache.GetTweet(ctx, tweet.ID)
+	require.NoError(t, err)
+	require.Equal(t, "v3", cached.Content)
+	for _, payload := range b.payloads(bus.TopicDeliveries) {
+		var event twitter.ChannelTweet
+		require.NoError(t, json.Unmarshal([]byte(payload), &event))
+		require.Equal(t, twitter.TweetEventEdited, event.Event)
+		require.Equal(t, "v3", event.Tweet.Content)
+		require.Equal(t, []int64{follow.FollowerID}, event.UserIDs)
+	}
+	require.Len(t, b.payloads(bus.TopicDeliveries), 2)
+}
+
+func TestRelayDelete(t *testing.T) {
+	ctx := context.Background()
+	b := &recordingBus{}
+	r, db := newTestRelay(b)
+	tweet, follow := tweetAndFollow(t, db)
+	retweet, err := db.NewTweet(ctx, twitter.Tweet{UserID: follow.FollowerID, Kind: twitter.TweetKindRetweet, ReferencedID: &tweet.ID})
+	require.NoError(t, err)
+	quote, err := db.NewTweet(ctx, twitter.Tweet{UserID: follow.FollowerID, Content: "look", Kind: twitter.TweetKindQuote, ReferencedID: &tweet.ID})
+	require.NoError(t, err)
+	_, err = r.RelayBatch(ctx)
+	require.NoError(t, err)
+	// alice has too many followers by now, they are not listed, bob's followers are
+	require.NoError(t, r.cache.SetHighFanout(ctx, follow.FolloweeID, true))
+	require.NoError(t, r.cache.FollowUser(ctx, twitter.Follow{FollowerID: follow.FolloweeID, FolloweeID: follow.FollowerID}))
+
+	_, err = db.DeleteTweet(ctx, tweet.ID, tweet.UserID)
+	require.NoError(t, err)
+	// the delete is committed, the cache and the bus are brought up to date even if they fail at first
+	b.failures = 1
+	_, err = r.RelayBatch(ctx)
+	require.Error(t, err)
+	require.Eventually(t, func() bool {
+		relayed, err := r.RelayBatch(ctx)
+		return err == nil && relayed == 1
+	}, 2*time.Second, 5*time.Millisecond)
+
+	for _, id := range []int64{tweet.ID, retweet.ID} {
+		_, err = r.cache.GetTweet(ctx, id)
+		require.Error(t, err)
+	}
+	cached, err := r.cache.GetTweet(ctx, quote.ID)
+	require.NoError(t, err)
+	require.Nil(t, cached.ReferencedID)
+
+	var events []twitter.ChannelTweet
+	for _, payload := range b.payloads(bus.TopicDeliveries) {
+		var event twitter.ChannelTweet
+		require.NoError(t, json.Unmarshal([]byte(payload), &event))
+		events = append(events, event)
+	}
+	require.Len(t, events, 3)
+	require.Equal(t, twitter.TweetEventDeleted, events[0].Event)
+	require.Equal(t, tweet.ID, events[0].Tweet.ID)
+	require.True(t, events[0].Followers)
+	require.Empty(t, events[0].UserIDs)
+	require.Equal(t, twitter.TweetEventDeleted, events[1].Event)
+	require.Equal(t, retweet.ID, events[1].Tweet.ID)
+	require.Equal(t, []int64{follow.FolloweeID}, events[1].UserIDs)
+	require.Equal(t, twitter.TweetEventEdited, events[2].Event)
+	require.Equal(t, quote.ID, events[2].Tweet.ID)
+	require.Equal(t, []int64{follow.FolloweeID}, events[2].UserIDs)
+}
+
+// deletingDB deletes the tweet right after the relay checked it, before it's pushed to the cache
+type deletingDB struct {
+	*db_inmemory.InMemoryDB
+	checked bool
+}
+
+func (db *deletingDB) GetTweet(ctx context.Context, tweetID int64) (twitter.Tweet, error) {
+	tweet, err := db.InMemoryDB.GetTweet(ctx, tweetID)
+	if err == nil && !db.checked {
+		db.checked = true
+		_, err = db.DeleteTweet(ctx, tweet.ID, tweet.UserID)
+	}
+	return tweet, err
+}
+
+func TestRelayEvictsTweetDeletedWhileRelayed(t *testing.T) {
+	ctx := context.Background()
+	b := &recordingBus{}
+	r, db := newTestRelay(b)
+	r.db = &deletingDB{InMemoryDB: db}
+	tweet, _ := tweetAndFollow(t, db)
+
+	relayed, err := r.RelayBatch(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, relayed)
+
+	_, err = r.cache.GetTweet(ctx, tweet.ID)
+	require.Error(t, err)
+	ids, err := r.cache.GetUserTweets(ctx, tweet.UserID)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+	require.Empty(t, b.payloads(bus.TopicTweets))
+}
+
+func TestRelayUnfollow(t *testing.T) {
+	ctx := context.Background()
+	b := &recordingBus{}
+	r, db := newTestRelay(b)
+	_, follow := tweetAndFollow(t, db)
+	_, err := r.RelayBatch(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, db.UnfollowUser(ctx, follow))
+	relayed, err := r.RelayBatch(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, relayed)
+	followers, err := r.cache.GetFollowers(ctx, follow.FolloweeID)
+	require.NoError(t, err)
+	require.Empty(t, followers)
+}
+
+func TestRelayUnfollowPurgesTimeline(t *testing.T) {
+	ctx := context.Background()
+	b := &recordingBus{}
+	r, db := newTestRelay(b)
+	tweet, follow := tweetAndFollow(t, db)
+	another, err := db.NewTweet(ctx, twitter.Tweet{UserID: follow.FolloweeID, Content: "hi", Kind: twitter.TweetKindOriginal})
+	require.NoError(t, err)
+	_, err = r.RelayBatch(ctx)
+	require.NoError(t, err)
+	require.NoError(t, r.cache.StoreTimeline(ctx, follow.FollowerID, []twitter.Tweet{tweet, another}))
+
+	// the followee's tweets are taken from the database page by page, not from their cached copies
+	r.batchSize = 1
+	require.NoError(t, db.UnfollowUser(ctx, follow))
+	relayed, err := r.RelayBatch(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, relayed)
+	timeline, err := r.cache.GetUserTimeline(ctx, follow.FollowerID, twitter.Cursor{Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, timeline)
+}
+
+func TestRelayFollowAfterUnfollow(t *testing.T) {
+	ctx := context.Background()
+	b := &recordingBus{}
+	r, db := newTestRelay(b)
+	_, follow := tweetAndFollow(t, db)
+	require.NoError(t, db.UnfollowUser(ctx, follow))
+	entries, err := db.ClaimOutbox(ctx, 10, time.Hour)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	// the follow was retried after another relay passed the unfollow on, the follower stays out
+	require.Equal(t, database.OutboxUnfollow, entries[2].Kind)
+	require.NoError(t, r.relay(ctx, entries[2]))
+	require.Equal(t, database.OutboxFollow, entries[1].Kind)
+	require.NoError(t, r.relay(ctx, entries[1]))
+	followers, err := r.cache.GetFollowers(ctx, follow.FolloweeID)
+	require.NoError(t, err)
+	require.Empty(t, followers)
+	require.Empty(t, b.payloads(bus.TopicFollows))
+
+	// followed again, the old unfollow doesn't take the new follow away
+	require.NoError(t, db.FollowUser(ctx, follow))
+	entries, err = db.ClaimOutbox(ctx, 10, time.Hour)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.NoError(t, r.relay(ctx, entries[0]))
+	payload, err := json.Marshal(follow)
+	require.NoError(t, err)
+	require.NoError(t, r.relay(ctx, database.OutboxEntry{ID: 100, Kind: database.OutboxUnfollow, Payload: payload}))
+	followers, err = r.cache.GetFollowers(ctx, follow.FolloweeID)
+	require.NoError(t, err)
+	require.Equal(t, []int64{follow.FollowerID}, followers)
+}
+
+func TestRelayStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := &recordingBus{failures: 3}
+	r, db := newTestRelay(b)
+	tweetAndFollow(t, db)
+
+	done := make(chan error)
+	go func() {
+		done <- r.Start(ctx)
+	}()
+	// failures don't stop it
+	require.Eventually(t, func() bool {
+		return len(b.payloads(bus.TopicFollows)) == 1
+	}, 2*time.Second, 5*time.Millisecond)
+
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+}
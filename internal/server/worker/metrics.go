@@ -0,0 +1,31 @@
+package worker
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// served by the metrics server from the default registry
+var (
+	queueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "worker",
+		Name:      "queue_depth",
+		Help:      "Jobs waiting for a free worker of the pool",
+	})
+	processingSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "worker",
+		Name:      "processing_seconds",
+		Help:      "Time spent on a job by the pool",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14), // 1ms ... ~8s
+	}, []string{"kind"})
+	failedJobs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "worker",
+		Name:      "failed_jobs_total",
+		Help:      "Jobs finished with an error",
+	}, []string{"kind"})
+	deadLetters = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "worker",
+		Name:      "dead_letters_total",
+		Help:      "Tweets which couldn't be delivered to a follower after all the retries",
+	})
+)
@@ -2,81 +2,420 @@ package worker
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
 	"strconv"
+	"sync"
+	"time"
+	"twitter-clone/internal/domain/bus"
 	"twitter-clone/internal/domain/cache"
+	"twitter-clone/internal/domain/config"
+	"twitter-clone/internal/domain/database"
 	"twitter-clone/internal/domain/twitter"
+
+	"github.com/rs/zerolog/log"
 )
 
+// all the workers share the tweets and the follows of one group
+const workersGroup = "workers"
+
 type Worker struct {
 	cache cache.Cache
+	db    database.DatabaseI // tweets missing in the cache are read from it, nil if there is none
+	bus   bus.Bus
+
+	fanoutThreshold int
+	timelineItems   int
+
+	poolSize int
+	queue    chan job
+
+	retryAttempts int
+	retryBackoff  time.Duration
+	maxDeliveries int
+}
+
+// job is what the pool runs, kind is used for logs and metrics
+type job struct {
+	kind string
+	run  func(ctx context.Context) error
 }
 
-func NewWorker(cache cache.Cache) *Worker {
+func NewWorker(cache cache.Cache, db database.DatabaseI, bus bus.Bus, cacheConfig config.CacheConfig, workerConfig config.WorkerConfig) *Worker {
 	return &Worker{
-		cache: cache,
+		cache:           cache,
+		db:              db,
+		bus:             bus,
+		fanoutThreshold: cacheConfig.FanoutFollowersThreshold(),
+		timelineItems:   max(cacheConfig.MaxTweetsTimelineItems(), 1),
+		poolSize:        max(workerConfig.WorkerPoolSize(), 1),
+		queue:           make(chan job, max(workerConfig.WorkerQueueSize(), 0)),
+		retryAttempts:   max(workerConfig.WorkerRetryAttempts(), 1),
+		retryBackoff:    time.Duration(workerConfig.WorkerRetryBackoffMillis()) * time.Millisecond,
+		maxDeliveries:   max(workerConfig.WorkerMaxDeliveries(), 1),
 	}
 }
 
 func (w *Worker) Start(ctx context.Context) error {
-	var err error
-
-	// it's very specific for the worker
-	// maybe for better abstraction it should be as a handler for Subscribe channel
-	var pubsub <-chan string
+	var (
+		err     error
+		tweets  <-chan bus.Message
+		follows <-chan bus.Message
+	)
+	if tweets, err = w.bus.Subscribe(ctx, bus.TopicTweets, workersGroup); err != nil {
+		return fmt.Errorf("failed to subscribe to tweets: %w", err)
+	}
+	if follows, err = w.bus.Subscribe(ctx, bus.TopicFollows, workersGroup); err != nil {
+		return fmt.Errorf("failed to subscribe to follows: %w", err)
+	}
 
-	if pubsub, err = w.cache.SubscribeToTweetsChannel(ctx, "tweets:channel"); err != nil {
-		return fmt.Errorf("failed to subscribe to tweets channel: %w", err)
+	var wg sync.WaitGroup
+	for range w.poolSize {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.runJobs(ctx)
+		}()
 	}
-	// hmm a little bit confused about the context
+	defer wg.Wait() // jobs which are already running are finished
 
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case msg := <-pubsub:
-			var tweetID int64
-			var tweet twitter.Tweet
-			if tweetID, err = strconv.ParseInt(msg, 10, 64); err != nil {
-				return fmt.Errorf("failed to parse tweet ID: %w", err)
+		case message, ok := <-tweets:
+			if !ok {
+				tweets = nil // closed on shutdown
+				continue
 			}
+			w.enqueue(ctx, w.tweetJob(message))
+		case message, ok := <-follows:
+			if !ok {
+				follows = nil
+				continue
+			}
+			w.enqueue(ctx, w.followJob(message))
+		}
+	}
+}
+
+// tweet is acknowledged only when it's processed, otherwise the bus may deliver it again.
+// After maxDeliveries it's given up as a dead letter, so it isn't delivered forever
+func (w *Worker) tweetJob(message bus.Message) job {
+	return job{
+		kind: "tweet",
+		run: func(ctx context.Context) error {
+			tweetID, err := strconv.ParseInt(string(message.Payload), 10, 64)
+			if err != nil {
+				_ = message.Ack(ctx) // there is nothing to retry
+				return fmt.Errorf("malformed message %v: %v", message.ID, err)
+			}
+			tweet, err := w.getTweet(ctx, tweetID)
+			if errors.Is(err, twitter.ErrTweetNotFound) {
+				return message.Ack(ctx) // deleted before it was processed, there is nothing to deliver
+			}
+			if err == nil {
+				err = w.ProcessTweet(ctx, tweet)
+			}
+			if err == nil {
+				return message.Ack(ctx)
+			}
+			if message.Deliveries < w.maxDeliveries {
+				return err
+			}
+			tweet.ID = tweetID
+			err = fmt.Errorf("gave up after %d deliveries: %w", message.Deliveries, err)
+			w.deadLetter(ctx, 0, tweet, err)
+			_ = message.Ack(ctx)
+			return err
+		},
+	}
+}
 
-			if tweet, err = w.cache.GetTweet(ctx, tweetID); err != nil {
-				return fmt.Errorf("failed to get tweet %d: %w", tweetID, err)
+func (w *Worker) followJob(message bus.Message) job {
+	return job{
+		kind: "follow",
+		run: func(ctx context.Context) error {
+			var follow twitter.Follow
+			if err := json.Unmarshal(message.Payload, &follow); err != nil {
+				_ = message.Ack(ctx)
+				return fmt.Errorf("malformed follow %q: %v", message.Payload, err)
 			}
+			if err := w.ProcessFollow(ctx, follow); err != nil {
+				if message.Deliveries >= w.maxDeliveries {
+					// the timeline just misses the older tweets, they show up once it's rebuilt
+					_ = message.Ack(ctx)
+					return fmt.Errorf("gave up follow after %d deliveries: %w", message.Deliveries, err)
+				}
+				return err
+			}
+			return message.Ack(ctx)
+		},
+	}
+}
 
-			go func() {
-				_ = w.ProcessTweet(ctx, tweet)
-			}()
+// getTweet reads the tweet from the cache, the expired or evicted one from the database.
+// twitter.ErrTweetNotFound means it's deleted
+func (w *Worker) getTweet(ctx context.Context, tweetID int64) (twitter.Tweet, error) {
+	tweet, err := w.cache.GetTweet(ctx, tweetID)
+	if err == nil {
+		return tweet, nil
+	}
+	if w.db == nil {
+		return twitter.Tweet{}, fmt.Errorf("failed to get tweet %d: %w", tweetID, err)
+	}
+	if tweet, err = w.db.GetTweet(ctx, tweetID); err != nil {
+		return twitter.Tweet{}, fmt.Errorf("failed to get tweet %d from database: %w", tweetID, err)
+	}
+	return tweet, nil
+}
+
+// returns false if the context is done before the time is up
+func (w *Worker) wait(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// enqueue blocks while the queue is full, so nothing new is read until the pool catches up
+func (w *Worker) enqueue(ctx context.Context, j job) {
+	select {
+	case <-ctx.Done():
+	case w.queue <- j:
+		queueDepth.Set(float64(len(w.queue)))
+	}
+}
+
+func (w *Worker) runJobs(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-w.queue:
+			queueDepth.Set(float64(len(w.queue)))
+			start := time.Now()
+			err := j.run(ctx)
+			processingSeconds.WithLabelValues(j.kind).Observe(time.Since(start).Seconds())
+			if err != nil {
+				// one failed job shouldn't stop the worker
+				failedJobs.WithLabelValues(j.kind).Inc()
+				log.Error().Err(err).Msgf("Failed to process %s", j.kind)
+			}
 		}
 	}
 }
 
 func (w *Worker) ProcessTweet(ctx context.Context, tweet twitter.Tweet) error {
+	count, err := w.cache.CountFollowers(ctx, tweet.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to count followers for user %v: %v", tweet.UserID, err)
+	}
+
+	// too many followers to push it to every feed, readers merge it into their timelines.
+	// The followers are not even read, every websocket server finds the connected ones itself
+	if w.fanoutThreshold > 0 && count > w.fanoutThreshold {
+		if err = w.cache.SetHighFanout(ctx, tweet.UserID, true); err != nil {
+			return fmt.Errorf("failed to set high fan-out for user %v: %v", tweet.UserID, err)
+		}
+		w.publish(ctx, twitter.ChannelTweet{Tweet: tweet, Followers: true})
+		return nil
+	}
+
 	followers, err := w.cache.GetFollowers(ctx, tweet.UserID)
 	if err != nil {
 		return fmt.Errorf("failed to get followers for user %v: %v", tweet.UserID, err)
 	}
+	if err = w.leaveHighFanout(ctx, tweet, followers); err != nil {
+		return err
+	}
 
-	// here we have to check the amount of follower & active followers
+	// here we have to check the amount of active followers
 	// we don't need to send to all followers at once, maybe better to keep it on client
+	// every follower has its own check for the original of a retweet, so they can't be batched
+	pending := followers
+	if tweet.Kind != twitter.TweetKindRetweet || tweet.ReferencedID == nil {
+		// only the users of the failed batches are left to push one by one
+		var err error
+		if pending, err = w.cache.PushToUserFeeds(ctx, followers, tweet.ID); err != nil {
+			log.Error().Err(err).Msgf("Failed to push tweet %d to %d feeds in batches", tweet.ID, len(pending))
+		}
+	}
+	skipped := make(map[int64]bool, len(pending))
+	for _, followerID := range pending {
+		pushed, err := w.deliver(ctx, followerID, tweet)
+		if err != nil {
+			skipped[followerID] = true
+			w.deadLetter(ctx, followerID, tweet, err)
+			continue
+		}
+		if !pushed {
+			skipped[followerID] = true // follower has already seen the original
+		}
+	}
+
+	recipients := make([]int64, 0, len(followers))
 	for _, followerID := range followers {
-		// do it as a batch to reduce a time!!!
-		if err := w.cache.PushToUserFeed(ctx, followerID, tweet.ID); err != nil {
-			return fmt.Errorf("failed to push tweet %d to user feed for follower %d: %v", tweet.ID, followerID, err)
+		if !skipped[followerID] {
+			recipients = append(recipients, followerID)
+		}
+	}
+	w.sendToWebSocket(ctx, recipients, tweet)
+
+	// a failed follower doesn't fail the tweet, the rest would get it twice when it's processed again
+	return nil
+}
+
+// leaveHighFanout puts the tweets the user wrote while it had too many followers into the feeds,
+// the readers stop merging them only after that, so they don't disappear from the timelines.
+// The tweet and the newer ones are left to be pushed as usual
+func (w *Worker) leaveHighFanout(ctx context.Context, tweet twitter.Tweet, followers []int64) error {
+	userID := tweet.UserID
+	high, err := w.cache.IsHighFanout(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to check high fan-out for user %v: %v", userID, err)
+	}
+	if !high {
+		return nil
+	}
+	tweetIDs, err := w.cache.GetUserTweets(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get tweets of user %v: %v", userID, err)
+	}
+	tweetIDs = slices.DeleteFunc(tweetIDs, func(id int64) bool {
+		return id >= tweet.ID
+	})
+	for _, followerID := range followers {
+		if err = w.cache.MergeToUserFeed(ctx, followerID, tweetIDs); err != nil {
+			return fmt.Errorf("failed to merge tweets of user %v to user feed for follower %d: %v", userID, followerID, err)
+		}
+	}
+	if err = w.cache.SetHighFanout(ctx, userID, false); err != nil {
+		return fmt.Errorf("failed to set high fan-out for user %v: %v", userID, err)
+	}
+	return nil
+}
+
+// deliver pushes the tweet to one feed, retrying with exponential backoff,
+// returns false if the retweet is skipped because its original is already there
+func (w *Worker) deliver(ctx context.Context, userID int64, tweet twitter.Tweet) (bool, error) {
+	var (
+		pushed bool
+		err    error
+	)
+	backoff := w.retryBackoff
+	for attempt := 1; ; attempt++ {
+		if tweet.Kind == twitter.TweetKindRetweet && tweet.ReferencedID != nil {
+			pushed, err = w.cache.PushRetweetToUserFeed(ctx, userID, tweet.ID, *tweet.ReferencedID)
+		} else {
+			pushed, err = true, w.cache.PushToUserFeed(ctx, userID, tweet.ID)
+		}
+		if err == nil {
+			return pushed, nil
+		}
+		if attempt == w.retryAttempts || !w.wait(ctx, backoff) {
+			return false, fmt.Errorf("failed to push tweet %d to user feed for follower %d after %d attempts: %v", tweet.ID, userID, attempt, err)
+		}
+		backoff *= 2
+	}
+}
+
+// deadLetter stores the failed delivery to be replayed later. A dead letter that can't be stored
+// is only logged, failing the job would deliver the tweet again to the followers who already got it
+func (w *Worker) deadLetter(ctx context.Context, userID int64, tweet twitter.Tweet, deliveryErr error) {
+	deadLetters.Inc()
+	log.Error().Err(deliveryErr).Msgf("Tweet %d is a dead letter for user %d", tweet.ID, userID)
+	if err := w.cache.PushDeadLetter(ctx, cache.DeadLetter{
+		UserID:   userID,
+		Tweet:    tweet,
+		Error:    deliveryErr.Error(),
+		FailedAt: time.Now().UTC(),
+	}); err != nil {
+		log.Error().Err(err).Msgf("Failed to store dead letter of tweet %d for user %d", tweet.ID, userID)
+	}
+}
+
+// ReplayDeadLetters tries to deliver the dead letters again, the delivered ones are removed.
+// It returns how many of them were delivered
+func (w *Worker) ReplayDeadLetters(ctx context.Context) (int, error) {
+	letters, err := w.cache.GetDeadLetters(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get dead letters: %w", err)
+	}
+	replayed := 0
+	for _, letter := range letters {
+		if err = w.replay(ctx, letter); err != nil {
+			log.Error().Err(err).Msgf("Failed to replay tweet %d for user %d", letter.Tweet.ID, letter.UserID)
+			continue
 		}
-		w.sendToWebSocket(ctx, followerID, tweet)
+		if err = w.cache.RemoveDeadLetter(ctx, letter); err != nil {
+			return replayed, fmt.Errorf("failed to remove dead letter: %w", err)
+		}
+		replayed++
 	}
+	return replayed, nil
+}
 
+// replay delivers the dead letter again, the tweet which failed as a whole is processed from the start
+func (w *Worker) replay(ctx context.Context, letter cache.DeadLetter) error {
+	if letter.UserID != 0 {
+		_, err := w.deliver(ctx, letter.UserID, letter.Tweet)
+		return err
+	}
+	tweet, err := w.getTweet(ctx, letter.Tweet.ID)
+	if errors.Is(err, twitter.ErrTweetNotFound) {
+		return nil // deleted since, there is nothing to deliver
+	}
+	if err != nil {
+		return err
+	}
+	return w.ProcessTweet(ctx, tweet)
+}
+
+// ProcessFollow puts the latest tweets of the followee into the follower's timeline,
+// otherwise they would appear only after the timeline expires.
+// The cached list of the followee's tweets expires too, then they are read from the database
+func (w *Worker) ProcessFollow(ctx context.Context, follow twitter.Follow) error {
+	tweetIDs, err := w.cache.GetUserTweets(ctx, follow.FolloweeID)
+	if err != nil {
+		return fmt.Errorf("failed to get tweets of user %v: %v", follow.FolloweeID, err)
+	}
+	if len(tweetIDs) == 0 && w.db != nil {
+		tweets, err := w.db.GetUsersTweets(ctx, follow.FolloweeID, twitter.Cursor{Limit: w.timelineItems})
+		if err != nil {
+			return fmt.Errorf("failed to get tweets of user %v from database: %w", follow.FolloweeID, err)
+		}
+		for _, tweet := range tweets {
+			tweetIDs = append(tweetIDs, tweet.ID)
+		}
+	}
+	if err = w.cache.MergeToUserFeed(ctx, follow.FollowerID, tweetIDs); err != nil {
+		return fmt.Errorf("failed to merge tweets of user %v to user feed for follower %d: %v", follow.FolloweeID, follow.FollowerID, err)
+	}
 	return nil
 }
 
-func (w *Worker) sendToWebSocket(ctx context.Context, userID int64, tweet twitter.Tweet) {
-	channelTweet := twitter.ChannelTweet{
-		Tweet:  tweet,
-		UserID: userID,
+// one message for all the recipients, the websocket server picks the connected ones
+func (w *Worker) sendToWebSocket(ctx context.Context, userIDs []int64, tweet twitter.Tweet) {
+	if len(userIDs) == 0 {
+		return
+	}
+	w.publish(ctx, twitter.ChannelTweet{
+		Tweet:   tweet,
+		UserIDs: userIDs,
+	})
+}
+
+func (w *Worker) publish(ctx context.Context, channelTweet twitter.ChannelTweet) {
+	data, err := json.Marshal(channelTweet)
+	if err == nil {
+		err = w.bus.Publish(ctx, bus.TopicDeliveries, data)
 	}
-	if err := w.cache.PushToTweetChannel(ctx, channelTweet); err != nil {
+	if err != nil {
 		fmt.Println("Failed to push tweet to channel:", err)
 	}
 }
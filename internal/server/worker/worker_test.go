@@ -0,0 +1,459 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+	redis_cache "twitter-clone/internal/cache"
+	"twitter-clone/internal/cache/cachetest"
+	"twitter-clone/internal/cache/inmemory"
+	db_inmemory "twitter-clone/internal/database/inmemory"
+	"twitter-clone/internal/domain/bus"
+	"twitter-clone/internal/domain/cache"
+	"twitter-clone/internal/domain/twitter"
+	"twitter-clone/internal/messaging"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// benchConfig is the cache of the tests with the batch size and the feed length of a real deployment
+type benchConfig struct {
+	cachetest.Config
+}
+
+func (c *benchConfig) FanoutBatchSize() int        { return 500 }
+func (c *benchConfig) MaxTweetsTimelineItems() int { return 100 }
+
+// the tweet is pushed to the feeds of redis over the network, miniredis keeps the round trips real
+func BenchmarkFanout(b *testing.B) {
+	server := miniredis.RunT(b)
+	c := redis_cache.NewRedisCache(&benchConfig{cachetest.Config{Address: server.Addr()}})
+	b.Cleanup(func() {
+		_ = c.Close() // lint
+	})
+	w := &Worker{cache: c, retryAttempts: 1}
+
+	ctx := context.Background()
+	tweet := twitter.Tweet{ID: 1, UserID: 1}
+	for _, count := range []int{10, 100, 1000} {
+		followers := make([]int64, count)
+		for i := range followers {
+			followers[i] = int64(i + 2)
+		}
+
+		// the path of the retweets and of the failed batches, one push per follower
+		b.Run(fmt.Sprintf("per_follower/%d", count), func(b *testing.B) {
+			for range b.N {
+				for _, followerID := range followers {
+					if _, err := w.deliver(ctx, followerID, tweet); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("batched/%d", count), func(b *testing.B) {
+			for range b.N {
+				if failed, err := c.PushToUserFeeds(ctx, followers, tweet.ID); err != nil {
+					b.Fatal(failed, err)
+				}
+			}
+		})
+	}
+}
+
+// channelBus feeds the worker from the test and records the acknowledged messages
+type channelBus struct {
+	tweets  chan bus.Message
+	follows chan bus.Message
+
+	mu    sync.Mutex
+	acked []string
+}
+
+func (b *channelBus) Publish(ctx context.Context, topic string, payload []byte) error {
+	return nil
+}
+
+func (b *channelBus) Subscribe(ctx context.Context, topic, group string) (<-chan bus.Message, error) {
+	if topic == bus.TopicFollows {
+		return b.follows, nil
+	}
+	return b.tweets, nil
+}
+
+// message is delivered as many times as deliveries
+func (b *channelBus) message(id, payload string, deliveries int) bus.Message {
+	return bus.Message{
+		ID:         id,
+		Payload:    []byte(payload),
+		Deliveries: deliveries,
+		Ack: func(ctx context.Context) error {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.acked = append(b.acked, id)
+			return nil
+		},
+	}
+}
+
+func (b *channelBus) processed() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Sorted(slices.Values(b.acked))
+}
+
+// channelCache records the pushed tweets and the dead letters
+type channelCache struct {
+	cache.Cache
+
+	mu            sync.Mutex
+	pushed        []int64
+	deadLetters   []cache.DeadLetter
+	deadLetterErr error
+}
+
+func (c *channelCache) GetTweet(ctx context.Context, tweetID int64) (twitter.Tweet, error) {
+	if tweetID == 404 {
+		return twitter.Tweet{}, errors.New("tweet expired")
+	}
+	return twitter.Tweet{ID: tweetID, UserID: 1}, nil
+}
+
+func (c *channelCache) GetFollowers(ctx context.Context, userID int64) ([]int64, error) {
+	return []int64{2}, nil
+}
+
+func (c *channelCache) CountFollowers(ctx context.Context, userID int64) (int, error) {
+	return 1, nil
+}
+
+func (c *channelCache) SetHighFanout(ctx context.Context, userID int64, high bool) error {
+	return nil
+}
+
+func (c *channelCache) IsHighFanout(ctx context.Context, userID int64) (bool, error) {
+	return false, nil
+}
+
+func (c *channelCache) PushToUserFeeds(ctx context.Context, userIDs []int64, tweetID int64) ([]int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pushed = append(c.pushed, tweetID)
+	return nil, nil
+}
+
+func (c *channelCache) PushDeadLetter(ctx context.Context, letter cache.DeadLetter) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadLetters = append(c.deadLetters, letter)
+	return nil
+}
+
+func (c *channelCache) letters() []cache.DeadLetter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.deadLetters)
+}
+
+func (c *channelCache) processed() []int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Sorted(slices.Values(c.pushed))
+}
+
+func TestStartKeepsGoingAfterFailures(t *testing.T) {
+	c := &channelCache{}
+	b := &channelBus{tweets: make(chan bus.Message), follows: make(chan bus.Message)}
+	w := &Worker{cache: c, bus: b, poolSize: 2, queue: make(chan job, 1), maxDeliveries: 3}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.Start(ctx)
+	}()
+
+	b.follows <- b.message("5-0", "not a follow", 1) // malformed, dropped
+	b.tweets <- b.message("1-0", "", 1)              // malformed, dropped
+	b.tweets <- b.message("2-0", "404", 1)           // GetTweet fails, left to be delivered again
+	b.tweets <- b.message("3-0", "1", 1)
+	b.tweets <- b.message("4-0", "2", 1)
+	b.tweets <- b.message("6-0", "404", 3) // fails for the last time, given up as a dead letter
+
+	require.Eventually(t, func() bool {
+		return slices.Equal(c.processed(), []int64{1, 2}) && slices.Equal(b.processed(), []string{"1-0", "3-0", "4-0", "5-0", "6-0"})
+	}, time.Second, 10*time.Millisecond)
+	letters := c.letters()
+	require.Len(t, letters, 1)
+	require.Zero(t, letters[0].UserID)
+	require.Equal(t, int64(404), letters[0].Tweet.ID)
+
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+}
+
+// flakyCache fails the batch and then every push to a feed as many times as set for the user
+type flakyCache struct {
+	cache.Cache
+	failures map[int64]int
+
+	pushed        []int64
+	deadLetters   []cache.DeadLetter
+	deadLetterErr error
+}
+
+func (c *flakyCache) GetFollowers(ctx context.Context, userID int64) ([]int64, error) {
+	return []int64{1, 2, 3}, nil
+}
+
+func (c *flakyCache) CountFollowers(ctx context.Context, userID int64) (int, error) {
+	return 3, nil
+}
+
+func (c *flakyCache) SetHighFanout(ctx context.Context, userID int64, high bool) error {
+	return nil
+}
+
+func (c *flakyCache) IsHighFanout(ctx context.Context, userID int64) (bool, error) {
+	return false, nil
+}
+
+func (c *flakyCache) PushToUserFeeds(ctx context.Context, userIDs []int64, tweetID int64) ([]int64, error) {
+	var failed []int64
+	for _, userID := range userIDs {
+		if c.failures[userID] > 0 {
+			failed = append(failed, userID)
+		} else {
+			c.pushed = append(c.pushed, userID)
+		}
+	}
+	return failed, nil
+}
+
+func (c *flakyCache) PushToUserFeed(ctx context.Context, userID, tweetID int64) error {
+	if c.failures[userID] > 0 {
+		c.failures[userID]--
+		return errors.New("connection reset")
+	}
+	c.pushed = append(c.pushed, userID)
+	return nil
+}
+
+// recordingBus keeps the recipients of the published deliveries
+// and the authors of the ones which go to all their followers
+type recordingBus struct {
+	bus.Bus
+	published []int64
+	authors   []int64
+}
+
+func (b *recordingBus) Publish(ctx context.Context, topic string, payload []byte) error {
+	var channelTweet twitter.ChannelTweet
+	if err := json.Unmarshal(payload, &channelTweet); err != nil {
+		return err
+	}
+	b.published = append(b.published, channelTweet.UserIDs...)
+	if channelTweet.Followers {
+		b.authors = append(b.authors, channelTweet.Tweet.UserID)
+	}
+	return nil
+}
+
+func (c *flakyCache) PushDeadLetter(ctx context.Context, letter cache.DeadLetter) error {
+	if c.deadLetterErr != nil {
+		return c.deadLetterErr
+	}
+	c.deadLetters = append(c.deadLetters, letter)
+	return nil
+}
+
+func (c *flakyCache) GetDeadLetters(ctx context.Context) ([]cache.DeadLetter, error) {
+	return c.deadLetters, nil
+}
+
+func (c *flakyCache) RemoveDeadLetter(ctx context.Context, letter cache.DeadLetter) error {
+	c.deadLetters = slices.DeleteFunc(c.deadLetters, func(l cache.DeadLetter) bool {
+		return l.UserID == letter.UserID && l.Tweet.ID == letter.Tweet.ID
+	})
+	return nil
+}
+
+func TestProcessTweetRetriesAndDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	c := &flakyCache{failures: map[int64]int{2: 1, 3: 5}}
+	b := &recordingBus{}
+	w := &Worker{cache: c, bus: b, retryAttempts: 3, retryBackoff: time.Millisecond}
+
+	err := w.ProcessTweet(ctx, twitter.Tweet{ID: 10, UserID: 100})
+	require.NoError(t, err)
+
+	// second one got it with the retry, the third one is left for later
+	require.Equal(t, []int64{1, 2}, c.pushed)
+	require.Equal(t, []int64{1, 2}, b.published)
+	require.Len(t, c.deadLetters, 1)
+	require.Equal(t, int64(3), c.deadLetters[0].UserID)
+	require.Equal(t, int64(10), c.deadLetters[0].Tweet.ID)
+	require.Equal(t, 2, c.failures[3])
+
+	// nothing is delivered while it still fails
+	c.failures[3] = 5
+	replayed, err := w.ReplayDeadLetters(ctx)
+	require.NoError(t, err)
+	require.Zero(t, replayed)
+	require.Len(t, c.deadLetters, 1)
+
+	c.failures[3] = 0
+	replayed, err = w.ReplayDeadLetters(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, replayed)
+	require.Empty(t, c.deadLetters)
+	require.Equal(t, []int64{1, 2, 3}, c.pushed)
+}
+
+func TestProcessTweetLostDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	c := &flakyCache{failures: map[int64]int{3: 5}, deadLetterErr: errors.New("connection reset")}
+	b := &recordingBus{}
+	w := &Worker{cache: c, bus: b, retryAttempts: 2, retryBackoff: time.Millisecond}
+
+	// the tweet isn't processed again, the others would get it twice
+	err := w.ProcessTweet(ctx, twitter.Tweet{ID: 10, UserID: 100})
+	require.NoError(t, err)
+	require.Equal(t, []int64{1, 2}, c.pushed)
+	require.Equal(t, []int64{1, 2}, b.published)
+	require.Empty(t, c.deadLetters)
+}
+
+func TestTweetJobReadsMissingTweetsFromDatabase(t *testing.T) {
+	ctx := context.Background()
+	c := &channelCache{}
+	b := &channelBus{}
+	db := db_inmemory.NewInMemoryDB()
+	w := &Worker{cache: c, db: db, bus: b, maxDeliveries: 1}
+
+	user, err := db.CreateUser(ctx, twitter.User{Username: "alice"})
+	require.NoError(t, err)
+	tweet, err := db.NewTweet(ctx, twitter.Tweet{ID: 404, UserID: user.ID, Content: "hello", Kind: twitter.TweetKindOriginal})
+	require.NoError(t, err)
+	require.NoError(t, w.tweetJob(b.message("1-0", "404", 1)).run(ctx))
+	require.Equal(t, []int64{tweet.ID}, c.processed())
+
+	// deleted since it was published, there is nothing to deliver
+	_, err = db.DeleteTweet(ctx, tweet.ID, tweet.UserID)
+	require.NoError(t, err)
+	require.NoError(t, w.tweetJob(b.message("2-0", "404", 1)).run(ctx))
+	require.Equal(t, []string{"1-0", "2-0"}, b.processed())
+	require.Empty(t, c.letters())
+}
+
+func TestProcessTweetHighFanout(t *testing.T) {
+	ctx := context.Background()
+	c := &flakyCache{}
+	b := &recordingBus{}
+	w := &Worker{cache: c, bus: b, fanoutThreshold: 2}
+
+	// merged into the timelines on read, the connected followers are found by the websocket servers
+	require.NoError(t, w.ProcessTweet(ctx, twitter.Tweet{ID: 10, UserID: 100}))
+	require.Empty(t, c.pushed)
+	require.Empty(t, b.published)
+	require.Equal(t, []int64{100}, b.authors)
+}
+
+func TestProcessTweetLeavesHighFanout(t *testing.T) {
+	ctx := context.Background()
+	c := inmemory.NewInMemoryCache(&cachetest.Config{})
+	b := &recordingBus{}
+	w := &Worker{cache: c, bus: b, fanoutThreshold: 1, retryAttempts: 1}
+
+	require.NoError(t, c.SetFollowers(ctx, 1, []twitter.User{{ID: 2}, {ID: 3}}))
+	require.NoError(t, c.StoreTimeline(ctx, 2, []twitter.Tweet{{ID: 5, UserID: 4}}))
+	for _, tweet := range []twitter.Tweet{{ID: 10, UserID: 1}, {ID: 20, UserID: 1}} {
+		require.NoError(t, c.PushTweet(ctx, tweet))
+	}
+
+	require.NoError(t, w.ProcessTweet(ctx, twitter.Tweet{ID: 10, UserID: 1}))
+	timeline, err := c.GetUserTimeline(ctx, 2, twitter.Cursor{Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []int64{5}, timeline)
+
+	// back under the threshold, the tweet which was merged on read goes to the feed too
+	require.NoError(t, c.SetFollowers(ctx, 1, []twitter.User{{ID: 2}}))
+	require.NoError(t, w.ProcessTweet(ctx, twitter.Tweet{ID: 20, UserID: 1}))
+	timeline, err = c.GetUserTimeline(ctx, 2, twitter.Cursor{Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []int64{5, 10, 20}, timeline)
+	high, err := c.IsHighFanout(ctx, 1)
+	require.NoError(t, err)
+	require.False(t, high)
+}
+
+func TestProcessFollowReadsExpiredTweetsFromDatabase(t *testing.T) {
+	ctx := context.Background()
+	c := inmemory.NewInMemoryCache(&cachetest.Config{})
+	db := db_inmemory.NewInMemoryDB()
+	w := &Worker{cache: c, db: db, timelineItems: 2}
+
+	alice, err := db.CreateUser(ctx, twitter.User{Username: "alice"})
+	require.NoError(t, err)
+	var tweets []twitter.Tweet
+	for _, id := range []int64{10, 20, 30} {
+		tweet, err := db.NewTweet(ctx, twitter.Tweet{ID: id, UserID: alice.ID, Content: "hello", Kind: twitter.TweetKindOriginal})
+		require.NoError(t, err)
+		tweets = append(tweets, tweet)
+	}
+	require.NoError(t, c.StoreTimeline(ctx, 2, []twitter.Tweet{{ID: 5, UserID: 4}}))
+
+	// alice hasn't tweeted lately, her cached tweets are gone
+	ids, err := c.GetUserTweets(ctx, alice.ID)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+	require.NoError(t, w.ProcessFollow(ctx, twitter.Follow{FollowerID: 2, FolloweeID: alice.ID}))
+	timeline, err := c.GetUserTimeline(ctx, 2, twitter.Cursor{Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []int64{5, tweets[1].ID, tweets[2].ID}, timeline)
+}
+
+func TestWorkerInProcess(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := inmemory.NewInMemoryCache(&cachetest.Config{})
+	b := messaging.NewInProcess()
+	w := &Worker{cache: c, bus: b, poolSize: 1, queue: make(chan job), retryAttempts: 1}
+
+	require.NoError(t, c.FollowUser(ctx, twitter.Follow{FollowerID: 2, FolloweeID: 1}))
+	require.NoError(t, c.PushTweet(ctx, twitter.Tweet{ID: 10, UserID: 1}))
+	deliveries, err := b.Subscribe(ctx, bus.TopicDeliveries, "websocket")
+	require.NoError(t, err)
+	done := make(chan error)
+	go func() {
+		done <- w.Start(ctx)
+	}()
+
+	// the worker may not be subscribed yet, the in-process bus doesn't keep messages
+	var message bus.Message
+	require.Eventually(t, func() bool {
+		require.NoError(t, b.Publish(ctx, bus.TopicTweets, []byte("10")))
+		select {
+		case message = <-deliveries:
+			return true
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}, 2*time.Second, time.Millisecond)
+
+	var channelTweet twitter.ChannelTweet
+	require.NoError(t, json.Unmarshal(message.Payload, &channelTweet))
+	require.Equal(t, []int64{2}, channelTweet.UserIDs)
+	timeline, err := c.GetUserTimeline(ctx, 2, twitter.Cursor{Limit: 10})
+	require.NoError(t, err)
+	require.Contains(t, timeline, int64(10))
+
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+}
@@ -5,10 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"maps"
 	"net/http"
+	"slices"
 	"sort"
 	"strconv"
+	"sync"
 	"twitter-clone/internal/domain/api"
+	"twitter-clone/internal/domain/bus"
 	"twitter-clone/internal/domain/cache"
 	"twitter-clone/internal/domain/config"
 	"twitter-clone/internal/domain/twitter"
@@ -18,8 +22,13 @@ import (
 )
 
 type WebSocketServer struct {
+	mu      sync.RWMutex // guards clients, they connect while the tweets are delivered
 	clients map[int64]*websocket.Conn
 	cache   cache.Cache
+	bus     bus.Bus
+
+	// users whose followings are not cached, they are asked from the API out of the delivery
+	uncachedFollowing chan int64
 
 	server *http.Server
 
@@ -34,12 +43,14 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-func NewWebSocketServer(cache cache.Cache, config config.WSServerConfig, api api.API) *WebSocketServer {
+func NewWebSocketServer(cache cache.Cache, bus bus.Bus, config config.WSServerConfig, api api.API) *WebSocketServer {
 	commonAddress := fmt.Sprintf("%s:%d", config.WSServerHost(), config.WSServerPort())
 	router := mux.NewRouter()
 	webSocketServer := &WebSocketServer{
-		clients: make(map[int64]*websocket.Conn),
-		cache:   cache,
+		clients:           make(map[int64]*websocket.Conn),
+		cache:             cache,
+		bus:               bus,
+		uncachedFollowing: make(chan int64, 1024),
 		server: &http.Server{
 			Addr:    commonAddress, // Configurable port
 			Handler: router,
@@ -91,7 +102,9 @@ func (ws *WebSocketServer) handleConnections(w http.ResponseWriter, r *http.Requ
 	// firstly we have correctly set user, then -- add to the table
 	ws.handleNewUser(ctx, userID, conn)
 	// I forgot how to store it not in a memory map
+	ws.mu.Lock()
 	ws.clients[userID] = conn
+	ws.mu.Unlock()
 }
 
 func (ws *WebSocketServer) handleNewUser(ctx context.Context, userID int64, conn *websocket.Conn) {
@@ -120,10 +133,15 @@ func (ws *WebSocketServer) handleNewUser(ctx context.Context, userID int64, conn
 		}
 	}
 	var timelineFromCache []int64
-	if timelineFromCache, err = ws.cache.GetUserTimeline(ctx, userID, 10); err != nil { // TODO set limit config
+	cursor := twitter.Cursor{Limit: 10} // TODO set limit config
+	if timelineFromCache, err = ws.cache.GetUserTimeline(ctx, userID, cursor); err != nil {
 		log.Printf("Error fetching timeline from cache: %v", err)
 		return
 	}
+	if timelineFromCache, err = ws.mergeHighFanoutTweets(ctx, userID, timelineFromCache, cursor); err != nil {
+		log.Printf("Error merging high fan-out tweets: %v", err)
+		return
+	}
 	tweets := make([]twitter.Tweet, 0, len(timelineFromCache))
 	for _, i := range timelineFromCache {
 		var tweet twitter.Tweet
@@ -157,6 +175,42 @@ func (ws *WebSocketServer) handleNewUser(ctx context.Context, userID int64, conn
 	}()
 }
 
+// tweets of high fan-out users are not pushed to the feed by the worker
+func (ws *WebSocketServer) mergeHighFanoutTweets(ctx context.Context, userID int64, timeline []int64, cursor twitter.Cursor) ([]int64, error) {
+	followees, err := ws.highFanoutFollowing(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return ws.cache.MergeHighFanoutTweets(ctx, timeline, followees, cursor)
+}
+
+// highFanoutFollowing returns the high fan-out users the user follows, the followings are asked
+// from the API only if they are not cached, then all of them are returned
+func (ws *WebSocketServer) highFanoutFollowing(ctx context.Context, userID int64) ([]int64, error) {
+	var (
+		err       error
+		cached    cache.HighFanoutFollowing
+		following []twitter.User
+	)
+	if cached, err = ws.cache.GetHighFanoutFollowing(ctx, userID); err != nil {
+		return nil, err
+	}
+	if cached.Cached {
+		return cached.Followees, nil
+	}
+	if following, err = ws.api.GetFollowing(ctx, userID); err != nil {
+		return nil, err
+	}
+	followees := make([]int64, len(following))
+	for i, user := range following {
+		followees[i] = user.ID
+	}
+	if err = ws.cache.SetFollowing(ctx, userID, followees, cached.Version); err != nil {
+		return nil, err
+	}
+	return followees, nil
+}
+
 func (ws *WebSocketServer) getAPITimeline(ctx context.Context, userID int64) ([]twitter.Tweet, error) {
 	var err error
 	var tweets []twitter.Tweet
@@ -209,33 +263,110 @@ func (ws *WebSocketServer) checkSetFollowers(ctx context.Context, userID int64)
 }
 
 func (ws *WebSocketServer) HandleTweets(ctx context.Context) {
-	pubsub, err := ws.cache.SubscribeToTweetsChannel(ctx, "workers:channel")
+	// every server needs all the deliveries, it's fine as long as the topic goes over pub/sub
+	deliveries, err := ws.bus.Subscribe(ctx, bus.TopicDeliveries, "websocket")
 	if err != nil {
 		log.Printf("Error subscribing to tweets channel: %v", err)
 		return
 	}
+	go ws.cacheFollowing(ctx)
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case msg := <-pubsub:
+		case msg, ok := <-deliveries:
+			if !ok {
+				return
+			}
 			var tweet twitter.ChannelTweet
-			if err := json.Unmarshal([]byte(msg), &tweet); err != nil {
+			if err := json.Unmarshal(msg.Payload, &tweet); err != nil {
 				log.Printf("Unmarshal error: %v", err)
 				continue
 			}
-			if conn, ok := ws.clients[tweet.UserID]; ok {
-				var tweetMarshalled []byte
-				tweetMarshalled, _ = json.Marshal(tweet.Tweet)
+			var tweetMarshalled []byte
+			tweetMarshalled, _ = json.Marshal(clientMessage(tweet))
+			for _, userID := range ws.recipients(ctx, tweet) {
+				ws.mu.RLock()
+				conn, ok := ws.clients[userID]
+				ws.mu.RUnlock()
+				if !ok {
+					continue // most of the followers are offline
+				}
 				err = conn.WriteMessage(websocket.TextMessage, tweetMarshalled)
 				if err != nil {
 					log.Printf("Error writing message to client: %v", err)
-					delete(ws.clients, tweet.UserID)
+					ws.mu.Lock()
+					delete(ws.clients, userID)
+					ws.mu.Unlock()
 				}
-			} else {
-				log.Printf("User %d is not connected", tweet.UserID)
 			}
 		}
 	}
 }
+
+// batched messages carry the list, single ones only the user.
+// Followers of a high fan-out user are not listed, they are found among the connected clients
+func (ws *WebSocketServer) recipients(ctx context.Context, channelTweet twitter.ChannelTweet) []int64 {
+	if channelTweet.Followers {
+		return ws.connectedFollowers(ctx, channelTweet.Tweet.UserID)
+	}
+	if len(channelTweet.UserIDs) > 0 {
+		return channelTweet.UserIDs
+	}
+	return []int64{channelTweet.UserID}
+}
+
+// the author is a high fan-out user, the connected clients which follow it are found in their cached
+// followings with one lookup. The API is never asked here, the clients whose followings are not cached
+// miss the tweet until the followings are cached again, it's still merged into their timelines
+func (ws *WebSocketServer) connectedFollowers(ctx context.Context, authorID int64) []int64 {
+	ws.mu.RLock()
+	connected := slices.Collect(maps.Keys(ws.clients))
+	ws.mu.RUnlock()
+
+	followers, uncached, err := ws.cache.FollowersAmong(ctx, authorID, connected)
+	if err != nil {
+		log.Printf("Error fetching followers of user %d: %v", authorID, err)
+		return nil
+	}
+	for _, userID := range uncached {
+		select {
+		case ws.uncachedFollowing <- userID:
+		default: // it's full, the user is sent again with the next tweet
+		}
+	}
+	return followers
+}
+
+// cacheFollowing asks the API for the followings which are not cached, out of the delivery loop
+func (ws *WebSocketServer) cacheFollowing(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case userID := <-ws.uncachedFollowing:
+			// already cached if the user was sent more than once
+			if _, err := ws.highFanoutFollowing(ctx, userID); err != nil {
+				log.Printf("Error fetching followings of user %d: %v", userID, err)
+			}
+		}
+	}
+}
+
+// events other than a new tweet are wrapped, so the client can tell them apart
+type tweetEventMessage struct {
+	Event twitter.TweetEvent `json:"event"`
+	Tweet twitter.Tweet      `json:"tweet"`
+}
+
+// new tweets are sent as is, as they were before events appeared
+func clientMessage(channelTweet twitter.ChannelTweet) any {
+	if channelTweet.Event == "" || channelTweet.Event == twitter.TweetEventNew {
+		return channelTweet.Tweet
+	}
+	return tweetEventMessage{
+		Event: channelTweet.Event,
+		Tweet: channelTweet.Tweet,
+	}
+}
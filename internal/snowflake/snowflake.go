@@ -0,0 +1,80 @@
+// Package snowflake generates time-ordered ids out of a timestamp, the node and a sequence:
+//
+//	0 | 41 bits of milliseconds since Epoch | 10 bits of node | 12 bits of sequence
+//
+// every node generates up to 4096 ids per millisecond for about 69 years after Epoch
+package snowflake
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	nodeBits     = 10
+	sequenceBits = 12
+
+	MaxNode     = 1<<nodeBits - 1
+	maxSequence = 1<<sequenceBits - 1
+
+	timeShift = nodeBits + sequenceBits
+)
+
+// Epoch is the time the ids count from
+var Epoch = time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+type Generator struct {
+	mu       sync.Mutex
+	node     int64
+	last     int64 // milliseconds since Epoch of the last id
+	sequence int64
+
+	now func() time.Time
+}
+
+// NewGenerator creates a generator of the node, every generating node needs its own
+func NewGenerator(node int) (*Generator, error) {
+	if node < 0 || node > MaxNode {
+		return nil, fmt.Errorf("node %d is out of range [0, %d]", node, MaxNode)
+	}
+	return &Generator{node: int64(node), now: time.Now}, nil
+}
+
+// NextID returns an id greater than any id returned before.
+// If the clock goes back, ids keep on counting from the last timestamp
+func (g *Generator) NextID() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	millis := max(g.now().Sub(Epoch).Milliseconds(), g.last)
+	if millis == g.last {
+		g.sequence = (g.sequence + 1) & maxSequence
+		if g.sequence == 0 {
+			// the millisecond is used up, wait for the next one
+			for millis <= g.last {
+				millis = g.now().Sub(Epoch).Milliseconds()
+			}
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.last = millis
+	return millis<<timeShift | g.node<<sequenceBits | g.sequence
+}
+
+// Time is when the id was generated, with millisecond precision
+func Time(id int64) time.Time {
+	return Epoch.Add(time.Duration(id>>timeShift) * time.Millisecond).UTC()
+}
+
+// Node is the node which generated the id
+func Node(id int64) int {
+	return int(id >> sequenceBits & MaxNode)
+}
+
+// FirstID is the smallest id generated at t, every id generated before t is smaller.
+// It turns a time into a cursor, ids before it are older than t
+func FirstID(t time.Time) int64 {
+	return max(t.Sub(Epoch).Milliseconds(), 0) << timeShift
+}
@@ -0,0 +1,107 @@
+package snowflake
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewGeneratorNodeRange(t *testing.T) {
+	_, err := NewGenerator(-1)
+	require.Error(t, err)
+	_, err = NewGenerator(MaxNode + 1)
+	require.Error(t, err)
+	_, err = NewGenerator(MaxNode)
+	require.NoError(t, err)
+}
+
+func TestNextID(t *testing.T) {
+	g, err := NewGenerator(7)
+	require.NoError(t, err)
+	now := time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)
+	g.now = func() time.Time { return now }
+
+	first := g.NextID()
+	second := g.NextID()
+	require.Greater(t, second, first)
+	require.Equal(t, now, Time(first))
+	require.Equal(t, now, Time(second))
+	require.Equal(t, 7, Node(first))
+
+	// clock went back, ids still grow and keep the last time
+	now = now.Add(-time.Second)
+	third := g.NextID()
+	require.Greater(t, third, second)
+	require.Equal(t, now.Add(time.Second), Time(third))
+
+	now = now.Add(time.Minute)
+	fourth := g.NextID()
+	require.Greater(t, fourth, third)
+	require.Equal(t, now, Time(fourth))
+}
+
+func TestNextIDSequenceOverflow(t *testing.T) {
+	g, err := NewGenerator(1)
+	require.NoError(t, err)
+	now := time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)
+	calls := 0
+	g.now = func() time.Time {
+		// the clock moves only while the generator waits for the next millisecond
+		calls++
+		if calls > maxSequence+2 {
+			return now.Add(time.Millisecond)
+		}
+		return now
+	}
+
+	var last int64
+	for range maxSequence + 1 {
+		id := g.NextID()
+		require.Greater(t, id, last)
+		require.Equal(t, now, Time(id))
+		last = id
+	}
+	id := g.NextID()
+	require.Greater(t, id, last)
+	require.Equal(t, now.Add(time.Millisecond), Time(id))
+}
+
+func TestNextIDConcurrent(t *testing.T) {
+	g, err := NewGenerator(1)
+	require.NoError(t, err)
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		seen = map[int64]bool{}
+	)
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 1000 {
+				id := g.NextID()
+				mu.Lock()
+				seen[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	require.Len(t, seen, 8000)
+}
+
+func TestFirstID(t *testing.T) {
+	g, err := NewGenerator(MaxNode)
+	require.NoError(t, err)
+	now := time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)
+	g.now = func() time.Time { return now }
+
+	id := g.NextID()
+	require.LessOrEqual(t, FirstID(now), id)
+	require.Less(t, id, FirstID(now.Add(time.Millisecond)))
+	require.Equal(t, now, Time(FirstID(now)))
+	require.Zero(t, FirstID(Epoch.Add(-time.Hour)))
+}